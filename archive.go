@@ -0,0 +1,88 @@
+package sivafs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var ErrUnsafePath = errors.New("unsafe path")
+
+// safePath returns the clean version of the given slash separated path, or
+// an ErrUnsafePath error if siva would change it to make it safe, that is, if
+// it is absolute or points outside of the root.
+func safePath(op, name string) (string, error) {
+	clean := path.Clean(name)
+	if clean == "." || siva.ToSafePath(name) != clean {
+		return "", &os.PathError{Op: op, Path: name, Err: ErrUnsafePath}
+	}
+
+	return clean, nil
+}
+
+// isRoot returns true if the slash separated path names the root directory,
+// like the "./" entry of archives made from a directory with ".".
+func isRoot(name string) bool {
+	return path.Clean(name) == "."
+}
+
+// createWithHeader creates a file in fs using the name, mode and modification
+// time of the header. Filesystems not implementing SivaCreateHeader get the
+// current modification time.
+func createWithHeader(fs billy.Basic, h *siva.Header) (billy.File, error) {
+	if c, ok := fs.(SivaCreateHeader); ok {
+		return c.CreateHeader(h)
+	}
+
+	return fs.OpenFile(h.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, h.Mode)
+}
+
+// walkFunc is called by walk for every file and directory.
+type walkFunc func(name string, fi os.FileInfo) error
+
+// walk calls fn for every directory and file under dir, parents before their
+// children and each directory contents in name order. Names pointing outside
+// of their directory, only found in filesystems with the UnsafePaths option,
+// are rejected with ErrUnsafePath.
+func walk(fs billy.Filesystem, dir string, fn walkFunc) error {
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// siva files can hold a file and a directory with the same name, the
+	// file goes first.
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Name() == files[j].Name() {
+			return !files[i].IsDir() && files[j].IsDir()
+		}
+
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, fi := range files {
+		if n := fi.Name(); n == "." || n == ".." || strings.Contains(n, "/") {
+			return &os.PathError{Op: "walk", Path: dir + "/" + n, Err: ErrUnsafePath}
+		}
+
+		name := path.Join(dir, fi.Name())
+		if err := fn(name, fi); err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			continue
+		}
+
+		if err := walk(fs, name, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
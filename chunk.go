@@ -0,0 +1,405 @@
+package sivafs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var ErrInvalidChunks = errors.New("invalid chunked file")
+
+var (
+	errWhence = errors.New("seek: invalid whence")
+	errOffset = errors.New("seek: invalid offset")
+)
+
+const (
+	// chunkSize is the size of the contents of every chunk written, except
+	// for the last one.
+	chunkSize = 64 * 1024
+	// chunkFooterSize is the size of the footer of chunked files: the chunk
+	// size, the size of the contents and the number of chunks.
+	chunkFooterSize = 16
+)
+
+// Chunked files store their contents split in chunks of chunkSize bytes,
+// each of them encoded independently so they can be read in any order. There
+// is always a last chunk, empty for empty files, so codecs authenticating it
+// detect files truncated to nothing. The encoded chunks are preceded by an
+// optional prefix used by the codec, and followed by a table with the stored
+// size of each chunk and a footer:
+//
+//	prefix | chunk 0 ... chunk N-1 | size 0 (4 bytes) ... size N-1 | footer
+//
+// All integers are big endian.
+
+// chunkCodec encodes and decodes the chunks of chunked files. i is the
+// number of the chunk in the file and last tells if it is the last one.
+type chunkCodec interface {
+	encode(dst, chunk []byte, i int, last bool) ([]byte, error)
+	decode(dst, stored []byte, i int, last bool) ([]byte, error)
+}
+
+// codecFunc returns the codec of a chunked file given its prefix.
+type codecFunc func(prefix []byte) (chunkCodec, error)
+
+// chainCodec encodes chunks with every codec in order, and decodes them in
+// reverse order.
+type chainCodec []chunkCodec
+
+func (c chainCodec) encode(dst, chunk []byte, i int, last bool) ([]byte, error) {
+	var err error
+	for j, codec := range c {
+		var buf []byte
+		if j == len(c)-1 {
+			buf = dst
+		}
+
+		chunk, err = codec.encode(buf, chunk, i, last)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chunk, nil
+}
+
+func (c chainCodec) decode(dst, stored []byte, i int, last bool) ([]byte, error) {
+	var err error
+	for j := len(c) - 1; j >= 0; j-- {
+		var buf []byte
+		if j == 0 {
+			buf = dst
+		}
+
+		stored, err = c[j].decode(buf, stored, i, last)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+// isChunked returns true if the entry flags tell that it is a chunked file.
+func isChunked(flags siva.Flag) bool {
+	return flags&(flagCompressionMask|flagEncrypted) != 0
+}
+
+type chunkFooter struct {
+	ChunkSize uint32
+	Size      uint64
+	Count     uint32
+}
+
+// readChunkFooter reads the footer of a chunked file with the given stored
+// size.
+func readChunkFooter(r io.ReaderAt, size int64) (*chunkFooter, error) {
+	if size < chunkFooterSize {
+		return nil, ErrInvalidChunks
+	}
+
+	var buf [chunkFooterSize]byte
+	if _, err := r.ReadAt(buf[:], size-chunkFooterSize); err != nil {
+		return nil, err
+	}
+
+	f := &chunkFooter{
+		ChunkSize: binary.BigEndian.Uint32(buf[0:4]),
+		Size:      binary.BigEndian.Uint64(buf[4:12]),
+		Count:     binary.BigEndian.Uint32(buf[12:16]),
+	}
+
+	count := (f.Size + uint64(f.ChunkSize) - 1) / uint64(f.ChunkSize)
+	if count == 0 {
+		count = 1
+	}
+
+	if f.ChunkSize == 0 || uint64(f.Count) != count ||
+		int64(f.Count)*4 > size-chunkFooterSize {
+		return nil, ErrInvalidChunks
+	}
+
+	return f, nil
+}
+
+// chunkWriter writes a chunked file to w.
+type chunkWriter struct {
+	w       io.Writer
+	prefix  []byte
+	codec   chunkCodec
+	buf     []byte
+	encoded []byte
+	size    uint64
+	sizes   []uint32
+}
+
+func newChunkWriter(w io.Writer, prefix []byte, codec chunkCodec) *chunkWriter {
+	return &chunkWriter{
+		w:      w,
+		prefix: prefix,
+		codec:  codec,
+		buf:    make([]byte, 0, chunkSize),
+	}
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		// full chunks are written once more data arrives, so the last chunk
+		// is only known on Close.
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+
+		free := chunkSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+		n += free
+	}
+
+	return n, nil
+}
+
+func (w *chunkWriter) writePrefix() error {
+	if w.prefix == nil {
+		return nil
+	}
+
+	if _, err := w.w.Write(w.prefix); err != nil {
+		return err
+	}
+
+	w.prefix = nil
+	return nil
+}
+
+func (w *chunkWriter) flush(last bool) error {
+	if err := w.writePrefix(); err != nil {
+		return err
+	}
+
+	var err error
+	w.encoded, err = w.codec.encode(w.encoded[:0], w.buf, len(w.sizes), last)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.w.Write(w.encoded); err != nil {
+		return err
+	}
+
+	w.size += uint64(len(w.buf))
+	w.sizes = append(w.sizes, uint32(len(w.encoded)))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the last chunk, the table and the footer. It does not close
+// the underlying writer.
+func (w *chunkWriter) Close() error {
+	if len(w.buf) > 0 || len(w.sizes) == 0 {
+		if err := w.flush(true); err != nil {
+			return err
+		}
+	}
+
+	if err := w.writePrefix(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4*len(w.sizes)+chunkFooterSize)
+	for i, s := range w.sizes {
+		binary.BigEndian.PutUint32(buf[4*i:], s)
+	}
+
+	footer := buf[4*len(w.sizes):]
+	binary.BigEndian.PutUint32(footer[0:4], chunkSize)
+	binary.BigEndian.PutUint64(footer[4:12], w.size)
+	binary.BigEndian.PutUint32(footer[12:16], uint32(len(w.sizes)))
+
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// chunkReader reads the contents of a chunked file.
+type chunkReader struct {
+	r      io.ReaderAt
+	codec  chunkCodec
+	footer *chunkFooter
+	// offsets holds the offset of every chunk and the end of the last one.
+	offsets []int64
+
+	mu sync.Mutex
+	// current is the number of the chunk in buf, -1 if none.
+	current int
+	buf     []byte
+	stored  []byte
+	pos     int64
+}
+
+// newChunkReader returns a reader of the chunked file stored in the first
+// size bytes of r. The codec is returned by fn.
+func newChunkReader(r io.ReaderAt, size int64, fn codecFunc) (*chunkReader, error) {
+	footer, err := readChunkFooter(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	table := make([]byte, 4*int(footer.Count))
+	tableStart := size - chunkFooterSize - int64(len(table))
+	if _, err := r.ReadAt(table, tableStart); err != nil {
+		return nil, err
+	}
+
+	var stored int64
+	for i := 0; i < int(footer.Count); i++ {
+		stored += int64(binary.BigEndian.Uint32(table[4*i:]))
+	}
+
+	if stored > tableStart {
+		return nil, ErrInvalidChunks
+	}
+
+	prefix := make([]byte, tableStart-stored)
+	if _, err := r.ReadAt(prefix, 0); err != nil {
+		return nil, err
+	}
+
+	codec, err := fn(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, footer.Count+1)
+	offsets[0] = int64(len(prefix))
+	for i := 0; i < int(footer.Count); i++ {
+		offsets[i+1] = offsets[i] + int64(binary.BigEndian.Uint32(table[4*i:]))
+	}
+
+	cr := &chunkReader{
+		r:       r,
+		codec:   codec,
+		footer:  footer,
+		offsets: offsets,
+		current: -1,
+	}
+
+	// the empty last chunk of empty files is never read, it is decoded here
+	// so the codec checks it.
+	if footer.Size == 0 {
+		if err := cr.load(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return cr, nil
+}
+
+// Size returns the size of the contents of the file.
+func (r *chunkReader) Size() int64 {
+	return int64(r.footer.Size)
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (r *chunkReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errOffset
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for n < len(p) {
+		if off >= r.Size() {
+			return n, io.EOF
+		}
+
+		i := int(off / int64(r.footer.ChunkSize))
+		if err := r.load(i); err != nil {
+			return n, err
+		}
+
+		start := off - int64(i)*int64(r.footer.ChunkSize)
+		c := copy(p[n:], r.buf[start:])
+		n += c
+		off += int64(c)
+	}
+
+	return n, nil
+}
+
+// load decodes the chunk i into buf.
+func (r *chunkReader) load(i int) error {
+	if r.current == i {
+		return nil
+	}
+
+	size := r.offsets[i+1] - r.offsets[i]
+	if int64(cap(r.stored)) < size {
+		r.stored = make([]byte, size)
+	}
+
+	r.stored = r.stored[:size]
+	if _, err := r.r.ReadAt(r.stored, r.offsets[i]); err != nil {
+		return err
+	}
+
+	r.current = -1
+	last := i == int(r.footer.Count)-1
+	buf, err := r.codec.decode(r.buf[:0], r.stored, i, last)
+	if err != nil {
+		return err
+	}
+
+	expected := int64(r.footer.ChunkSize)
+	if rest := r.Size() - int64(i)*expected; rest < expected {
+		expected = rest
+	}
+
+	if int64(len(buf)) != expected {
+		return ErrInvalidChunks
+	}
+
+	r.buf = buf
+	r.current = i
+	return nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, errWhence
+	}
+
+	if offset < 0 {
+		return 0, errOffset
+	}
+
+	r.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func runLs(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	offset := offsetFlag(flags)
+	long := flags.Bool("l", false, "show mode, size and modification time")
+	recursive := flags.Bool("r", false, "list subdirectories recursively")
+	args, err := parse(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadOnly(args[0], *offset)
+	if err != nil {
+		return err
+	}
+
+	dir := ""
+	if len(args) > 1 {
+		dir = cleanPath(args[1])
+	}
+
+	return list(fs, dir, *recursive, func(name string, fi os.FileInfo) {
+		if fi.IsDir() {
+			name += "/"
+		}
+
+		if *long {
+			fmt.Fprintf(stdout, "%v %10d %s %s\n", fi.Mode(), fi.Size(),
+				fi.ModTime().Format(time.RFC3339), name)
+		} else {
+			fmt.Fprintln(stdout, name)
+		}
+	})
+}
+
+// list calls fn with the files of the directory, sorted by name.
+func list(fs billy.Filesystem, dir string, recursive bool, fn func(string, os.FileInfo)) error {
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, fi := range files {
+		name := path.Join(dir, fi.Name())
+		fn(name, fi)
+		if recursive && fi.IsDir() {
+			if err := list(fs, name, recursive, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func runStat(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	offset := offsetFlag(flags)
+	args, err := parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadOnly(args[0], *offset)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fs.Stat(cleanPath(args[1]))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "name:     %s\n", cleanPath(args[1]))
+	fmt.Fprintf(stdout, "size:     %d\n", fi.Size())
+	fmt.Fprintf(stdout, "mode:     %v\n", fi.Mode())
+	fmt.Fprintf(stdout, "modified: %s\n", fi.ModTime().Format(time.RFC3339))
+
+	info, ok := fi.Sys().(*sivafs.EntryInfo)
+	if !ok || info == nil {
+		return nil
+	}
+
+	if info.Hash != 0 {
+		fmt.Fprintf(stdout, "hash:     %v %x\n", info.Hash, info.Sum)
+	}
+
+	keys := make([]string, 0, len(info.Meta))
+	for k := range info.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(stdout, "meta:     %s=%s\n", k, info.Meta[k])
+	}
+
+	return nil
+}
+
+func runCat(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	offset := offsetFlag(flags)
+	args, err := parse(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadOnly(args[0], *offset)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args[1:] {
+		f, err := fs.Open(cleanPath(name))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(stdout, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runPut(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	args, err := parse(flags, args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	name := path.Base(args[1])
+	if len(args) > 2 {
+		name = cleanPath(args[2])
+	}
+
+	src, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fs, err := openReadWrite(args[0])
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return fs.Sync()
+}
+
+func runRm(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	args, err := parse(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadWrite(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args[1:] {
+		if err := fs.Remove(cleanPath(name)); err != nil {
+			return err
+		}
+	}
+
+	return fs.Sync()
+}
+
+func runMkdir(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	args, err := parse(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadWrite(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args[1:] {
+		if err := fs.MkdirAll(cleanPath(name), 0755); err != nil {
+			return err
+		}
+	}
+
+	return fs.Sync()
+}
+
+func runHistory(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	offset := offsetFlag(flags)
+	args, err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadOnly(args[0], *offset)
+	if err != nil {
+		return err
+	}
+
+	history, err := fs.(sivafs.SivaHistory).History()
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := fs.(sivafs.SivaSnapshot).Snapshots()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[uint64][]string)
+	for _, s := range snapshots {
+		names[s.Offset] = append(names[s.Offset], s.Name)
+	}
+
+	for _, r := range history {
+		fmt.Fprintf(stdout, "%d", r.Offset)
+		if len(names[r.Offset]) > 0 {
+			fmt.Fprintf(stdout, " (%s)", strings.Join(names[r.Offset], ", "))
+		}
+		fmt.Fprintln(stdout)
+
+		for _, name := range r.Written {
+			fmt.Fprintf(stdout, "\t+ %s\n", name)
+		}
+
+		for _, name := range r.Deleted {
+			fmt.Fprintf(stdout, "\t- %s\n", name)
+		}
+	}
+
+	return nil
+}
+
+func runDiff(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	args, err := parse(flags, args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	var offsets [2]uint64
+	for i, arg := range args[1:] {
+		if offsets[i], err = strconv.ParseUint(arg, 10, 64); err != nil {
+			return errUsage
+		}
+	}
+
+	from, err := openReadOnly(args[0], offsets[0])
+	if err != nil {
+		return err
+	}
+
+	to, err := openReadOnly(args[0], offsets[1])
+	if err != nil {
+		return err
+	}
+
+	old, err := files(from)
+	if err != nil {
+		return err
+	}
+
+	current, err := files(to)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			lines = append(lines, "D "+name)
+		}
+	}
+
+	for name, fi := range current {
+		oldFi, ok := old[name]
+		if !ok {
+			lines = append(lines, "A "+name)
+			continue
+		}
+
+		changed, err := modified(from, to, name, oldFi, fi)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			lines = append(lines, "M "+name)
+		}
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i][2:] < lines[j][2:]
+	})
+
+	for _, line := range lines {
+		fmt.Fprintln(stdout, line)
+	}
+
+	return nil
+}
+
+// files returns the files of the filesystem by path.
+func files(fs billy.Filesystem) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	err := list(fs, "", true, func(name string, fi os.FileInfo) {
+		if !fi.IsDir() {
+			files[name] = fi
+		}
+	})
+
+	return files, err
+}
+
+// modified returns true if the file differs between both filesystems.
+func modified(a, b billy.Filesystem, name string, fa, fb os.FileInfo) (bool, error) {
+	if fa.Size() != fb.Size() || fa.Mode() != fb.Mode() {
+		return true, nil
+	}
+
+	ca, err := readFile(a, name)
+	if err != nil {
+		return false, err
+	}
+
+	cb, err := readFile(b, name)
+	if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(ca, cb), nil
+}
+
+func readFile(fs billy.Filesystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+func runExtract(flags *flag.FlagSet, args []string, stdout io.Writer) error {
+	offset := offsetFlag(flags)
+	args, err := parse(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	fs, err := openReadOnly(args[0], *offset)
+	if err != nil {
+		return err
+	}
+
+	return sivafs.UnpackWithOptions(fs, osfs.New(args[1]), "", sivafs.PackOptions{
+		Include: args[2:],
+	})
+}
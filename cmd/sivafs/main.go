@@ -0,0 +1,178 @@
+// Command sivafs inspects and manipulates siva files.
+//
+// Usage:
+//
+//	sivafs <command> [flags] <siva file> [arguments]
+//
+// Commands reading the siva file accept --offset to use the index at the
+// given offset instead of the last one, as listed by the history command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+type command struct {
+	usage       string
+	description string
+	run         func(flags *flag.FlagSet, args []string, stdout io.Writer) error
+}
+
+var commands = map[string]*command{
+	"ls": {
+		"[--offset N] [-l] [-r] <siva file> [dir]",
+		"list the files of a directory",
+		runLs,
+	},
+	"stat": {
+		"[--offset N] <siva file> <path>",
+		"show the details of a file",
+		runStat,
+	},
+	"cat": {
+		"[--offset N] <siva file> <path>...",
+		"print the contents of files",
+		runCat,
+	},
+	"put": {
+		"<siva file> <local file> [path]",
+		"write a local file into the siva file",
+		runPut,
+	},
+	"rm": {
+		"<siva file> <path>...",
+		"remove files",
+		runRm,
+	},
+	"mkdir": {
+		"<siva file> <path>...",
+		"check directories can be created, siva files have no empty directories",
+		runMkdir,
+	},
+	"history": {
+		"[--offset N] <siva file>",
+		"list the revisions of the siva file with their changes",
+		runHistory,
+	},
+	"diff": {
+		"<siva file> <offset> [offset]",
+		"list the changes between two revisions, the last one by default",
+		runDiff,
+	},
+	"extract": {
+		"[--offset N] <siva file> <dir> [pattern]...",
+		"extract the files matching the patterns, all by default, to a directory",
+		runExtract,
+	},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != errUsage && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "sivafs:", err)
+		}
+
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(stderr)
+		return errUsage
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: sivafs %s %s\n", args[0], cmd.usage)
+		flags.PrintDefaults()
+	}
+
+	err := cmd.run(flags, args[1:], stdout)
+	if err == errUsage {
+		flags.Usage()
+	}
+
+	return err
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: sivafs <command> [flags] <siva file> [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].description)
+	}
+}
+
+// parse parses the flags of the command, checking it got at least min and at
+// most max arguments. A negative max means no limit.
+func parse(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+
+		return nil, errUsage
+	}
+
+	n := flags.NArg()
+	if n < min || (max >= 0 && n > max) {
+		return nil, errUsage
+	}
+
+	return flags.Args(), nil
+}
+
+func offsetFlag(flags *flag.FlagSet) *uint64 {
+	return flags.Uint64("offset", 0, "offset of the index to use, the last one if 0")
+}
+
+// openReadOnly opens the siva file at the given path read only, using the
+// index at the given offset.
+func openReadOnly(name string, offset uint64) (sivafs.SivaFS, error) {
+	dir, base := filepath.Split(name)
+	if _, err := os.Stat(name); err != nil {
+		return nil, err
+	}
+
+	return sivafs.NewFilesystemReadOnly(osfs.New(dir), base, offset)
+}
+
+// openReadWrite opens the siva file at the given path, creating it if it does
+// not exist.
+func openReadWrite(name string) (sivafs.SivaFS, error) {
+	dir, base := filepath.Split(name)
+	return sivafs.NewFilesystem(osfs.New(dir), base, memfs.New())
+}
+
+// cleanPath returns the path relative to the root of the siva file.
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CommandSuite struct {
+	dir  string
+	siva string
+}
+
+var _ = Suite(&CommandSuite{})
+
+func (s *CommandSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.siva = filepath.Join(s.dir, "test.siva")
+}
+
+func (s *CommandSuite) run(c *C, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func (s *CommandSuite) exec(c *C, args ...string) string {
+	out, err := s.run(c, args...)
+	c.Assert(err, IsNil, Commentf("%v", args))
+	return out
+}
+
+func (s *CommandSuite) put(c *C, name, content string) {
+	local := filepath.Join(s.dir, "local")
+	c.Assert(ioutil.WriteFile(local, []byte(content), 0644), IsNil)
+	s.exec(c, "put", s.siva, local, name)
+}
+
+// offsets returns the offsets of the revisions listed by history.
+func (s *CommandSuite) offsets(c *C) []string {
+	var offsets []string
+	for _, line := range strings.Split(s.exec(c, "history", s.siva), "\n") {
+		if line != "" && !strings.HasPrefix(line, "\t") {
+			offsets = append(offsets, line)
+		}
+	}
+
+	return offsets
+}
+
+func (s *CommandSuite) TestCommands(c *C) {
+	s.put(c, "one", "one")
+	s.put(c, "dir/two", "two")
+	c.Assert(s.exec(c, "ls", s.siva), Equals, "dir/\none\n")
+	c.Assert(s.exec(c, "ls", "-r", s.siva), Equals, "dir/\ndir/two\none\n")
+	c.Assert(s.exec(c, "ls", s.siva, "dir"), Equals, "dir/two\n")
+	c.Assert(s.exec(c, "cat", s.siva, "one", "/dir/two"), Equals, "onetwo")
+	c.Assert(s.exec(c, "stat", s.siva, "dir/two"), Matches,
+		"(?s)name: +dir/two\nsize: +3\n.*")
+
+	s.exec(c, "mkdir", s.siva, "other")
+	_, err := s.run(c, "mkdir", s.siva, "one")
+	c.Assert(err, NotNil)
+
+	s.exec(c, "rm", s.siva, "one")
+	c.Assert(s.exec(c, "ls", "-r", s.siva), Equals, "dir/\ndir/two\n")
+
+	extracted := filepath.Join(s.dir, "extracted")
+	s.exec(c, "extract", s.siva, extracted)
+	data, err := ioutil.ReadFile(filepath.Join(extracted, "dir", "two"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "two")
+}
+
+func (s *CommandSuite) TestExtractSymlinkSlip(c *C) {
+	fs, err := sivafs.NewFilesystem(osfs.New(s.dir), "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	f, err := fs.(sivafs.SivaCreateHeader).CreateHeader(&siva.Header{
+		Name: "dir",
+		Mode: os.ModeSymlink | 0777,
+	})
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("../outside"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+	s.put(c, "dir/x", "x")
+
+	outside := filepath.Join(s.dir, "outside")
+	c.Assert(os.Mkdir(outside, 0755), IsNil)
+
+	_, err = s.run(c, "extract", s.siva, filepath.Join(s.dir, "extracted"))
+	c.Assert(err, NotNil)
+
+	_, err = os.Stat(filepath.Join(outside, "x"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *CommandSuite) TestHistory(c *C) {
+	s.put(c, "one", "one")
+	s.put(c, "two", "two")
+	s.put(c, "one", "new one")
+	s.exec(c, "rm", s.siva, "two")
+
+	offsets := s.offsets(c)
+	c.Assert(offsets, HasLen, 4)
+	c.Assert(s.exec(c, "history", s.siva), Equals, offsets[0]+"\n\t+ one\n"+
+		offsets[1]+"\n\t+ two\n"+
+		offsets[2]+"\n\t+ one\n"+
+		offsets[3]+"\n\t- two\n")
+
+	c.Assert(s.exec(c, "cat", "--offset", offsets[1], s.siva, "one"), Equals, "one")
+	c.Assert(s.exec(c, "ls", "--offset", offsets[1], s.siva), Equals, "one\ntwo\n")
+
+	c.Assert(s.exec(c, "diff", s.siva, offsets[1]), Equals, "M one\nD two\n")
+	c.Assert(s.exec(c, "diff", s.siva, offsets[0], offsets[2]), Equals, "M one\nA two\n")
+}
+
+func (s *CommandSuite) TestErrors(c *C) {
+	_, err := s.run(c)
+	c.Assert(err, Equals, errUsage)
+	_, err = s.run(c, "unknown")
+	c.Assert(err, Equals, errUsage)
+	_, err = s.run(c, "cat", s.siva)
+	c.Assert(err, Equals, errUsage)
+	_, err = s.run(c, "diff", s.siva, "foo")
+	c.Assert(err, Equals, errUsage)
+
+	_, err = s.run(c, "ls", s.siva)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
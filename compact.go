@@ -0,0 +1,405 @@
+package sivafs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrUnsignedCompaction  = errors.New("signed siva file cannot be compacted without signing key")
+	ErrCompactionOpenFiles = errors.New("siva file cannot be compacted while files read from it are open")
+)
+
+// compactSuffix is appended to the path of the siva file to name the file
+// where it is compacted.
+const compactSuffix = ".compact"
+
+// SivaCompact is implemented by siva filesystems able to reclaim the space
+// of superseded and deleted entries.
+type SivaCompact interface {
+	// Compact syncs the filesystem and rewrites the siva file in a single
+	// index block holding only the live entries. Files read before keep
+	// reading the old siva file until they are closed, except on Windows,
+	// where files cannot be replaced while open and ErrCompactionOpenFiles
+	// is returned instead. Signatures are made again with the SigningKey
+	// option, and ErrUnsignedCompaction is returned for signed siva files
+	// without it. History is lost: only the snapshots of the current state
+	// are kept, and the offsets returned before, such as the ones of
+	// Snapshot, Revision and BlockSignature, are no longer valid.
+	Compact() error
+}
+
+// CompactionPolicy tells when a siva file is compacted, see the Compaction
+// option.
+type CompactionPolicy struct {
+	// MinFragmentation is the ratio of the contents held by superseded and
+	// deleted entries, as returned by Stats.Fragmentation, from which the
+	// siva file is compacted.
+	MinFragmentation float64
+	// MinSize is the size the siva file must reach to be compacted.
+	MinSize uint64
+	// KeepTombstones keeps the entries marking deletions, which are
+	// otherwise dropped. It must be set for siva files used as upper layers
+	// of a union, so their deletions keep hiding the files of the lower
+	// ones.
+	KeepTombstones bool
+}
+
+// tombstoneCompacter is implemented by filesystems able to compact keeping
+// tombstones, see union.Compact.
+type tombstoneCompacter interface {
+	compact(keepTombstones bool) error
+}
+
+// matches returns true if a siva file with the given statistics has to be
+// compacted.
+func (p *CompactionPolicy) matches(s *Stats) bool {
+	return s.DeadBytes > 0 &&
+		s.Size >= p.MinSize &&
+		s.Fragmentation() >= p.MinFragmentation
+}
+
+// compactIfNeeded compacts the siva file if it matches the Compaction option.
+// Signed siva files that cannot be signed again, and siva files that cannot
+// be replaced yet, are left as they are.
+func (fs *sivaFS) compactIfNeeded() error {
+	stats, err := fs.Stats()
+	if err != nil {
+		return err
+	}
+
+	policy := fs.options.Compaction
+	if !policy.matches(stats) {
+		return nil
+	}
+
+	err = fs.compact(policy.KeepTombstones)
+	if err == ErrUnsignedCompaction || err == ErrCompactionOpenFiles {
+		return nil
+	}
+
+	return err
+}
+
+// Compact implements SivaCompact interface. The compacted siva file is
+// written next to it, in the underlying filesystem, and renamed over it.
+// Tombstones are dropped unless the Compaction option keeps them.
+func (fs *sivaFS) Compact() error {
+	p := fs.options.Compaction
+	return fs.compact(p != nil && p.KeepTombstones)
+}
+
+func (fs *sivaFS) compact(keepTombstones bool) error {
+	if fs.options.ReadOnly {
+		return ErrReadOnlyFilesystem
+	}
+
+	if fs.fileWriteModeOpen {
+		return ErrFileWriteModeAlreadyOpen
+	}
+
+	if !renameOpenFiles && atomic.LoadInt64(&fs.reading) > 0 {
+		return ErrCompactionOpenFiles
+	}
+
+	if err := fs.ensureClosed(); err != nil {
+		return err
+	}
+
+	if err := fs.ensureOpen(); err != nil {
+		return err
+	}
+
+	tmp := fs.path + compactSuffix
+	if err := fs.writeCompacted(tmp, keepTombstones); err != nil {
+		fs.underlying.Remove(tmp)
+		return err
+	}
+
+	if err := fs.ensureClosed(); err != nil {
+		fs.underlying.Remove(tmp)
+		return err
+	}
+
+	if err := fs.underlying.Rename(tmp, fs.path); err != nil {
+		fs.underlying.Remove(tmp)
+		return err
+	}
+
+	fs.stats.mu.Lock()
+	fs.stats.reset()
+	fs.stats.mu.Unlock()
+	fs.sums.reset()
+	return nil
+}
+
+// writeCompacted writes the live entries of the siva file to a new siva file
+// with the given path.
+func (fs *sivaFS) writeCompacted(path string, keepTombstones bool) error {
+	blocks, err := readIndexBlocks(fs.f, fs.end)
+	if err != nil {
+		return err
+	}
+
+	signed := false
+	for _, b := range blocks {
+		signed = signed || isSignatureBlock(b)
+	}
+
+	if signed && fs.options.SigningKey == nil {
+		return ErrUnsignedCompaction
+	}
+
+	entries, err := fs.compactedEntries(blocks, keepTombstones)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.underlying.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.copyEntries(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// copyEntries writes the stored bytes of the entries in a single index block,
+// signed if the SigningKey option is set. Sidecars are written again with the
+// new offsets of their files, which must be copied before them. Tombstones
+// must be copied first, as siva only deletes names missing from its index
+// while it is empty.
+func (fs *sivaFS) copyEntries(f billy.File, entries []blockEntry) error {
+	w := siva.NewWriter(f)
+	// offsets holds the offsets of the entries copied by name.
+	offsets := make(map[string]int64)
+	var offset int64
+	for _, e := range entries {
+		if err := fs.ctx.Err(); err != nil {
+			return err
+		}
+
+		deleted := e.Flags&siva.FlagDeleted != 0
+		if !deleted && strings.HasPrefix(e.Name, metaDir+"/") {
+			n, err := fs.copySidecar(w, e, offsets)
+			if err != nil {
+				return err
+			}
+
+			offset += n
+			continue
+		}
+
+		err := w.WriteHeader(&siva.Header{
+			Name:    e.Name,
+			ModTime: e.ModTime,
+			Mode:    e.Mode,
+			Flags:   e.Flags,
+		})
+		if err != nil {
+			return err
+		}
+
+		r := io.NewSectionReader(fs.f, int64(e.Block.Offset(e.IndexEntry)), int64(e.Size))
+		n, err := copyContext(fs.ctx, w, r)
+		if err != nil {
+			return err
+		}
+
+		offsets[e.Name] = offset
+		offset += n
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if len(entries) == 0 || fs.options.SigningKey == nil {
+		return nil
+	}
+
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	return fs.sign(f, f, uint64(end), nil)
+}
+
+// copySidecar writes the sidecar entry with the offset its file has in
+// offsets, encoded as new files are. It returns the number of bytes written.
+func (fs *sivaFS) copySidecar(w siva.Writer, e blockEntry, offsets map[string]int64) (int64, error) {
+	data, err := fs.readBlockEntry(e)
+	if err != nil {
+		return 0, err
+	}
+
+	s := &sidecar{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return 0, err
+	}
+
+	if s.Offset != nil {
+		offset := offsets[strings.TrimPrefix(e.Name, metaDir+"/")]
+		s.Offset = &offset
+	}
+
+	if data, err = json.Marshal(s); err != nil {
+		return 0, err
+	}
+
+	header := &siva.Header{Name: e.Name, ModTime: e.ModTime, Mode: e.Mode}
+	codec, prefix, flags, err := fs.newCodec(e.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	// sidecars are small, so they are encoded in memory to know their size.
+	var buf bytes.Buffer
+	if codec == nil {
+		buf.Write(data)
+	} else {
+		header.Flags |= flags
+		cw := newChunkWriter(&buf, prefix, codec)
+		if _, err := cw.Write(data); err != nil {
+			return 0, err
+		}
+
+		if err := cw.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.WriteHeader(header); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w, &buf)
+}
+
+// compactedEntries returns the entries kept by a compaction: the tombstones,
+// if kept, the live ones, except blobs no longer referenced and sidecars of
+// files and blobs no longer live, and the snapshots of the current state.
+func (fs *sivaFS) compactedEntries(blocks []*indexBlock, keepTombstones bool) ([]blockEntry, error) {
+	latest := latestEntries(blocks)
+	referenced := make(map[string]bool)
+	for _, e := range latest {
+		if e.Flags&siva.FlagDeleted != 0 || e.Flags&flagReference == 0 {
+			continue
+		}
+
+		id, err := fs.readBlockEntry(e)
+		if err != nil {
+			return nil, err
+		}
+
+		referenced[blobPath(string(id))] = true
+	}
+
+	names := make([]string, 0, len(latest))
+	for name := range latest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// tombstones are copied before and sidecars after all the files, see
+	// copyEntries.
+	var tombstones, entries, sidecars []blockEntry
+	for _, name := range names {
+		e := latest[name]
+		deleted := e.Flags&siva.FlagDeleted != 0
+		plain := e.Flags&flagEncryptedName == 0
+		switch {
+		case name == signaturePath || name == snapshotPath:
+			continue
+		case deleted && (!keepTombstones || plain && isReserved(name)):
+			continue
+		case deleted:
+			tombstones = append(tombstones, e)
+			continue
+		case !deleted && strings.HasPrefix(name, blobsDir+"/"):
+			if !referenced[name] {
+				continue
+			}
+		case !deleted && strings.HasPrefix(name, metaDir+"/"):
+			path := strings.TrimPrefix(name, metaDir+"/")
+			file, ok := latest[path]
+			if !ok || file.Flags&siva.FlagDeleted != 0 {
+				continue
+			}
+
+			if strings.HasPrefix(path, blobsDir+"/") && !referenced[path] {
+				continue
+			}
+
+			sidecars = append(sidecars, e)
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	entries = append(append(tombstones, entries...), sidecars...)
+
+	// snapshots written after the last changes name the current state.
+	last := 0
+	for i, b := range blocks {
+		for _, e := range b.Entries {
+			if e.Name != snapshotPath && e.Name != signaturePath {
+				last = i
+				break
+			}
+		}
+	}
+
+	for _, b := range blocks[last:] {
+		for _, e := range b.Entries {
+			if e.Name == snapshotPath {
+				entries = append(entries, blockEntry{e, b})
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// readBlockEntry returns the contents of an entry of an index block.
+func (fs *sivaFS) readBlockEntry(e blockEntry) ([]byte, error) {
+	offset := int64(e.Block.Offset(e.IndexEntry))
+	sr := io.NewSectionReader(fs.f, offset, int64(e.Size))
+	if !isChunked(e.Flags) {
+		return ioutil.ReadAll(sr)
+	}
+
+	entry := e.IndexEntry
+	if e.Flags&flagEncryptedName != 0 {
+		name, err := fs.realName(e.Name, io.NewSectionReader(fs.f, offset, int64(e.Size)))
+		if err != nil {
+			return nil, err
+		}
+
+		copied := *entry
+		copied.Name = name
+		entry = &copied
+	}
+
+	r, err := newChunkReader(sr, sr.Size(), fs.entryCodec(entry))
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
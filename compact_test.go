@@ -0,0 +1,312 @@
+package sivafs
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+type CompactSuite struct {
+	memSuite
+}
+
+var _ = Suite(&CompactSuite{})
+
+func (s *CompactSuite) stats(c *C, fs SivaFS) *Stats {
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	return stats
+}
+
+func (s *CompactSuite) TestCompact(c *C) {
+	o := SivaFSOptions{Hash: crypto.SHA256}
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte(strings.Repeat("1", 10)))
+	writeFile(c, fs, "dir/two", []byte(strings.Repeat("2", 20)))
+	c.Assert(fs.Sync(), IsNil)
+
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Remove("dir/two"), IsNil)
+	c.Assert(fs.(SivaMeta).SetMeta("one", "key", "value"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.stats(c, fs).DeadBytes, Not(Equals), uint64(0))
+
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+	c.Assert(s.mem.Remove("test.siva"+compactSuffix), NotNil)
+
+	for _, fs := range []SivaFS{fs, s.open(c, o)} {
+		stats := s.stats(c, fs)
+		c.Assert(stats.DeadBytes, Equals, uint64(0))
+		c.Assert(stats.Blocks, Equals, 1)
+		c.Assert(stats.Tombstones, Equals, 0)
+		c.Assert(stats.Files, Equals, 1)
+
+		testFileContent(c, fs, "one", "one")
+		_, err := fs.Stat("dir/two")
+		c.Assert(err, NotNil)
+
+		value, err := fs.(SivaMeta).GetMeta("one", "key")
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, "value")
+
+		h, sum, err := fs.(SivaHash).Hash("one")
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, crypto.SHA256)
+		c.Assert(sum, HasLen, crypto.SHA256.Size())
+	}
+
+	writeFile(c, fs, "three", []byte("three"))
+	c.Assert(fs.Sync(), IsNil)
+	testFileContent(c, s.open(c, o), "three", "three")
+}
+
+func (s *CompactSuite) TestEncoded(c *C) {
+	o := SivaFSOptions{
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+		Compression:  Gzip,
+		Dedup:        true,
+	}
+
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte("shared"))
+	writeFile(c, fs, "two", []byte("shared"))
+	writeFile(c, fs, "three", []byte("removed"))
+	c.Assert(fs.Sync(), IsNil)
+
+	c.Assert(fs.Remove("one"), IsNil)
+	c.Assert(fs.Remove("three"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+
+	var blobs int
+	for _, e := range readBlocks(c, s.mem, "test.siva")[0].Entries {
+		if strings.HasPrefix(e.Name, blobsDir+"/") {
+			blobs++
+		}
+	}
+	c.Assert(blobs, Equals, 1)
+
+	fs = s.open(c, o)
+	testFileContent(c, fs, "two", "shared")
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"two"})
+	c.Assert(s.stats(c, fs).Tombstones, Equals, 0)
+}
+
+func (s *CompactSuite) TestKeepTombstones(c *C) {
+	o := SivaFSOptions{Compaction: &CompactionPolicy{
+		MinFragmentation: 1,
+		KeepTombstones:   true,
+	}}
+
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte("one"))
+	writeFile(c, fs, "two", []byte("two"))
+	writeFile(c, fs, "three", []byte("three"))
+	c.Assert(fs.Sync(), IsNil)
+
+	c.Assert(fs.Remove("two"), IsNil)
+	c.Assert(fs.Remove("three"), IsNil)
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+
+	blocks := readBlocks(c, s.mem, "test.siva")
+	c.Assert(blocks, HasLen, 1)
+	c.Assert(blocks[0].Entries[0].Name, Equals, "three")
+	c.Assert(blocks[0].Entries[1].Name, Equals, "two")
+	for _, e := range blocks[0].Entries {
+		if e.Flags&siva.FlagDeleted == 0 {
+			c.Assert(e.Name, Equals, "one")
+		}
+	}
+
+	fs = s.open(c, o)
+	c.Assert(s.stats(c, fs).Tombstones, Equals, 2)
+	deleted, err := fs.(indexedFS).deletedPaths()
+	c.Assert(err, IsNil)
+	c.Assert(deleted, DeepEquals, map[string]bool{"two": true, "three": true})
+	testFileContent(c, fs, "one", "one")
+}
+
+func (s *CompactSuite) TestUnion(c *C) {
+	base := s.openPath(c, "base.siva", SivaFSOptions{})
+	writeFile(c, base, "one", []byte("one"))
+	c.Assert(base.Sync(), IsNil)
+
+	top := s.openPath(c, "top.siva", SivaFSOptions{})
+	u, err := NewUnionWithOptions([]SivaFS{base, top}, UnionOptions{Writable: true})
+	c.Assert(err, IsNil)
+	writeFile(c, u, "two", []byte("two"))
+	c.Assert(u.Remove("one"), IsNil)
+	c.Assert(u.Sync(), IsNil)
+
+	c.Assert(u.(SivaCompact).Compact(), IsNil)
+	_, err = u.Stat("one")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	testFileContent(c, u, "two", "two")
+}
+
+func (s *CompactSuite) TestPolicy(c *C) {
+	fs := s.open(c, SivaFSOptions{Compaction: &CompactionPolicy{
+		MinFragmentation: 0.5,
+		MinSize:          100,
+	}})
+
+	writeFile(c, fs, "one", []byte(strings.Repeat("1", 100)))
+	c.Assert(fs.Sync(), IsNil)
+	writeFile(c, fs, "two", []byte(strings.Repeat("2", 100)))
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.stats(c, fs).Blocks, Equals, 2)
+
+	c.Assert(fs.Remove("two"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	stats := s.stats(c, fs)
+	c.Assert(stats.Blocks, Equals, 1)
+	c.Assert(stats.DeadBytes, Equals, uint64(0))
+	testFileContent(c, fs, "one", "one")
+}
+
+func (s *CompactSuite) TestPolicyMinSize(c *C) {
+	fs := s.open(c, SivaFSOptions{Compaction: &CompactionPolicy{
+		MinFragmentation: 0.5,
+		MinSize:          1 << 30,
+	}})
+
+	writeFile(c, fs, "one", []byte(strings.Repeat("1", 100)))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(fs.Remove("one"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.stats(c, fs).Blocks, Equals, 2)
+}
+
+func (s *CompactSuite) TestOpenFiles(c *C) {
+	if !renameOpenFiles {
+		c.Skip("open files cannot be replaced")
+	}
+
+	for _, underlying := range []billy.Filesystem{memfs.New(), osfs.New(c.MkDir())} {
+		fs, err := NewFilesystem(underlying, "test.siva", memfs.New())
+		c.Assert(err, IsNil)
+		writeFile(c, fs, "one", []byte("one"))
+		writeFile(c, fs, "two", []byte("two"))
+		c.Assert(fs.Sync(), IsNil)
+
+		f, err := fs.Open("one")
+		c.Assert(err, IsNil)
+
+		c.Assert(fs.Remove("two"), IsNil)
+		c.Assert(fs.(SivaCompact).Compact(), IsNil)
+
+		data, err := ioutil.ReadAll(f)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "one")
+		c.Assert(f.Close(), IsNil)
+
+		testFileContent(c, fs, "one", "one")
+	}
+}
+
+func (s *CompactSuite) TestOpenFilesRenameFails(c *C) {
+	if renameOpenFiles {
+		c.Skip("open files can be replaced")
+	}
+
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err := fs.Open("one")
+	c.Assert(err, IsNil)
+	c.Assert(fs.(SivaCompact).Compact(), Equals, ErrCompactionOpenFiles)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+}
+
+func (s *CompactSuite) TestSigned(c *C) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	fs := s.open(c, SivaFSOptions{SigningKey: priv})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+	writeFile(c, fs, "one", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	unsigned := s.open(c, SivaFSOptions{
+		Compaction: &CompactionPolicy{MinFragmentation: 0.1},
+	})
+	c.Assert(unsigned.(SivaCompact).Compact(), Equals, ErrUnsignedCompaction)
+	writeFile(c, unsigned, "two", []byte("two"))
+	c.Assert(unsigned.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{SigningKey: priv})
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+
+	signatures, err := VerifySignatures(s.mem, "test.siva", pub)
+	c.Assert(err, IsNil)
+	c.Assert(signatures, HasLen, 1)
+	c.Assert(signatures[0].Status, Equals, SignatureValid)
+
+	fs = s.open(c, SivaFSOptions{VerifyKey: pub, ReadOnly: true})
+	testFileContent(c, fs, "one", "two")
+	testFileContent(c, fs, "two", "two")
+}
+
+func (s *CompactSuite) TestSnapshots(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.(SivaSnapshot).Snapshot("old", ""), IsNil)
+	writeFile(c, fs, "one", []byte("two"))
+	c.Assert(fs.(SivaSnapshot).Snapshot("current", ""), IsNil)
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+
+	snapshots, err := fs.(SivaSnapshot).Snapshots()
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 1)
+	c.Assert(snapshots[0].Name, Equals, "current")
+
+	ro, err := NewFilesystemSnapshot(s.mem, "test.siva", "current")
+	c.Assert(err, IsNil)
+	testFileContent(c, ro, "one", "two")
+}
+
+func (s *CompactSuite) TestReadOnly(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{ReadOnly: true})
+	c.Assert(fs.(SivaCompact).Compact(), Equals, ErrReadOnlyFilesystem)
+}
+
+func (s *CompactSuite) TestSharded(c *C) {
+	fs, err := NewSharded(s.mem, []string{"0.siva", "1.siva"}, memfs.New())
+	c.Assert(err, IsNil)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(c, fs, name, []byte(name))
+	}
+	c.Assert(fs.Sync(), IsNil)
+
+	for _, name := range []string{"a", "b", "c"} {
+		c.Assert(fs.Remove(name), IsNil)
+	}
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+
+	stats := s.stats(c, fs)
+	c.Assert(stats.DeadBytes, Equals, uint64(0))
+	c.Assert(stats.Files, Equals, 1)
+	testFileContent(c, fs, "d", "d")
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package sivafs
+
+// renameOpenFiles tells whether a file can be replaced while it is open.
+const renameOpenFiles = false
@@ -0,0 +1,130 @@
+package sivafs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression algorithm")
+
+// Compression is the algorithm used to compress the contents of new files.
+type Compression int
+
+const (
+	// NoCompression stores file contents as they are.
+	NoCompression Compression = iota
+	// Gzip compresses file contents with gzip.
+	Gzip
+	// Zstd compresses file contents with Zstandard.
+	Zstd
+	// Snappy compresses file contents with Snappy.
+	Snappy
+)
+
+const (
+	// flagCompressionShift is the position of the compression algorithm in
+	// the flags of siva headers.
+	flagCompressionShift = 8
+	flagCompressionMask  = siva.Flag(0xf) << flagCompressionShift
+)
+
+// flag returns the header flags of files compressed with c.
+func (c Compression) flag() siva.Flag {
+	return siva.Flag(c) << flagCompressionShift
+}
+
+// compressionFromFlags returns the compression algorithm of a file with the
+// given header flags.
+func compressionFromFlags(flags siva.Flag) Compression {
+	return Compression((flags & flagCompressionMask) >> flagCompressionShift)
+}
+
+// compressionCodec returns the codec compressing chunks with c.
+func compressionCodec(c Compression) (chunkCodec, error) {
+	switch c {
+	case Gzip:
+		return gzipCodec{}, nil
+	case Zstd:
+		return zstdCodec{}, nil
+	case Snappy:
+		return snappyCodec{}, nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) encode(dst, chunk []byte, i int, last bool) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(chunk); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) decode(dst, stored []byte, i int, last bool) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(dst)
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), r.Close()
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCoders returns the zstd encoder and decoder shared by every file.
+// Both of them are safe for concurrent use with EncodeAll and DecodeAll.
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+
+	return zstdEncoder, zstdDecoder
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) encode(dst, chunk []byte, i int, last bool) ([]byte, error) {
+	e, _ := zstdCoders()
+	return e.EncodeAll(chunk, dst), nil
+}
+
+func (zstdCodec) decode(dst, stored []byte, i int, last bool) ([]byte, error) {
+	_, d := zstdCoders()
+	return d.DecodeAll(stored, dst)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) encode(dst, chunk []byte, i int, last bool) ([]byte, error) {
+	return snappy.Encode(dst[:cap(dst)], chunk), nil
+}
+
+func (snappyCodec) decode(dst, stored []byte, i int, last bool) ([]byte, error) {
+	return snappy.Decode(dst[:cap(dst)], stored)
+}
@@ -0,0 +1,209 @@
+package sivafs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type CompressSuite struct {
+	memSuite
+	data []byte
+}
+
+var _ = Suite(&CompressSuite{})
+
+var compressions = []Compression{Gzip, Zstd, Snappy}
+
+func (s *CompressSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3*chunkSize+100; i++ {
+		fmt.Fprintf(&buf, "{\"line\": %d, \"text\": \"some repeated text\"}\n", i)
+	}
+
+	s.data = buf.Bytes()
+}
+
+func (s *CompressSuite) open(c *C, name string, compression Compression) SivaFS {
+	return s.openPath(c, name, SivaFSOptions{Compression: compression})
+}
+
+func (s *CompressSuite) TestReadWrite(c *C) {
+	for _, compression := range compressions {
+		name := fmt.Sprintf("%d.siva", compression)
+		fs := s.open(c, name, compression)
+		writeFile(c, fs, "big.json", s.data)
+		writeFile(c, fs, "small", []byte("small"))
+		writeFile(c, fs, "empty", nil)
+		c.Assert(fs.Sync(), IsNil)
+
+		fs = s.open(c, name, NoCompression)
+		testFileContent(c, fs, "big.json", string(s.data))
+		testFileContent(c, fs, "small", "small")
+		testFileContent(c, fs, "empty", "")
+
+		fi, err := fs.Stat("big.json")
+		c.Assert(err, IsNil)
+		c.Assert(fi.Size(), Equals, int64(len(s.data)))
+
+		files, err := fs.ReadDir("/")
+		c.Assert(err, IsNil)
+		c.Assert(files, HasLen, 3)
+		c.Assert(files[0].Name(), Equals, "big.json")
+		c.Assert(files[0].Size(), Equals, int64(len(s.data)))
+
+		entries := readBlocks(c, s.mem, name)[0].Entries
+		c.Assert(entries[0].Size < uint64(len(s.data))/2, Equals, true,
+			Commentf("compression %d", compression))
+		c.Assert(compressionFromFlags(entries[0].Flags), Equals, compression)
+	}
+}
+
+func (s *CompressSuite) TestSeekAndReadAt(c *C) {
+	fs := s.open(c, "test.siva", Zstd)
+	writeFile(c, fs, "big.json", s.data)
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err := fs.Open("big.json")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	off := int64(chunkSize - 10)
+	buf := make([]byte, chunkSize+20)
+	n, err := f.ReadAt(buf, off)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(buf))
+	c.Assert(buf, DeepEquals, s.data[off:off+int64(len(buf))])
+
+	n, err = f.ReadAt(buf, int64(len(s.data)-5))
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(n, Equals, 5)
+
+	pos, err := f.Seek(-100, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(len(s.data)-100))
+
+	rest, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, s.data[len(s.data)-100:])
+
+	_, err = f.Seek(2*chunkSize, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = io.ReadFull(f, buf[:10])
+	c.Assert(err, IsNil)
+	c.Assert(buf[:10], DeepEquals, s.data[2*chunkSize:2*chunkSize+10])
+}
+
+func (s *CompressSuite) TestReadUncompressed(c *C) {
+	fs, err := NewFilesystemWithOptions(osfs.New(fixturesPath), "basic.siva",
+		memfs.New(), SivaFSOptions{ReadOnly: true, Compression: Gzip})
+	c.Assert(err, IsNil)
+
+	f, err := fs.Open("gopher.txt")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	fi, err := fs.Stat("gopher.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(len(data)))
+}
+
+func (s *CompressSuite) TestMergeKeepsCompression(c *C) {
+	src := s.open(c, "src.siva", Snappy)
+	writeFile(c, src, "big.json", s.data)
+	c.Assert(src.Sync(), IsNil)
+
+	dst := s.open(c, "dst.siva", NoCompression)
+	c.Assert(Merge(dst, src, MergeFail), IsNil)
+
+	entries := readBlocks(c, s.mem, "dst.siva")[0].Entries
+	c.Assert(compressionFromFlags(entries[0].Flags), Equals, Snappy)
+	testFileContent(c, s.open(c, "dst.siva", NoCompression), "big.json", string(s.data))
+}
+
+func (s *CompressSuite) TestBytes(c *C) {
+	fs := s.open(c, "test.siva", Gzip)
+	writeFile(c, fs, "small", []byte("small"))
+	c.Assert(fs.Sync(), IsNil)
+
+	_, _, err := fs.(SivaBytes).Bytes("small")
+	c.Assert(err, Equals, ErrNotMapped)
+}
+
+func (s *CompressSuite) TestUnknownCompression(c *C) {
+	fs := s.open(c, "test.siva", Compression(10))
+	_, err := fs.Create("file")
+	c.Assert(err, Equals, ErrUnknownCompression)
+}
+
+func snappyCodecFunc(prefix []byte) (chunkCodec, error) {
+	return snappyCodec{}, nil
+}
+
+func (s *CompressSuite) TestInvalidChunks(c *C) {
+	_, err := newChunkReader(bytes.NewReader([]byte("short")), 5, snappyCodecFunc)
+	c.Assert(err, Equals, ErrInvalidChunks)
+
+	var buf bytes.Buffer
+	w := newChunkWriter(&buf, nil, snappyCodec{})
+	_, err = w.Write([]byte("some data"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	stored := buf.Bytes()
+	stored[len(stored)-chunkFooterSize-1]++
+	_, err = newChunkReader(bytes.NewReader(stored), int64(len(stored)), snappyCodecFunc)
+	c.Assert(err, Equals, ErrInvalidChunks)
+}
+
+// countingReaderAt counts the reads of a siva file.
+type countingReaderAt struct {
+	io.ReaderAt
+	reads int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func (s *CompressSuite) TestStatReadsFooterOnce(c *C) {
+	fs := s.open(c, "test.siva", Gzip)
+	writeFile(c, fs, "big.json", s.data)
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err := s.mem.Open("test.siva")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	r := &countingReaderAt{ReaderAt: bytes.NewReader(data)}
+	ro, err := NewFilesystemFromReaderAt(r, int64(len(data)), 0)
+	c.Assert(err, IsNil)
+
+	fi, err := ro.Stat("big.json")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(len(s.data)))
+
+	reads := r.reads
+	for i := 0; i < 3; i++ {
+		fi, err = ro.Stat("big.json")
+		c.Assert(err, IsNil)
+		c.Assert(fi.Size(), Equals, int64(len(s.data)))
+
+		files, err := ro.ReadDir("/")
+		c.Assert(err, IsNil)
+		c.Assert(files[0].Size(), Equals, int64(len(s.data)))
+	}
+
+	c.Assert(r.reads, Equals, reads)
+}
@@ -0,0 +1,120 @@
+package sivafs
+
+import (
+	"context"
+	"io"
+)
+
+// SivaContext is implemented by siva filesystems whose operations can be
+// cancelled.
+type SivaContext interface {
+	// WithContext returns a filesystem sharing the siva file and the state
+	// of this one, whose operations fail with the error of ctx once it is
+	// done. Scans of the index, reads and writes of the files opened from it,
+	// copies of contents and Sync check ctx. The index block written by Sync
+	// is never left half written: a cancelled Sync keeps the changes pending
+	// for the next one. Closing a file whose write was cancelled returns the
+	// error of ctx and drops it, restoring the file it replaced, if any.
+	WithContext(ctx context.Context) SivaFS
+}
+
+// contextFS is implemented by the root filesystems of this package, see
+// SivaContext.
+type contextFS interface {
+	// withContext returns a filesystem sharing the state of this one, with
+	// its operations cancelled by ctx.
+	withContext(ctx context.Context) rootFS
+}
+
+func (fs *sivaFS) withContext(ctx context.Context) rootFS {
+	return &sivaFS{sivaState: fs.sivaState, ctx: ctx}
+}
+
+func (u *union) withContext(ctx context.Context) rootFS {
+	layers := make([]SivaFS, len(u.layers))
+	for i, l := range u.layers {
+		if c, ok := l.(SivaContext); ok {
+			l = c.WithContext(ctx)
+		}
+
+		layers[i] = l
+	}
+
+	return &union{
+		layers:   layers,
+		writable: u.writable,
+		ctx:      ctx,
+	}
+}
+
+func (s *sharded) withContext(ctx context.Context) rootFS {
+	shards := make([]*sivaFS, len(s.shards))
+	for i, shard := range s.shards {
+		shards[i] = &sivaFS{sivaState: shard.sivaState, ctx: ctx}
+	}
+
+	return &sharded{
+		shards:  shards,
+		shard:   s.shard,
+		options: s.options,
+		ctx:     ctx,
+	}
+}
+
+// WithContext implements SivaContext interface.
+func (h *temp) WithContext(ctx context.Context) SivaFS {
+	return newTemp(h.sivaRoot.withContext(ctx), h.tmpFs)
+}
+
+// WithContext implements SivaContext interface.
+func (r *readOnly) WithContext(ctx context.Context) SivaFS {
+	return newReadOnly(r.sivaRoot.withContext(ctx))
+}
+
+// WithContext implements SivaContext interface.
+func (u *unionFS) WithContext(ctx context.Context) SivaFS {
+	return newUnionFS(u.sivaRoot.withContext(ctx).(*union))
+}
+
+// contextInterval is the number of entries of the index scanned between
+// checks of the context.
+const contextInterval = 256
+
+// checkContext returns the error of ctx every contextInterval calls, with i
+// the number of the entry scanned.
+func checkContext(ctx context.Context, i int) error {
+	if i%contextInterval != 0 {
+		return nil
+	}
+
+	return ctx.Err()
+}
+
+// copyContext copies src to dst like io.Copy, checking ctx before every
+// chunk.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			m, werr := dst.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+
+		if err == io.EOF {
+			return written, nil
+		}
+
+		if err != nil {
+			return written, err
+		}
+	}
+}
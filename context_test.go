@@ -0,0 +1,209 @@
+package sivafs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type ContextSuite struct {
+	memSuite
+}
+
+var _ = Suite(&ContextSuite{})
+
+func cancelled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func (s *ContextSuite) TestReadDir(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "dir/one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	cfs := fs.(SivaContext).WithContext(cancelled())
+	_, err := cfs.ReadDir("dir")
+	c.Assert(err, Equals, context.Canceled)
+	_, err = cfs.Stat("dir")
+	c.Assert(err, Equals, context.Canceled)
+
+	files, err := fs.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"one"})
+
+	ro := s.open(c, SivaFSOptions{ReadOnly: true})
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	_, err = ro.(SivaContext).WithContext(ctx).ReadDir("dir")
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
+
+func (s *ContextSuite) TestRead(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := fs.(SivaContext).WithContext(ctx).Open("one")
+	c.Assert(err, IsNil)
+
+	b := make([]byte, 1)
+	_, err = f.Read(b)
+	c.Assert(err, IsNil)
+
+	cancel()
+	_, err = ioutil.ReadAll(f)
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *ContextSuite) TestWrite(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := fs.(SivaContext).WithContext(ctx).Create("one")
+	c.Assert(err, IsNil)
+
+	_, err = f.Write([]byte("one"))
+	c.Assert(err, IsNil)
+
+	cancel()
+	_, err = f.Write([]byte("two"))
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(f.Close(), Equals, context.Canceled)
+
+	c.Assert(fs.Sync(), IsNil)
+	_, err = s.open(c, SivaFSOptions{}).Stat("one")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ContextSuite) TestRewrite(c *C) {
+	fs := s.open(c, SivaFSOptions{Hash: crypto.SHA256})
+	writeFile(c, fs, "one", []byte("one"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := fs.(SivaContext).WithContext(ctx).Create("one")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("two"))
+	c.Assert(err, IsNil)
+
+	cancel()
+	c.Assert(f.Close(), Equals, context.Canceled)
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{Hash: crypto.SHA256})
+	testFileContent(c, fs, "one", "one")
+	_, sum, err := fs.(SivaHash).Hash("one")
+	c.Assert(err, IsNil)
+	c.Assert(sum, DeepEquals, sha256Sum("one"))
+}
+
+// countdownContext is cancelled once Err has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n <= 0 {
+		return context.Canceled
+	}
+
+	ctx.n--
+	return nil
+}
+
+func (s *ContextSuite) TestMerge(c *C) {
+	data := bytes.Repeat([]byte("new"), 100*1024)
+	for n := 0; ; n++ {
+		s.mem = memfs.New()
+		dst := s.open(c, SivaFSOptions{})
+		writeFile(c, dst, "a", []byte("old"))
+		c.Assert(dst.Sync(), IsNil)
+
+		src := s.openPath(c, "src.siva", SivaFSOptions{})
+		writeFile(c, src, "a", data)
+		c.Assert(src.Sync(), IsNil)
+
+		ctx := &countdownContext{Context: context.Background(), n: n}
+		err := Merge(dst.(SivaContext).WithContext(ctx), src, MergeTakeSrc)
+		if err == nil {
+			testFileContent(c, s.open(c, SivaFSOptions{}), "a", string(data))
+			break
+		}
+
+		// A Merge cancelled in its final Sync leaves the whole file pending.
+		c.Assert(err, Equals, context.Canceled)
+		c.Assert(dst.Sync(), IsNil)
+		f, err := s.open(c, SivaFSOptions{}).Open("a")
+		c.Assert(err, IsNil)
+		read, err := ioutil.ReadAll(f)
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+		if !bytes.Equal(read, data) {
+			c.Assert(string(read), Equals, "old")
+		}
+	}
+}
+
+func (s *ContextSuite) TestSync(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cfs := fs.(SivaContext).WithContext(ctx)
+	writeFile(c, cfs, "one", []byte("one"))
+
+	cancel()
+	c.Assert(cfs.Sync(), Equals, context.Canceled)
+
+	writeFile(c, fs, "two", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(readBlocks(c, s.mem, "test.siva"), HasLen, 1)
+
+	fs = s.open(c, SivaFSOptions{})
+	testFileContent(c, fs, "one", "one")
+	testFileContent(c, fs, "two", "two")
+}
+
+func (s *ContextSuite) TestUnion(c *C) {
+	lower := s.open(c, SivaFSOptions{})
+	writeFile(c, lower, "one", []byte("one"))
+	c.Assert(lower.Sync(), IsNil)
+
+	upper := s.openPath(c, "upper.siva", SivaFSOptions{})
+
+	u, err := NewUnionWithOptions([]SivaFS{lower, upper}, UnionOptions{Writable: true})
+	c.Assert(err, IsNil)
+
+	_, err = u.(SivaContext).WithContext(cancelled()).ReadDir("/")
+	c.Assert(err, Equals, context.Canceled)
+
+	cu := u.(SivaContext).WithContext(context.Background())
+	testFileContent(c, cu, "one", "one")
+	writeFile(c, cu, "two", []byte("two"))
+	c.Assert(cu.Sync(), IsNil)
+	testFileContent(c, u, "two", "two")
+}
+
+func (s *ContextSuite) TestSharded(c *C) {
+	fs, err := NewSharded(s.mem, []string{"0.siva", "1.siva"}, memfs.New())
+	c.Assert(err, IsNil)
+	writeFile(c, fs, "one", []byte("one"))
+	writeFile(c, fs, "two", []byte("two"))
+
+	cfs := fs.(SivaContext).WithContext(cancelled())
+	_, err = cfs.ReadDir("/")
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(cfs.Sync(), Equals, context.Canceled)
+
+	c.Assert(fs.Sync(), IsNil)
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"one", "two"})
+}
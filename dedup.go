@@ -0,0 +1,412 @@
+package sivafs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrReservedPath = errors.New("path is reserved for internal use")
+	ErrMissingBlob  = errors.New("file references missing contents")
+)
+
+const (
+	// reservedDir holds the entries used internally by the filesystem. They
+	// are hidden from the files of the filesystem.
+	reservedDir = ".sivafs"
+	// blobsDir holds the contents of deduplicated files, see the Dedup
+	// option.
+	blobsDir = reservedDir + "/blobs"
+)
+
+// flagReference tells that the contents of the file are the ID of the blob
+// holding its real contents.
+const flagReference = siva.Flag(1) << 14
+
+// isReserved returns true if the path is used internally.
+func isReserved(path string) bool {
+	return path == reservedDir || strings.HasPrefix(path, reservedDir+"/")
+}
+
+// visibleEntries returns the entries of the index not used internally.
+func visibleEntries(index siva.OrderedIndex) siva.OrderedIndex {
+	var visible siva.OrderedIndex
+	for i, e := range index {
+		if !isReserved(e.Name) {
+			if visible != nil {
+				visible = append(visible, e)
+			}
+
+			continue
+		}
+
+		if visible == nil {
+			visible = append(siva.OrderedIndex{}, index[:i]...)
+		}
+	}
+
+	if visible == nil {
+		return index
+	}
+
+	return visible
+}
+
+// blobPath returns the path of the blob with the given ID.
+func blobPath(id string) string {
+	return blobsDir + "/" + id
+}
+
+// dedupBufferSize is the size up to which the contents of deduplicated files
+// are kept in memory. Bigger files are spooled to the temporary filesystem,
+// so in both cases their blob is only written if there is none with the same
+// contents. Without a temporary filesystem they are streamed to a new blob,
+// deleted once closed if there was one already.
+const dedupBufferSize = chunkSize
+
+// newBlobID returns a random ID for a new blob. IDs do not depend on the
+// contents so they do not disclose them. The SHA-256 of the contents is kept
+// in the sidecar of the blob, encrypted like other sidecars with the Keys
+// option, so blobs keep being shared after the current key changes.
+func newBlobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// createDedup returns a file whose contents are stored in a blob, unless
+// there is already one with the same contents, and written as a reference to
+// it once closed. Its sidecar holds the hash computed with h, if not nil,
+// and meta.
+func (fs *sivaFS) createDedup(
+	header *siva.Header,
+	h hash.Hash,
+	meta map[string]string,
+) (billy.File, error) {
+	w := &dedupWriter{fs: fs, modTime: header.ModTime, sum: sha256.New()}
+	var tee io.Writer = w
+	if h != nil {
+		tee = io.MultiWriter(w, h)
+	}
+
+	closeFunc := func() error {
+		if fs.getReadWriter() == nil {
+			return nil
+		}
+
+		fs.fileWriteModeOpen = false
+		if err := fs.ctx.Err(); err != nil {
+			// nothing is written for the file until closed, a blob
+			// being streamed is dropped when closed.
+			w.discard()
+			return err
+		}
+
+		return fs.writeDedup(header, w, h, meta)
+	}
+
+	fs.fileWriteModeOpen = true
+	return newFile(fs.ctx, header.Name, tee, closeFunc), nil
+}
+
+// dedupWriter writes the contents of a deduplicated file, computing their
+// SHA-256. They are buffered up to dedupBufferSize and spooled afterwards.
+type dedupWriter struct {
+	fs      *sivaFS
+	modTime time.Time
+	sum     hash.Hash
+	buf     bytes.Buffer
+	// spool receives the contents bigger than dedupBufferSize, see spool.
+	spool billy.File
+	// id is the ID of the blob the contents are streamed to when there is
+	// no temporary filesystem.
+	id string
+}
+
+func (w *dedupWriter) Write(p []byte) (int, error) {
+	w.sum.Write(p)
+	if w.spool == nil && w.buf.Len()+len(p) <= dedupBufferSize {
+		return w.buf.Write(p)
+	}
+
+	if w.spool == nil {
+		if err := w.startSpool(); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.spool.Write(p)
+}
+
+// startSpool creates the file receiving the contents, a temporary file or,
+// without temporary filesystem, a new blob, and writes the buffered ones to
+// it.
+func (w *dedupWriter) startSpool() error {
+	var f billy.File
+	var id string
+	var err error
+	if w.fs.tmp != nil {
+		f, err = util.TempFile(w.fs.tmp, "", "sivafs-dedup")
+	} else if id, err = newBlobID(); err == nil {
+		f, err = w.fs.createFile(w.fs.blobHeader(id, w.modTime), os.O_WRONLY, nil)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(w.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.id, w.spool = id, f
+	w.buf.Reset()
+	return nil
+}
+
+// discard closes the spool, if any, removing the temporary file. A blob
+// being streamed is dropped when closed if the write was cancelled.
+func (w *dedupWriter) discard() error {
+	if w.spool == nil {
+		return nil
+	}
+
+	err := w.spool.Close()
+	if w.id != "" {
+		return err
+	}
+
+	if rerr := w.fs.tmp.Remove(w.spool.Name()); err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+func (fs *sivaFS) blobHeader(id string, modTime time.Time) *siva.Header {
+	return &siva.Header{
+		Name:    blobPath(id),
+		Mode:    0644,
+		ModTime: modTime,
+	}
+}
+
+func (fs *sivaFS) writeDedup(
+	header *siva.Header,
+	w *dedupWriter,
+	h hash.Hash,
+	meta map[string]string,
+) error {
+	if w.id != "" {
+		// the blob streamed must be complete before looking for others.
+		if err := w.spool.Close(); err != nil {
+			return err
+		}
+	}
+
+	sums, err := fs.getBlobSums()
+	if err != nil {
+		return err
+	}
+
+	sum := w.sum.Sum(nil)
+	id, ok := sums[hex.EncodeToString(sum)]
+	switch {
+	case ok && w.id != "":
+		err = fs.deleteFile(blobPath(w.id))
+	case ok:
+		err = w.discard()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if id, err = fs.writeBlob(w, sum); err != nil {
+			return err
+		}
+
+		sums[hex.EncodeToString(sum)] = id
+	}
+
+	header.Flags |= flagReference
+	if err := fs.writeEntry(header, []byte(id)); err != nil {
+		return err
+	}
+
+	return fs.writeFileSidecar(header.Name, h, meta)
+}
+
+// writeBlob writes the blob with the contents of w, unless they were already
+// streamed to one, and its sidecar holding their SHA-256. It returns the ID
+// of the blob.
+func (fs *sivaFS) writeBlob(w *dedupWriter, sum []byte) (string, error) {
+	id := w.id
+	if id == "" {
+		var err error
+		if id, err = newBlobID(); err != nil {
+			return "", err
+		}
+
+		if err := fs.writeSpooled(fs.blobHeader(id, w.modTime), w); err != nil {
+			return "", err
+		}
+	}
+
+	err := fs.writeSidecar(blobPath(id), &sidecar{Hash: crypto.SHA256, Sum: sum})
+	return id, err
+}
+
+// writeSpooled writes a file with the contents of w, buffered or spooled to
+// a temporary file, which is removed.
+func (fs *sivaFS) writeSpooled(header *siva.Header, w *dedupWriter) error {
+	if w.spool == nil {
+		return fs.writeEntry(header, w.buf.Bytes())
+	}
+
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		w.discard()
+		return err
+	}
+
+	f, err := fs.createFile(header, os.O_WRONLY, nil)
+	if err == nil {
+		_, err = io.Copy(f, w.spool)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if derr := w.discard(); err == nil {
+		err = derr
+	}
+
+	return err
+}
+
+// getBlobSums returns the IDs of the blobs by the SHA-256 of their contents,
+// in hexadecimal. They are read from the sidecars of the blobs the first time
+// it is called after the siva file is opened. Blobs written before the
+// sidecars were, without the Keys option, are named after the SHA-256.
+func (fs *sivaFS) getBlobSums() (map[string]string, error) {
+	if fs.blobSums != nil {
+		return fs.blobSums, nil
+	}
+
+	index, err := fs.getFullIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string)
+	for _, e := range index {
+		if !strings.HasPrefix(e.Name, blobsDir+"/") {
+			continue
+		}
+
+		id := strings.TrimPrefix(e.Name, blobsDir+"/")
+		s, err := fs.readSidecar(index, e)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case s != nil && s.Hash == crypto.SHA256:
+			sums[hex.EncodeToString(s.Sum)] = id
+		case s == nil && fs.options.Keys == nil:
+			sums[id] = id
+		}
+	}
+
+	fs.blobSums = sums
+	return sums, nil
+}
+
+// writeEntry writes a file with the given contents.
+func (fs *sivaFS) writeEntry(header *siva.Header, data []byte) error {
+	f, err := fs.createFile(header, os.O_WRONLY, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// resolveReference returns the entry of the blob referenced by the entry.
+func (fs *sivaFS) resolveReference(e *siva.IndexEntry) (*siva.IndexEntry, error) {
+	r, err := fs.contentReader(e)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := fs.getBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	blob, ok := blobs[string(id)]
+	if !ok {
+		return nil, ErrMissingBlob
+	}
+
+	return blob, nil
+}
+
+// getBlobs returns the entries of the blobs by their ID. They are read from
+// the index once per generation, see indexedFS.
+func (fs *sivaFS) getBlobs() (map[string]*siva.IndexEntry, error) {
+	gen := fs.generation()
+	fs.mu.Lock()
+	blobs := fs.blobs
+	fresh := fs.blobsGen == gen
+	fs.mu.Unlock()
+	if blobs != nil && fresh {
+		return blobs, nil
+	}
+
+	index, err := fs.getFullIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	blobs = make(map[string]*siva.IndexEntry)
+	for _, e := range index {
+		if strings.HasPrefix(e.Name, blobsDir+"/") {
+			blobs[strings.TrimPrefix(e.Name, blobsDir+"/")] = e
+		}
+	}
+
+	fs.mu.Lock()
+	fs.blobs, fs.blobsGen = blobs, gen
+	fs.mu.Unlock()
+	return blobs, nil
+}
@@ -0,0 +1,222 @@
+package sivafs
+
+import (
+	"fmt"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+type DedupSuite struct {
+	memSuite
+	data string
+}
+
+var _ = Suite(&DedupSuite{})
+
+func (s *DedupSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+	s.data = strings.Repeat("vendored file ", 1000)
+}
+
+func (s *DedupSuite) open(c *C, o SivaFSOptions) SivaFS {
+	o.Dedup = true
+	return s.memSuite.open(c, o)
+}
+
+// blobs returns the number of blobs written in the siva file.
+func (s *DedupSuite) blobs(c *C) int {
+	var count int
+	for _, b := range readBlocks(c, s.mem, "test.siva") {
+		for _, e := range b.Entries {
+			if strings.HasPrefix(e.Name, blobsDir+"/") {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func (s *DedupSuite) TestDedup(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "a/vendor/file", []byte(s.data))
+	writeFile(c, fs, "b/vendor/file", []byte(s.data))
+	writeFile(c, fs, "other", []byte("other"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "c/vendor/file", []byte(s.data))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.blobs(c), Equals, 2)
+
+	fi, err := s.mem.Stat("test.siva")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size() < int64(2*len(s.data)), Equals, true)
+
+	fs = s.open(c, SivaFSOptions{})
+	for _, name := range []string{"a/vendor/file", "b/vendor/file", "c/vendor/file"} {
+		testFileContent(c, fs, name, s.data)
+
+		fi, err := fs.Stat(name)
+		c.Assert(err, IsNil)
+		c.Assert(fi.Size(), Equals, int64(len(s.data)))
+	}
+
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"a", "b", "c", "other"})
+
+	_, err = fs.Stat(reservedDir)
+	c.Assert(err, NotNil)
+}
+
+func (s *DedupSuite) TestRemoveShared(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte(s.data))
+	writeFile(c, fs, "two", []byte(s.data))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{})
+	c.Assert(fs.Remove("one"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{})
+	_, err := fs.Stat("one")
+	c.Assert(err, NotNil)
+	testFileContent(c, fs, "two", s.data)
+
+	writeFile(c, fs, "three", []byte(s.data))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.blobs(c), Equals, 1)
+	testFileContent(c, fs, "three", s.data)
+
+	deleted, err := fs.(indexedFS).deletedPaths()
+	c.Assert(err, IsNil)
+	c.Assert(deleted, DeepEquals, map[string]bool{"one": true})
+}
+
+func (s *DedupSuite) TestSpooled(c *C) {
+	data := strings.Repeat("big vendored file ", dedupBufferSize/10)
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte(data))
+	writeFile(c, fs, "two", []byte(data))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.blobs(c), Equals, 1)
+
+	fs = s.open(c, SivaFSOptions{})
+	testFileContent(c, fs, "one", data)
+	testFileContent(c, fs, "two", data)
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.DeadBytes, Equals, uint64(0))
+}
+
+func (s *DedupSuite) TestStreamed(c *C) {
+	data := strings.Repeat("big vendored file ", dedupBufferSize/10)
+	fs := NewWithOptions(s.mem, "test.siva", SivaFSOptions{Dedup: true})
+	writeFile(c, fs, "one", []byte(data))
+	writeFile(c, fs, "two", []byte(data))
+	c.Assert(fs.Sync(), IsNil)
+
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.DeadBytes >= uint64(len(data)), Equals, true)
+
+	fs = s.open(c, SivaFSOptions{})
+	testFileContent(c, fs, "one", data)
+	testFileContent(c, fs, "two", data)
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+	c.Assert(s.blobs(c), Equals, 1)
+
+	for _, fs := range []billy.Basic{fs, s.open(c, SivaFSOptions{})} {
+		testFileContent(c, fs, "one", data)
+		testFileContent(c, fs, "two", data)
+	}
+}
+
+func (s *DedupSuite) TestBlobSumsIndexOnce(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	for i := 0; i < 10; i++ {
+		writeFile(c, fs, fmt.Sprintf("file%d", i), []byte(fmt.Sprint(i)))
+	}
+	c.Assert(fs.Sync(), IsNil)
+
+	observer := &testObserver{}
+	fs = s.open(c, SivaFSOptions{Observer: observer})
+	writeFile(c, fs, "one", []byte(s.data))
+	c.Assert(observer.count(OpIndex), Equals, 1)
+}
+
+func (s *DedupSuite) TestKeyRotation(c *C) {
+	keys := newTestKeys()
+	fs := s.open(c, SivaFSOptions{Keys: keys})
+	writeFile(c, fs, "one", []byte(s.data))
+	c.Assert(fs.Sync(), IsNil)
+
+	keys.current = "two"
+	fs = s.open(c, SivaFSOptions{Keys: keys})
+	writeFile(c, fs, "two", []byte(s.data))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(s.blobs(c), Equals, 1)
+
+	fs = s.open(c, SivaFSOptions{Keys: keys})
+	testFileContent(c, fs, "one", s.data)
+	testFileContent(c, fs, "two", s.data)
+}
+
+func (s *DedupSuite) TestEncoded(c *C) {
+	o := SivaFSOptions{
+		Compression:  Snappy,
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+	}
+
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte(s.data))
+	writeFile(c, fs, "two", []byte(s.data))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, o)
+	testFileContent(c, fs, "one", s.data)
+	testFileContent(c, fs, "two", s.data)
+
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"one", "two"})
+	c.Assert(files[0].Size(), Equals, int64(len(s.data)))
+}
+
+func (s *DedupSuite) TestMerge(c *C) {
+	src := s.open(c, SivaFSOptions{})
+	writeFile(c, src, "one", []byte(s.data))
+	writeFile(c, src, "two", []byte(s.data))
+	c.Assert(src.Sync(), IsNil)
+
+	dst := s.openPath(c, "dst.siva", SivaFSOptions{})
+	c.Assert(Merge(dst, src, MergeFail), IsNil)
+
+	testFileContent(c, dst, "one", s.data)
+	testFileContent(c, dst, "two", s.data)
+}
+
+func (s *DedupSuite) TestWriteModeOpen(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	f, err := fs.Create("one")
+	c.Assert(err, IsNil)
+
+	_, err = fs.Create("two")
+	c.Assert(err, Equals, ErrFileWriteModeAlreadyOpen)
+	c.Assert(f.Close(), IsNil)
+
+	f, err = fs.Create("two")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *DedupSuite) TestReservedPath(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	_, err := fs.Create(".sivafs/blobs/file")
+	c.Assert(err, Equals, ErrReservedPath)
+}
@@ -0,0 +1,265 @@
+package sivafs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrNoKeyProvider           = errors.New("file is encrypted and there is no key provider")
+	ErrInvalidEncryptionHeader = errors.New("invalid encryption header")
+)
+
+// NamesKeyID is the ID of the key used to hide the names of files, see the
+// EncryptNames option.
+const NamesKeyID = "names"
+
+// KeyProvider supplies the keys used to encrypt file contents. Keys must be
+// 16, 24 or 32 bytes long to use AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key used to encrypt new files.
+	CurrentKeyID() string
+	// Key returns the key with the given ID.
+	Key(id string) ([]byte, error)
+}
+
+// DecryptError is returned when a file can not be decrypted, usually because
+// its key is wrong or missing.
+type DecryptError struct {
+	// Path is the path of the file, or its stored name if the name could not
+	// be decrypted.
+	Path string
+	// KeyID is the ID of the key the file was encrypted with.
+	KeyID string
+	Err   error
+}
+
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("cannot decrypt %s with key %q: %s", e.Path, e.KeyID, e.Err)
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// flagEncrypted tells that the file contents are encrypted.
+	flagEncrypted = siva.Flag(1) << 12
+	// flagEncryptedName tells that the stored name is a hash of the file
+	// name, which is encrypted in the encryption header.
+	flagEncryptedName = siva.Flag(1) << 13
+)
+
+const (
+	// encryptionVersion is the version of the encryption header, the only
+	// one accepted.
+	encryptionVersion = 2
+	nonceSize         = 12
+)
+
+// encryptionHeader is stored at the start of encrypted files:
+//
+//	version (1 byte) | key ID size (2 bytes) | key ID | nonce (12 bytes) |
+//	sealed name size (2 bytes) | sealed name
+//
+// The nonce of each chunk is the header nonce xor its number plus one. The
+// name, if any, is sealed with the header nonce.
+type encryptionHeader struct {
+	Version uint8
+	KeyID   string
+	Nonce   []byte
+	Name    []byte
+}
+
+func (h *encryptionHeader) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(h.Version)
+	binary.Write(&buf, binary.BigEndian, uint16(len(h.KeyID)))
+	buf.WriteString(h.KeyID)
+	buf.Write(h.Nonce)
+	binary.Write(&buf, binary.BigEndian, uint16(len(h.Name)))
+	buf.Write(h.Name)
+	return buf.Bytes()
+}
+
+// readEncryptionHeader reads the encryption header at the start of r.
+func readEncryptionHeader(r io.Reader) (*encryptionHeader, error) {
+	var version uint8
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, ErrInvalidEncryptionHeader
+	}
+
+	if version != encryptionVersion {
+		return nil, ErrInvalidEncryptionHeader
+	}
+
+	keyID, err := readSized(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, ErrInvalidEncryptionHeader
+	}
+
+	name, err := readSized(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptionHeader{
+		Version: version,
+		KeyID:   string(keyID),
+		Nonce:   nonce,
+		Name:    name,
+	}, nil
+}
+
+// readSized reads a byte slice preceded by its size as an uint16.
+func readSized(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, ErrInvalidEncryptionHeader
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, ErrInvalidEncryptionHeader
+	}
+
+	return buf, nil
+}
+
+// aeadCodec encrypts chunks with AES-GCM.
+type aeadCodec struct {
+	aead   cipher.AEAD
+	header *encryptionHeader
+	path   string
+	// stored is the name of the siva entry, authenticated with the chunks
+	// and the sealed name so they can not be moved to another entry.
+	stored string
+}
+
+// newAEADCodec returns the codec of the file with the given path, stored
+// with the given name, and encryption header, using keys from the provider.
+func newAEADCodec(keys KeyProvider, h *encryptionHeader, path, stored string) (*aeadCodec, error) {
+	key, err := keys.Key(h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aeadCodec{
+		aead:   aead,
+		header: h,
+		path:   path,
+		stored: siva.ToSafePath(stored),
+	}, nil
+}
+
+// newEncryptionHeader returns the header of a new file encrypted with the
+// current key of the provider.
+func newEncryptionHeader(keys KeyProvider) (*encryptionHeader, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &encryptionHeader{
+		Version: encryptionVersion,
+		KeyID:   keys.CurrentKeyID(),
+		Nonce:   nonce,
+	}, nil
+}
+
+// nonce returns the nonce of the chunk i, or the one of the name for -1.
+func (c *aeadCodec) nonce(i int) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, c.header.Nonce)
+
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ uint64(i+1)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// additionalData authenticates the position of the chunk, so chunks can not
+// be reordered or the file truncated, and the stored name of the file.
+func (c *aeadCodec) additionalData(i int, last bool) []byte {
+	ad := make([]byte, 9, 9+len(c.stored))
+	binary.BigEndian.PutUint64(ad[:8], uint64(i))
+	if last {
+		ad[8] = 1
+	}
+
+	return append(ad, c.nameData()...)
+}
+
+// nameData returns the stored name authenticated by the codec.
+func (c *aeadCodec) nameData() []byte {
+	return []byte(c.stored)
+}
+
+func (c *aeadCodec) encode(dst, chunk []byte, i int, last bool) ([]byte, error) {
+	return c.aead.Seal(dst, c.nonce(i), chunk, c.additionalData(i, last)), nil
+}
+
+func (c *aeadCodec) decode(dst, stored []byte, i int, last bool) ([]byte, error) {
+	b, err := c.aead.Open(dst, c.nonce(i), stored, c.additionalData(i, last))
+	if err != nil {
+		return nil, &DecryptError{Path: c.path, KeyID: c.header.KeyID, Err: err}
+	}
+
+	return b, nil
+}
+
+// sealName encrypts the name of the file in the header.
+func (c *aeadCodec) sealName(name string) {
+	c.header.Name = c.aead.Seal(nil, c.nonce(-1), []byte(name), c.nameData())
+}
+
+// openName decrypts the name of the file from the header.
+func (c *aeadCodec) openName() (string, error) {
+	name, err := c.aead.Open(nil, c.nonce(-1), c.header.Name, c.nameData())
+	if err != nil {
+		return "", &DecryptError{Path: c.path, KeyID: c.header.KeyID, Err: err}
+	}
+
+	return string(name), nil
+}
+
+// hashName returns the name stored for a file when names are encrypted.
+func hashName(keys KeyProvider, name string) (string, error) {
+	if keys == nil {
+		return "", ErrNoKeyProvider
+	}
+
+	key, err := keys.Key(NamesKeyID)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,284 @@
+package sivafs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// testKeys is a KeyProvider holding keys in memory.
+type testKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+func newTestKeys() *testKeys {
+	return &testKeys{
+		current: "one",
+		keys: map[string][]byte{
+			"one":      bytes.Repeat([]byte{1}, 32),
+			"two":      bytes.Repeat([]byte{2}, 16),
+			NamesKeyID: bytes.Repeat([]byte{3}, 32),
+		},
+	}
+}
+
+func (k *testKeys) CurrentKeyID() string {
+	return k.current
+}
+
+func (k *testKeys) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, errors.New("unknown key")
+	}
+
+	return key, nil
+}
+
+type EncryptSuite struct {
+	memSuite
+	keys *testKeys
+	data []byte
+}
+
+var _ = Suite(&EncryptSuite{})
+
+func (s *EncryptSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+	s.keys = newTestKeys()
+	s.data = []byte(strings.Repeat("secret customer data ", 10000))
+}
+
+func (s *EncryptSuite) sivaFile(c *C) []byte {
+	f, err := s.mem.Open("test.siva")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (s *EncryptSuite) TestReadWrite(c *C) {
+	for _, compression := range []Compression{NoCompression, Zstd} {
+		s.mem = memfs.New()
+		o := SivaFSOptions{Keys: s.keys, Compression: compression}
+
+		fs := s.open(c, o)
+		writeFile(c, fs, "big", s.data)
+		writeFile(c, fs, "empty", nil)
+		c.Assert(fs.Sync(), IsNil)
+
+		c.Assert(bytes.Contains(s.sivaFile(c), []byte("secret")), Equals, false)
+
+		fs = s.open(c, o)
+		testFileContent(c, fs, "big", string(s.data))
+		testFileContent(c, fs, "empty", "")
+
+		fi, err := fs.Stat("big")
+		c.Assert(err, IsNil)
+		c.Assert(fi.Size(), Equals, int64(len(s.data)))
+
+		f, err := fs.Open("big")
+		c.Assert(err, IsNil)
+		buf := make([]byte, 100)
+		_, err = f.ReadAt(buf, chunkSize-50)
+		c.Assert(err, IsNil)
+		c.Assert(buf, DeepEquals, s.data[chunkSize-50:chunkSize+50])
+		c.Assert(f.Close(), IsNil)
+	}
+}
+
+func (s *EncryptSuite) TestKeyRotation(c *C) {
+	fs := s.open(c, SivaFSOptions{Keys: s.keys})
+	writeFile(c, fs, "old", []byte("old"))
+	c.Assert(fs.Sync(), IsNil)
+
+	s.keys.current = "two"
+	fs = s.open(c, SivaFSOptions{Keys: s.keys})
+	writeFile(c, fs, "new", []byte("new"))
+	c.Assert(fs.Sync(), IsNil)
+
+	testFileContent(c, fs, "old", "old")
+	testFileContent(c, fs, "new", "new")
+}
+
+func (s *EncryptSuite) TestWrongKey(c *C) {
+	fs := s.open(c, SivaFSOptions{Keys: s.keys})
+	writeFile(c, fs, "file", []byte("data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	s.keys.keys["one"] = bytes.Repeat([]byte{9}, 32)
+	f, err := s.open(c, SivaFSOptions{Keys: s.keys}).Open("file")
+	c.Assert(err, IsNil)
+
+	_, err = ioutil.ReadAll(f)
+	var decryptErr *DecryptError
+	c.Assert(errors.As(err, &decryptErr), Equals, true)
+	c.Assert(decryptErr.Path, Equals, "file")
+	c.Assert(decryptErr.KeyID, Equals, "one")
+
+	delete(s.keys.keys, "one")
+	_, err = s.open(c, SivaFSOptions{Keys: s.keys}).Open("file")
+	c.Assert(errors.As(err, &decryptErr), Equals, true)
+
+	_, err = s.open(c, SivaFSOptions{}).Open("file")
+	c.Assert(errors.As(err, &decryptErr), Equals, true)
+	c.Assert(decryptErr.Err, Equals, ErrNoKeyProvider)
+}
+
+func (s *EncryptSuite) TestTampered(c *C) {
+	fs := s.open(c, SivaFSOptions{Keys: s.keys})
+	writeFile(c, fs, "file", []byte("some data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	blocks := readBlocks(c, s.mem, "test.siva")
+	data := s.sivaFile(c)
+	data[blocks[0].Offset(blocks[0].Entries[0])+40]++
+	c.Assert(util.WriteFile(s.mem, "test.siva", data, 0644), IsNil)
+
+	f, err := s.open(c, SivaFSOptions{Keys: s.keys}).Open("file")
+	c.Assert(err, IsNil)
+
+	_, err = io.Copy(ioutil.Discard, f)
+	var decryptErr *DecryptError
+	c.Assert(errors.As(err, &decryptErr), Equals, true)
+}
+
+func (s *EncryptSuite) TestMovedContents(c *C) {
+	fs := s.open(c, SivaFSOptions{Keys: s.keys})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	blocks := readBlocks(c, s.mem, "test.siva")
+	e := blocks[0].Entries[0]
+	stored := s.sivaFile(c)[blocks[0].Offset(e) : blocks[0].Offset(e)+e.Size]
+
+	// the contents of one stored as they are in another entry.
+	f, err := fs.(SivaCreateHeader).CreateHeader(&siva.Header{
+		Name:  "two",
+		Mode:  0644,
+		Flags: e.Flags,
+	})
+	c.Assert(err, IsNil)
+	_, err = f.Write(stored)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err = s.open(c, SivaFSOptions{Keys: s.keys}).Open("two")
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(f)
+	var decryptErr *DecryptError
+	c.Assert(errors.As(err, &decryptErr), Equals, true)
+}
+
+func (s *EncryptSuite) TestVersion(c *C) {
+	h, err := newEncryptionHeader(s.keys)
+	c.Assert(err, IsNil)
+
+	for _, version := range []uint8{0, 1, encryptionVersion + 1} {
+		h.Version = version
+		_, err = readEncryptionHeader(bytes.NewReader(h.marshal()))
+		c.Assert(err, Equals, ErrInvalidEncryptionHeader)
+	}
+}
+
+func (s *EncryptSuite) TestTruncated(c *C) {
+	fs := s.open(c, SivaFSOptions{Keys: s.keys})
+	writeFile(c, fs, "file", s.data)
+	c.Assert(fs.Sync(), IsNil)
+
+	blocks := readBlocks(c, s.mem, "test.siva")
+	e := blocks[0].Entries[0]
+	start := blocks[0].Offset(e)
+	stored := s.sivaFile(c)[start : start+e.Size]
+	footer, err := readChunkFooter(bytes.NewReader(stored), int64(len(stored)))
+	c.Assert(err, IsNil)
+	c.Assert(footer.Count > 1, Equals, true)
+
+	table := len(stored) - chunkFooterSize - 4*int(footer.Count)
+	prefix := table
+	for i := 0; i < int(footer.Count); i++ {
+		prefix -= int(binary.BigEndian.Uint32(stored[table+4*i:]))
+	}
+
+	first := prefix + int(binary.BigEndian.Uint32(stored[table:]))
+	truncated := map[string][]byte{
+		"no chunks":   stored[:prefix],
+		"first chunk": append(append([]byte(nil), stored[:first]...), stored[table:table+4]...),
+	}
+
+	for name, data := range truncated {
+		count := 0
+		if name == "first chunk" {
+			count = 1
+		}
+
+		var f [chunkFooterSize]byte
+		binary.BigEndian.PutUint32(f[0:4], chunkSize)
+		binary.BigEndian.PutUint32(f[12:16], uint32(count))
+		data = append(append([]byte(nil), data...), f[:]...)
+
+		// the truncated file claims to be empty.
+		s.mem = memfs.New()
+		sf, err := s.mem.Create("test.siva")
+		c.Assert(err, IsNil)
+		w := siva.NewWriter(sf)
+		c.Assert(w.WriteHeader(&siva.Header{Name: "file", Mode: 0644, Flags: e.Flags}), IsNil)
+		_, err = w.Write(data)
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		c.Assert(sf.Close(), IsNil)
+
+		file, err := s.open(c, SivaFSOptions{Keys: s.keys}).Open("file")
+		if err == nil {
+			_, err = ioutil.ReadAll(file)
+		}
+
+		c.Assert(err, NotNil, Commentf(name))
+	}
+}
+
+func (s *EncryptSuite) TestEncryptNames(c *C) {
+	o := SivaFSOptions{Keys: s.keys, EncryptNames: true}
+	fs := s.open(c, o)
+	writeFile(c, fs, "secret/a.txt", []byte("a"))
+	writeFile(c, fs, "secret/b.txt", []byte("b"))
+
+	files, err := fs.ReadDir("secret")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"a.txt", "b.txt"})
+	c.Assert(fs.Sync(), IsNil)
+
+	c.Assert(bytes.Contains(s.sivaFile(c), []byte("secret")), Equals, false)
+
+	fs = s.open(c, o)
+	testFileContent(c, fs, "secret/a.txt", "a")
+	c.Assert(fs.Remove("secret/a.txt"), IsNil)
+	c.Assert(fs.Remove("secret/missing.txt"), Equals, os.ErrNotExist)
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, o)
+	files, err = fs.ReadDir("secret")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"b.txt"})
+
+	deleted, err := fs.(indexedFS).deletedPaths()
+	c.Assert(err, IsNil)
+	c.Assert(deleted, DeepEquals, map[string]bool{"secret/a.txt": true})
+
+	_, err = s.open(c, SivaFSOptions{}).ReadDir("/")
+	var decryptErr *DecryptError
+	c.Assert(errors.As(err, &decryptErr), Equals, true)
+}
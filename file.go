@@ -1,35 +1,86 @@
 package sivafs
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-siva.v1"
 )
 
+// fileReader reads the contents of a file, either directly from the siva
+// file or decoding them.
+type fileReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
 type file struct {
+	ctx         context.Context
 	name        string
 	closeNotify func() error
 	isClosed    bool
 
-	w siva.Writer
-	r *io.SectionReader
+	w io.Writer
+	r fileReader
+
+	// mapped is the memory mapped siva file holding the contents of the
+	// file from offset, if they are stored as they are.
+	mapped *mmapFile
+	offset int64
+	size   int64
+}
+
+// fileRefs closes a siva file once its last user releases it.
+type fileRefs struct {
+	mu sync.Mutex
+	c  io.Closer
+	n  int
+}
+
+// newFileRefs returns the references of a siva file with a single user.
+func newFileRefs(c io.Closer) *fileRefs {
+	return &fileRefs{c: c, n: 1}
+}
+
+func (r *fileRefs) acquire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.n++
 }
 
-func newFile(filename string, w siva.Writer, closeNotify func() error) billy.File {
+func (r *fileRefs) release() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.n--
+	if r.n > 0 {
+		return nil
+	}
+
+	return r.c.Close()
+}
+
+func newFile(ctx context.Context, filename string, w io.Writer, closeNotify func() error) billy.File {
 	return &file{
+		ctx:         ctx,
 		name:        filepath.FromSlash(filename),
 		closeNotify: closeNotify,
 		w:           w,
 	}
 }
 
-func openFile(filename string, r *io.SectionReader) billy.File {
+func openFile(ctx context.Context, filename string, r fileReader, closeNotify func() error) *file {
 	return &file{
-		name: filepath.FromSlash(filename),
-		r:    r,
+		ctx:         ctx,
+		name:        filepath.FromSlash(filename),
+		closeNotify: closeNotify,
+		r:           r,
 	}
 }
 
@@ -42,6 +93,10 @@ func (f *file) Read(p []byte) (int, error) {
 		return 0, os.ErrClosed
 	}
 
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	if f.r == nil {
 		return 0, ErrWriteOnlyFile
 	}
@@ -54,6 +109,10 @@ func (f *file) ReadAt(b []byte, off int64) (int, error) {
 		return 0, os.ErrClosed
 	}
 
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	if f.r == nil {
 		return 0, ErrWriteOnlyFile
 	}
@@ -78,6 +137,10 @@ func (f *file) Write(p []byte) (int, error) {
 		return 0, os.ErrClosed
 	}
 
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	if f.w == nil {
 		return 0, ErrReadOnlyFile
 	}
@@ -104,6 +167,36 @@ func (f *file) Close() error {
 	return f.closeNotify()
 }
 
+// Bytes returns the contents of a file opened for reading without copying
+// them, as a slice of the memory mapped siva file. It returns ErrNotMapped if
+// the filesystem does not use a memory mapped siva file, see the MMap option,
+// or if the file is stored compressed. The slice must not be modified and it
+// is valid until the file is closed, even if the filesystem is synced before.
+func (f *file) Bytes() ([]byte, error) {
+	if f.isClosed {
+		return nil, os.ErrClosed
+	}
+
+	if f.r == nil {
+		return nil, ErrWriteOnlyFile
+	}
+
+	if f.mapped == nil {
+		return nil, ErrNotMapped
+	}
+
+	b, err := f.mapped.slice(f.offset, f.size)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(b)) < f.size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return b, nil
+}
+
 // Lock is a no-op. It's not implemented in the underlying siva library.
 func (f *file) Lock() error {
 	return nil
@@ -9,11 +9,15 @@ import (
 )
 
 type fileInfo struct {
-	e *siva.IndexEntry
+	e    *siva.IndexEntry
+	size int64
+	sys  func() interface{}
 }
 
-func newFileInfo(e *siva.IndexEntry) os.FileInfo {
-	return &fileInfo{e}
+// newFileInfo returns the FileInfo of the entry. sys returns the value of
+// Sys, it may be nil.
+func newFileInfo(e *siva.IndexEntry, size int64, sys func() interface{}) os.FileInfo {
+	return &fileInfo{e, size, sys}
 }
 
 func (f *fileInfo) Name() string {
@@ -21,7 +25,7 @@ func (f *fileInfo) Name() string {
 }
 
 func (f *fileInfo) Size() int64 {
-	return int64(f.e.Size)
+	return f.size
 }
 
 func (f *fileInfo) Mode() os.FileMode {
@@ -37,7 +41,11 @@ func (f *fileInfo) IsDir() bool {
 }
 
 func (f *fileInfo) Sys() interface{} {
-	return nil
+	if f.sys == nil {
+		return nil
+	}
+
+	return f.sys()
 }
 
 type dirFileInfo struct {
@@ -1,13 +1,20 @@
 package sivafs
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -34,10 +41,65 @@ const sivaCapabilities = billy.ReadCapability |
 type SivaSync interface {
 	// Sync closes any open files, this method should be called at the end of
 	// program to ensure that all the files are properly closed, otherwise the
-	// siva file will be corrupted.
+	// siva file will be corrupted. Files opened for reading are not closed:
+	// they keep the siva file they read from open until they are closed.
 	Sync() error
 }
 
+// SivaBytes is implemented by siva filesystems able to return the contents of
+// their files without copying them.
+type SivaBytes interface {
+	// Bytes returns the contents of the file as a slice of the memory mapped
+	// siva file. It returns ErrNotMapped if the siva file is not memory
+	// mapped, see the MMap option, or if the file is stored compressed. The
+	// slice must not be modified. It is valid until the returned io.Closer
+	// is closed, which keeps the siva file mapped even if the filesystem is
+	// synced before.
+	Bytes(path string) ([]byte, io.Closer, error)
+}
+
+// SivaCreateHeader is implemented by siva filesystems able to create files
+// from a siva header, keeping its modification time.
+type SivaCreateHeader interface {
+	// CreateHeader creates a new file with the name, mode, modification time
+	// and flags of the given header. FlagDeleted and the flags used
+	// internally by deduplicated files are ignored. Like Create the file is
+	// opened write only.
+	CreateHeader(h *siva.Header) (billy.File, error)
+}
+
+// indexedFS is implemented by the filesystems of this package, giving access
+// to their siva index.
+type indexedFS interface {
+	// liveIndex returns the index of the files of the filesystem.
+	liveIndex() (siva.OrderedIndex, error)
+	// deletedPaths returns the paths whose newest entry is a deletion.
+	deletedPaths() (map[string]bool, error)
+	// writeTombstone marks the path as deleted, even when the filesystem
+	// does not have a file with that path.
+	writeTombstone(path string) error
+	// openRaw returns the index entry of the file and a reader of the bytes
+	// stored for it in the siva file.
+	openRaw(path string) (*siva.IndexEntry, io.Reader, error)
+	// fileInfo returns the FileInfo of an entry of the index.
+	fileInfo(e *siva.IndexEntry) (os.FileInfo, error)
+	// copyFile writes the file of the entry of src, another filesystem of
+	// this package, keeping its header, hash and metadata. See Merge.
+	copyFile(src SivaFS, e *siva.IndexEntry) error
+	// generation returns a number increased every time the index changes,
+	// so views built from it can be cached.
+	generation() uint64
+}
+
+// sivaRoot holds the siva specific methods that every root siva filesystem
+// implements. The optional interfaces, like SivaHistory, are forwarded by the
+// helper filesystems only if the root implements them, see forwarded.
+type sivaRoot interface {
+	SivaSync
+	indexedFS
+	contextFS
+}
+
 type SivaBasicFS interface {
 	billy.Basic
 	billy.Dir
@@ -59,16 +121,128 @@ type SivaFSOptions struct {
 	// Offset specifies the offset of the index. If it is 0 then the latest
 	// index is used. This is only usable in read only mode.
 	Offset uint64
+	// Snapshot opens the siva file as it was when the snapshot with the
+	// given name was taken, see SivaSnapshot. Offset is ignored. This is
+	// only usable in read only mode.
+	Snapshot string
+	// MMap memory maps the siva file when it is stored in the OS filesystem,
+	// so file contents are read without a system call per read and can be
+	// accessed without copying them, see SivaBytes. It is ignored for other
+	// filesystems or on platforms without mmap. The siva file must not be
+	// truncated by other processes while mapped; reads past its end would
+	// crash the program. Rollback and Compact are safe.
+	MMap bool
+	// Compression compresses the contents of new files with the given
+	// algorithm. Files are compressed in independent chunks so they can still
+	// be read at any offset. Files stored uncompressed can be read with any
+	// value. The algorithm is kept in bits 8 to 11 of the entry flags, which
+	// siva and older versions of this package ignore: they read the chunks
+	// of compressed files as their contents instead of failing.
+	Compression Compression
+	// Keys encrypts the contents of new files with AES-GCM, using the current
+	// key of the provider. Files are encrypted in independent chunks so they
+	// can still be read at any offset. It is also needed to read encrypted
+	// files.
+	Keys KeyProvider
+	// EncryptNames hides the names of new files, storing a hash of them made
+	// with the key NamesKeyID. The real names are encrypted with the file
+	// contents. It needs Keys.
+	EncryptNames bool
+	// Dedup stores files with the same contents only once. New files are
+	// kept in memory up to 64KiB and spooled to the temporary filesystem
+	// afterwards, or streamed to a blob deleted if the contents were already
+	// stored for filesystems created without one. Files removed keep their contents stored while
+	// other files use them. Contents are matched by their SHA-256, so they
+	// keep being shared after the current key of the Keys option changes.
+	Dedup bool
+	// Hash stores the hash of the contents of new files computed with the
+	// given function, usually crypto.SHA256, next to their CRC32. It can be
+	// read with SivaHash or from the Sys method of their FileInfo, see
+	// EntryInfo. The function must be linked into the binary.
+	Hash crypto.Hash
+	// SigningKey signs the index block written by every Sync with ed25519,
+	// covering its index and the hash of the contents of every entry. The
+	// signature is written in a block of its own. See VerifySignatures.
+	SigningKey ed25519.PrivateKey
+	// SignScope sets the blocks covered by each signature, by default only
+	// the block written by the Sync.
+	SignScope SignScope
+	// VerifyKey rejects siva files with blocks not signed with the given
+	// key, or modified after being signed, returning a SignatureError. The
+	// contents of every entry are hashed once by the filesystem, so reopening
+	// the siva file after a Sync only reads the contents written since.
+	VerifyKey ed25519.PublicKey
+	// Compaction compacts the siva file when it matches the policy, checked
+	// by every Sync writing to it. See SivaCompact.
+	Compaction *CompactionPolicy
+	// Observer receives the events of the filesystem, such as reads, writes
+	// or index loads, with their sizes and durations.
+	Observer Observer
 }
 
 type sivaFS struct {
+	*sivaState
+	// ctx cancels the operations of the filesystem, see SivaContext.
+	ctx context.Context
+}
+
+// sivaState is the state of a siva filesystem, shared with the filesystems
+// returned by WithContext.
+type sivaState struct {
 	mu sync.Mutex
 
 	underlying billy.Filesystem
 	path       string
-	f          billy.File
+	f          sivaFile
 	rw         *siva.ReadWriter
 	r          siva.Reader
+	// refs counts the users of f, the filesystem and the files read from
+	// it, so files opened before a compaction keep reading the replaced
+	// siva file until they are closed.
+	refs *fileRefs
+	// reading counts the files read from any siva file opened, see
+	// Compact.
+	reading int64
+	// end is the offset where the last index block of the opened siva file
+	// ends.
+	end uint64
+	// deleted holds the paths whose newest entry in the index blocks is a
+	// deletion. It is loaded lazily, see getDeleted.
+	deleted map[string]bool
+	// written holds the paths written since the siva file was opened, set
+	// to true for deletions.
+	written map[string]bool
+	// names holds the names of the files with encrypted names by their
+	// stored name.
+	names   map[string]string
+	namesMu sync.Mutex
+	// stats holds the statistics of the index blocks read by Stats.
+	stats statsCache
+	// changes counts the changes of the index, see generation.
+	changes uint64
+	// sizes holds the sizes of the chunked entries read by fileInfo, by
+	// the offset of the entry.
+	sizes map[int64]int64
+	// blobs holds the entries of the blobs by their ID, built from the
+	// index with the generation blobsGen. See getBlobs.
+	blobs    map[string]*siva.IndexEntry
+	blobsGen uint64
+	// sums holds the SHA-256 of the contents of the entries signed or
+	// verified, see contentSums.
+	sums contentSums
+	// hashes holds the paths of the files by their hash, built from the
+	// sidecars with the generation hashesGen. See getHashes.
+	hashes    map[string][]string
+	hashesGen uint64
+	// blobSums holds the IDs of the blobs by the SHA-256 of their
+	// contents, see getBlobSums.
+	blobSums map[string]string
+	// tmp holds the files spooled by Dedup, nil for filesystems created
+	// without a temporary filesystem.
+	tmp billy.Filesystem
+
+	readerAt io.ReaderAt
+	size     int64
 
 	fileWriteModeOpen bool
 	options           SivaFSOptions
@@ -87,10 +261,17 @@ func New(fs billy.Filesystem, path string) SivaBasicFS {
 // NewWithOptions creates a new siva backed filesystem and accepts options.
 // See New documentation.
 func NewWithOptions(fs billy.Filesystem, path string, o SivaFSOptions) SivaBasicFS {
+	return newSivaFS(fs, path, o)
+}
+
+func newSivaFS(fs billy.Filesystem, path string, o SivaFSOptions) *sivaFS {
 	return &sivaFS{
-		underlying: fs,
-		path:       path,
-		options:    o,
+		sivaState: &sivaState{
+			underlying: fs,
+			path:       path,
+			options:    o,
+		},
+		ctx: context.Background(),
 	}
 }
 
@@ -110,32 +291,18 @@ func NewFilesystemWithOptions(
 	tmpFs billy.Filesystem,
 	o SivaFSOptions,
 ) (SivaFS, error) {
-	tempdir := "/tmp"
-
-	if !o.ReadOnly && o.Offset != 0 {
+	if !o.ReadOnly && (o.Offset != 0 || o.Snapshot != "") {
 		return nil, ErrOffsetReadWrite
 	}
 
-	root := NewWithOptions(fs, path, o)
+	root := newSivaFS(fs, path, o)
+	root.tmp = tmpFs
 
 	if o.ReadOnly {
-		ro := &readOnly{
-			Filesystem: chroot.New(root, "/"),
-			SivaSync:   root,
-		}
-
-		return ro, nil
-	}
-
-	m := mount.New(root, tempdir, tmpFs)
-
-	t := &temp{
-		defaultDir: tempdir,
-		SivaSync:   root,
-		Filesystem: chroot.New(m, "/"),
+		return newReadOnly(root), nil
 	}
 
-	return t, nil
+	return newTemp(root, tmpFs), nil
 }
 
 // NewFilesystemReadOnly creates a read only filesystem backed by a siva file.
@@ -177,16 +344,29 @@ func (fs *sivaFS) OpenFile(path string, flag int, mode os.FileMode) (billy.File,
 	}
 
 	if flag&os.O_CREATE != 0 {
-		if fs.fileWriteModeOpen {
-			return nil, ErrFileWriteModeAlreadyOpen
-		}
-
-		return fs.createFile(path, flag, mode)
+		return fs.create(path, flag, mode, nil)
 	}
 
 	return fs.openFile(path, flag, mode)
 }
 
+// create creates a new file with the given metadata, if any.
+func (fs *sivaFS) create(path string, flag int, mode os.FileMode, meta map[string]string) (billy.File, error) {
+	if fs.fileWriteModeOpen {
+		return nil, ErrFileWriteModeAlreadyOpen
+	}
+
+	if isReserved(path) {
+		return nil, ErrReservedPath
+	}
+
+	return fs.createFile(&siva.Header{
+		Name:    path,
+		Mode:    mode,
+		ModTime: time.Now(),
+	}, flag, meta)
+}
+
 func (fs *sivaFS) Stat(p string) (os.FileInfo, error) {
 	p = normalizePath(p)
 
@@ -201,10 +381,10 @@ func (fs *sivaFS) Stat(p string) (os.FileInfo, error) {
 
 	e := index.Find(p)
 	if e != nil {
-		return newFileInfo(e), nil
+		return fs.fileInfo(e)
 	}
 
-	stat, err := getDir(index, p)
+	stat, err := getDir(fs.ctx, index, p)
 	if err != nil {
 		return nil, err
 	}
@@ -228,12 +408,12 @@ func (fs *sivaFS) ReadDir(path string) ([]os.FileInfo, error) {
 		return nil, err
 	}
 
-	files, err := listFiles(index, path)
+	files, err := listFiles(fs.ctx, index, path, fs.fileInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	dirs, err := listDirs(index, path)
+	dirs, err := listDirs(fs.ctx, index, path)
 	if err != nil {
 		return nil, err
 	}
@@ -292,15 +472,10 @@ func (fs *sivaFS) Remove(path string) error {
 	e := index.Find(path)
 
 	if e != nil {
-		return fs.getReadWriter().WriteHeader(&siva.Header{
-			Name:    path,
-			ModTime: time.Now(),
-			Mode:    0,
-			Flags:   siva.FlagDeleted,
-		})
+		return fs.deleteFile(path)
 	}
 
-	dir, err := getDir(index, path)
+	dir, err := getDir(fs.ctx, index, path)
 	if err != nil {
 		return err
 	}
@@ -322,7 +497,124 @@ func (fs *sivaFS) Rename(from, to string) error {
 }
 
 func (fs *sivaFS) Sync() error {
-	return fs.ensureClosed()
+	writing := fs.getReadWriter() != nil
+	if err := fs.ensureClosed(); err != nil {
+		return err
+	}
+
+	if !writing || fs.options.Compaction == nil {
+		return nil
+	}
+
+	return fs.compactIfNeeded()
+}
+
+func (fs *sivaFS) liveIndex() (siva.OrderedIndex, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	return fs.getIndex()
+}
+
+func (fs *sivaFS) deletedPaths() (map[string]bool, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	return fs.getDeleted()
+}
+
+func (fs *sivaFS) writeTombstone(path string) error {
+	if err := fs.ensureOpen(); err != nil {
+		return err
+	}
+
+	if fs.getReadWriter() == nil {
+		return ErrReadOnlyFilesystem
+	}
+
+	return fs.deleteFile(normalizePath(path))
+}
+
+func (fs *sivaFS) openRaw(path string) (*siva.IndexEntry, io.Reader, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, nil, err
+	}
+
+	index, err := fs.getIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := index.Find(normalizePath(path))
+	if e == nil {
+		return nil, nil, os.ErrNotExist
+	}
+
+	if e.Flags&flagReference != 0 {
+		// the contents of the blob are returned as if they were stored
+		// in the file.
+		blob, err := fs.resolveReference(e)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		copied := *blob
+		copied.Header = e.Header
+		copied.Flags = blob.Flags
+		e = &copied
+	}
+
+	r, err := fs.getReader().Get(e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e, r, nil
+}
+
+// CreateHeader implements SivaCreateHeader interface.
+func (fs *sivaFS) CreateHeader(h *siva.Header) (billy.File, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	if fs.getReadWriter() == nil {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	if fs.fileWriteModeOpen {
+		return nil, ErrFileWriteModeAlreadyOpen
+	}
+
+	path := normalizePath(h.Name)
+	if isReserved(path) {
+		return nil, ErrReservedPath
+	}
+
+	return fs.createFile(&siva.Header{
+		Name:    path,
+		Mode:    h.Mode,
+		ModTime: h.ModTime,
+		Flags:   h.Flags &^ (siva.FlagDeleted | flagReference),
+	}, os.O_WRONLY, nil)
+}
+
+// Bytes implements SivaBytes interface.
+func (fs *sivaFS) Bytes(path string) ([]byte, io.Closer, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := f.(*file).Bytes()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return b, f, nil
 }
 
 // Capability implements billy.Capable interface.
@@ -331,20 +623,76 @@ func (fs *sivaFS) Capabilities() billy.Capability {
 }
 
 func (fs *sivaFS) ensureOpen() error {
+	if err := fs.ctx.Err(); err != nil {
+		return err
+	}
+
 	if fs.getReader() != nil {
 		return nil
 	}
 
+	start := time.Now()
+	if err := fs.openSiva(); err != nil {
+		fs.observe(OpOpen, fs.path, 0, start, err)
+		return err
+	}
+
+	fs.observe(OpOpen, fs.path, int64(fs.end), start, nil)
+	return nil
+}
+
+// openSiva opens the siva file, for reading only if the ReadOnly option is
+// set.
+func (fs *sivaFS) openSiva() error {
 	if fs.options.ReadOnly {
-		f, err := fs.underlying.Open(fs.path)
+		f, err := fs.openReadOnly()
 		if err != nil {
 			return err
 		}
 
-		r := siva.NewReaderWithOffset(f, fs.options.Offset)
+		if bf, ok := f.(billy.File); ok && fs.options.MMap {
+			f = newMmapFile(fs.underlying, fs.path, bf)
+		}
+
+		offset := fs.options.Offset
+		if fs.options.Snapshot != "" {
+			size, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				f.Close()
+				return err
+			}
+
+			offset, err = fs.snapshotOffset(f, uint64(size), fs.options.Snapshot)
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		end := offset
+		if end == 0 {
+			size, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				f.Close()
+				return err
+			}
+
+			end = uint64(size)
+		}
+
+		if fs.options.VerifyKey != nil {
+			if err := fs.checkSignatures(f, end); err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		r := siva.NewReaderWithOffset(f, offset)
 
 		fs.setReader(r)
 		fs.f = f
+		fs.refs = newFileRefs(f)
+		fs.end = end
 		return nil
 	}
 
@@ -353,18 +701,47 @@ func (fs *sivaFS) ensureOpen() error {
 		return err
 	}
 
+	if fs.options.MMap {
+		f = newMmapFile(fs.underlying, fs.path, f)
+	}
+
 	rw, err := siva.NewReaderWriter(f)
 	if err != nil {
 		f.Close()
 		return err
 	}
 
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if fs.options.VerifyKey != nil {
+		if err := fs.checkSignatures(f, uint64(end)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
 	fs.setReadWriter(rw)
 	fs.setReader(rw)
 	fs.f = f
+	fs.refs = newFileRefs(f)
+	fs.end = uint64(end)
 	return nil
 }
 
+// openReadOnly opens the siva file for reading, either from the underlying
+// filesystem or from the ReaderAt given to the filesystem.
+func (fs *sivaFS) openReadOnly() (sivaFile, error) {
+	if fs.readerAt != nil {
+		return &sectionFile{io.NewSectionReader(fs.readerAt, 0, fs.size)}, nil
+	}
+
+	return fs.underlying.Open(fs.path)
+}
+
 func (fs *sivaFS) getReader() siva.Reader {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -398,35 +775,129 @@ func (fs *sivaFS) ensureClosed() error {
 		return nil
 	}
 
+	start := time.Now()
 	if fs.getReadWriter() != nil {
-		if err := fs.rw.Close(); err != nil {
+		if err := fs.flush(); err != nil {
 			return err
 		}
 	}
 
 	fs.setReadWriter(nil)
+	err := fs.release()
+	fs.observe(OpClose, fs.path, 0, start, err)
+	return err
+}
+
+// release forgets the opened siva file, closing it if there are no files read
+// from it left.
+func (fs *sivaFS) release() error {
 	fs.setReader(nil)
+	fs.changed()
 
-	f := fs.f
+	refs := fs.refs
 	fs.f = nil
-	return f.Close()
+	fs.refs = nil
+	fs.deleted = nil
+	fs.written = nil
+	fs.blobSums = nil
+	fs.setSizes(nil)
+
+	return refs.release()
 }
 
-func (fs *sivaFS) createFile(path string, flag int, mode os.FileMode) (billy.File, error) {
+// flush writes the index block of the entries written since the siva file was
+// opened, signing it if the SigningKey option is set.
+func (fs *sivaFS) flush() error {
+	if err := fs.ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := fs.rw.Close()
+	if err == nil && fs.options.SigningKey != nil {
+		err = fs.signWritten()
+	}
+
+	if err != nil {
+		fs.observe(OpFlush, fs.path, 0, start, err)
+		return err
+	}
+
+	end, err := fs.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if size := end - int64(fs.end); size > 0 {
+		fs.observe(OpFlush, fs.path, size, start, nil)
+	}
+
+	return nil
+}
+
+// createFile writes a new file with the given header. Its metadata, if any,
+// is stored once closed.
+func (fs *sivaFS) createFile(header *siva.Header, flag int, meta map[string]string) (billy.File, error) {
 	if flag&os.O_RDWR != 0 || flag&os.O_RDONLY != 0 {
 		return nil, billy.ErrNotSupported
 	}
 
-	header := &siva.Header{
-		Name:    path,
-		Mode:    mode,
-		ModTime: time.Now(),
+	path := header.Name
+	raw := isChunked(header.Flags)
+	// regular tells if the contents are written by the user, not stored as
+	// they are nor used internally.
+	regular := !raw && !isReserved(path) && header.Flags&flagReference == 0
+
+	var h hash.Hash
+	if regular {
+		var err error
+		if h, err = fs.newHash(); err != nil {
+			return nil, err
+		}
+	}
+
+	if fs.options.Dedup && regular {
+		return fs.createDedup(header, h, meta)
+	}
+
+	var w io.Writer = fs.getReadWriter()
+	var cw *chunkWriter
+	if !raw {
+		codec, prefix, flags, err := fs.newCodec(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if codec != nil {
+			header.Flags |= flags
+			cw = newChunkWriter(w, prefix, codec)
+			w = cw
+		}
+	}
+
+	if header.Flags&flagEncryptedName != 0 {
+		stored, err := hashName(fs.options.Keys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		fs.setName(stored, path)
+		header.Name = stored
+	}
+
+	// prev is the entry replaced, restored if the write is cancelled.
+	index, err := fs.getReadWriter().Index()
+	if err != nil {
+		return nil, err
 	}
 
+	prev := siva.OrderedIndex(index).Find(siva.ToSafePath(header.Name))
 	if err := fs.getReadWriter().WriteHeader(header); err != nil {
 		return nil, err
 	}
 
+	fs.setWritten(path, false)
+
 	closeFunc := func() error {
 		if fs.getReadWriter() == nil {
 			return nil
@@ -436,11 +907,89 @@ func (fs *sivaFS) createFile(path string, flag int, mode os.FileMode) (billy.Fil
 			fs.fileWriteModeOpen = false
 		}
 
-		return fs.getReadWriter().Flush()
+		if cw != nil {
+			if err := cw.Close(); err != nil {
+				return err
+			}
+		}
+
+		if err := fs.getReadWriter().Flush(); err != nil {
+			return err
+		}
+
+		if err := fs.ctx.Err(); err != nil {
+			if derr := fs.dropWritten(path, header.Name, prev); derr != nil {
+				return derr
+			}
+
+			return err
+		}
+
+		return fs.writeFileSidecar(header.Name, h, meta)
+	}
+
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+
+	if fs.options.Observer != nil {
+		w = &observedWriter{Writer: w, fs: fs, path: path}
 	}
 
 	defer func() { fs.fileWriteModeOpen = true }()
-	return newFile(path, fs.getReadWriter(), closeFunc), nil
+	return newFile(fs.ctx, path, w, closeFunc), nil
+}
+
+// dropWritten undoes the file with the given path and stored name, whose
+// write was cancelled, writing again the entry it replaced, if any, along
+// with its sidecar, or a tombstone otherwise. It is not cancelled itself.
+func (fs *sivaFS) dropWritten(path, stored string, prev *siva.IndexEntry) error {
+	bg := &sivaFS{sivaState: fs.sivaState, ctx: context.Background()}
+	if prev == nil {
+		return bg.deleteFile(path)
+	}
+
+	index, err := bg.getFullIndex()
+	if err != nil {
+		return err
+	}
+
+	named := *prev
+	named.Name = path
+	s, err := bg.readSidecar(index, &named)
+	if err != nil {
+		return err
+	}
+
+	r, err := bg.getReader().Get(prev)
+	if err != nil {
+		return err
+	}
+
+	rw := bg.getReadWriter()
+	err = rw.WriteHeader(&siva.Header{
+		Name:    stored,
+		Mode:    prev.Mode,
+		ModTime: prev.ModTime,
+		Flags:   prev.Flags,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(rw, r); err != nil {
+		return err
+	}
+
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	return bg.writeSidecar(stored, &sidecar{Hash: s.Hash, Sum: s.Sum, Meta: s.Meta})
 }
 
 func (fs *sivaFS) openFile(path string, flag int, mode os.FileMode) (billy.File, error) {
@@ -458,28 +1007,502 @@ func (fs *sivaFS) openFile(path string, flag int, mode os.FileMode) (billy.File,
 		return nil, os.ErrNotExist
 	}
 
+	e, err = fs.contentEntry(e)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := fs.contentReader(e)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.options.Observer != nil {
+		r = &observedReader{fileReader: r, fs: fs, path: path}
+	}
+
+	refs := fs.refs
+	refs.acquire()
+	atomic.AddInt64(&fs.reading, 1)
+	f := openFile(fs.ctx, path, r, func() error {
+		atomic.AddInt64(&fs.reading, -1)
+		return refs.release()
+	})
+	if m, ok := fs.f.(*mmapFile); ok && !isChunked(e.Flags) {
+		f.mapped, f.offset, f.size = m, entryOffset(e), int64(e.Size)
+	}
+
+	return f, nil
+}
+
+// contentEntry returns the entry holding the contents of the given one: the
+// blob it references, if any, or itself.
+func (fs *sivaFS) contentEntry(e *siva.IndexEntry) (*siva.IndexEntry, error) {
+	if e.Flags&flagReference == 0 {
+		return e, nil
+	}
+
+	return fs.resolveReference(e)
+}
+
+// contentReader returns a reader of the contents of the entry, decoding them
+// if they are stored chunked.
+func (fs *sivaFS) contentReader(e *siva.IndexEntry) (fileReader, error) {
 	sr, err := fs.getReader().Get(e)
 	if err != nil {
 		return nil, err
 	}
 
-	return openFile(path, sr), nil
+	if !isChunked(e.Flags) {
+		return sr, nil
+	}
+
+	return newChunkReader(sr, sr.Size(), fs.entryCodec(e))
+}
+
+// newCodec returns the codec of new files, the prefix written before their
+// chunks and the flags telling how they are encoded. The codec is nil if
+// files are stored as they are.
+func (fs *sivaFS) newCodec(path string) (chunkCodec, []byte, siva.Flag, error) {
+	var codecs chainCodec
+	var prefix []byte
+	var flags siva.Flag
+
+	if c := fs.options.Compression; c != NoCompression {
+		codec, err := compressionCodec(c)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		codecs = append(codecs, codec)
+		flags |= c.flag()
+	}
+
+	if keys := fs.options.Keys; keys != nil {
+		h, err := newEncryptionHeader(keys)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		stored := path
+		encryptName := fs.options.EncryptNames && !isReserved(path)
+		if encryptName {
+			if stored, err = hashName(keys, path); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+
+		codec, err := newAEADCodec(keys, h, path, stored)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		if encryptName {
+			codec.sealName(path)
+			flags |= flagEncryptedName
+		}
+
+		codecs = append(codecs, codec)
+		prefix = h.marshal()
+		flags |= flagEncrypted
+	}
+
+	if len(codecs) == 0 {
+		return nil, nil, 0, nil
+	}
+
+	return codecs, prefix, flags, nil
+}
+
+// entryCodec returns the function giving the codec of the chunked entry.
+func (fs *sivaFS) entryCodec(e *siva.IndexEntry) codecFunc {
+	return func(prefix []byte) (chunkCodec, error) {
+		var codecs chainCodec
+		if c := compressionFromFlags(e.Flags); c != NoCompression {
+			codec, err := compressionCodec(c)
+			if err != nil {
+				return nil, err
+			}
+
+			codecs = append(codecs, codec)
+		}
+
+		if e.Flags&flagEncrypted == 0 {
+			if len(prefix) != 0 {
+				return nil, ErrInvalidChunks
+			}
+
+			return codecs, nil
+		}
+
+		h, err := readEncryptionHeader(bytes.NewReader(prefix))
+		if err != nil {
+			return nil, err
+		}
+
+		stored, err := fs.storedName(e)
+		if err != nil {
+			return nil, err
+		}
+
+		codec, err := fs.openAEAD(e.Name, stored, h)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(codecs, codec), nil
+	}
+}
+
+// openAEAD returns the codec decrypting the file with the given path, stored
+// name and encryption header.
+func (fs *sivaFS) openAEAD(path, stored string, h *encryptionHeader) (*aeadCodec, error) {
+	if fs.options.Keys == nil {
+		return nil, &DecryptError{Path: path, KeyID: h.KeyID, Err: ErrNoKeyProvider}
+	}
+
+	codec, err := newAEADCodec(fs.options.Keys, h, path, stored)
+	if err != nil {
+		return nil, &DecryptError{Path: path, KeyID: h.KeyID, Err: err}
+	}
+
+	return codec, nil
+}
+
+// realName returns the name of the file with the given stored name, reading
+// its encryption header from r if it is not known yet.
+func (fs *sivaFS) realName(stored string, r io.Reader) (string, error) {
+	fs.namesMu.Lock()
+	name, ok := fs.names[stored]
+	fs.namesMu.Unlock()
+	if ok {
+		return name, nil
+	}
+
+	h, err := readEncryptionHeader(r)
+	if err != nil {
+		return "", err
+	}
+
+	codec, err := fs.openAEAD(stored, stored, h)
+	if err != nil {
+		return "", err
+	}
+
+	name, err = codec.openName()
+	if err != nil {
+		return "", err
+	}
+
+	if !fs.options.UnsafePaths {
+		name = siva.ToSafePath(name)
+	}
+
+	fs.setName(stored, name)
+	return name, nil
+}
+
+func (fs *sivaFS) setName(stored, name string) {
+	fs.namesMu.Lock()
+	defer fs.namesMu.Unlock()
+
+	if fs.names == nil {
+		fs.names = make(map[string]string)
+	}
+
+	fs.names[stored] = name
+}
+
+// resolveNames returns the index with the real names of the files with
+// encrypted names. Their entries are copied, so the original index is not
+// modified.
+func (fs *sivaFS) resolveNames(index siva.OrderedIndex) (siva.OrderedIndex, error) {
+	var resolved siva.OrderedIndex
+	for i, e := range index {
+		if e.Flags&flagEncryptedName == 0 {
+			continue
+		}
+
+		if err := checkContext(fs.ctx, i); err != nil {
+			return nil, err
+		}
+
+		if resolved == nil {
+			resolved = append(siva.OrderedIndex(nil), index...)
+		}
+
+		r, err := fs.getReader().Get(e)
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := fs.realName(e.Name, r)
+		if err != nil {
+			return nil, err
+		}
+
+		copied := *e
+		copied.Name = name
+		resolved[i] = &copied
+	}
+
+	if resolved == nil {
+		return index, nil
+	}
+
+	resolved.Sort()
+	return resolved, nil
+}
+
+// fileInfo returns the FileInfo of the entry. The size of chunked files is
+// read from the siva file.
+func (fs *sivaFS) fileInfo(e *siva.IndexEntry) (os.FileInfo, error) {
+	content := e
+	if e.Flags&flagReference != 0 {
+		blob, err := fs.resolveReference(e)
+		if err != nil {
+			return nil, err
+		}
+
+		content = blob
+	}
+
+	if !isChunked(content.Flags) {
+		return newFileInfo(e, int64(content.Size), fs.sysFunc(e)), nil
+	}
+
+	size, err := fs.chunkedSize(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(e, size, fs.sysFunc(e)), nil
+}
+
+// chunkedSize returns the size of the contents of a chunked entry, read from
+// its footer the first time.
+func (fs *sivaFS) chunkedSize(e *siva.IndexEntry) (int64, error) {
+	offset := entryOffset(e)
+	fs.mu.Lock()
+	size, ok := fs.sizes[offset]
+	fs.mu.Unlock()
+	if ok {
+		return size, nil
+	}
+
+	sr, err := fs.getReader().Get(e)
+	if err != nil {
+		return 0, err
+	}
+
+	footer, err := readChunkFooter(sr, sr.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.sizes == nil {
+		fs.sizes = make(map[int64]int64)
+	}
+
+	fs.sizes[offset] = int64(footer.Size)
+	return int64(footer.Size), nil
+}
+
+func (fs *sivaFS) setSizes(sizes map[int64]int64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.sizes = sizes
+}
+
+// deleteFile writes a deletion entry for the path.
+func (fs *sivaFS) deleteFile(path string) error {
+	rw := fs.getReadWriter()
+	index, err := rw.Index()
+	if err != nil {
+		return err
+	}
+
+	stored := path
+	flags := siva.FlagDeleted
+	var prefix []byte
+	if fs.options.EncryptNames && siva.OrderedIndex(index).Find(path) == nil {
+		// the name is hidden in the deletion entry like in files.
+		stored, prefix, err = fs.sealedName(path)
+		if err != nil {
+			return err
+		}
+
+		flags |= flagEncrypted | flagEncryptedName
+	}
+
+	now := time.Now()
+	if deleteFails(siva.OrderedIndex(index), stored) {
+		if err := rw.WriteHeader(&siva.Header{Name: stored, ModTime: now}); err != nil {
+			return err
+		}
+	}
+
+	err = rw.WriteHeader(&siva.Header{
+		Name:    stored,
+		ModTime: now,
+		Mode:    0,
+		Flags:   flags,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if prefix != nil {
+		if _, err := rw.Write(prefix); err != nil {
+			return err
+		}
+
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fs.setWritten(path, true)
+	return nil
+}
+
+// sealedName returns the stored name of the path when names are encrypted
+// and an encryption header holding the name.
+func (fs *sivaFS) sealedName(path string) (string, []byte, error) {
+	stored, err := hashName(fs.options.Keys, path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	h, err := newEncryptionHeader(fs.options.Keys)
+	if err != nil {
+		return "", nil, err
+	}
+
+	codec, err := newAEADCodec(fs.options.Keys, h, path, stored)
+	if err != nil {
+		return "", nil, err
+	}
+
+	codec.sealName(path)
+	fs.setName(stored, path)
+	return stored, h.marshal(), nil
+}
+
+func (fs *sivaFS) setWritten(path string, deleted bool) {
+	if fs.written == nil {
+		fs.written = make(map[string]bool)
+	}
+
+	fs.written[path] = deleted
+	fs.changed()
+}
+
+func (fs *sivaFS) changed() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.changes++
+}
+
+func (fs *sivaFS) generation() uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.changes
+}
+
+// getDeleted returns the paths whose newest entry is a deletion. The ones in
+// the index blocks are read the first time it is called.
+func (fs *sivaFS) getDeleted() (map[string]bool, error) {
+	if fs.deleted == nil {
+		blocks, err := readIndexBlocks(fs.f, fs.end)
+		if err != nil {
+			return nil, err
+		}
+
+		fs.deleted = make(map[string]bool)
+		for name, e := range latestEntries(blocks) {
+			if e.Flags&siva.FlagDeleted == 0 {
+				continue
+			}
+
+			if e.Flags&flagEncryptedName != 0 {
+				r := io.NewSectionReader(fs.f,
+					int64(e.Block.Offset(e.IndexEntry)), int64(e.Size))
+				name, err = fs.realName(name, r)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if !fs.options.UnsafePaths {
+				name = siva.ToSafePath(name)
+			}
+
+			if isReserved(name) {
+				continue
+			}
+
+			fs.deleted[name] = true
+		}
+	}
+
+	deleted := make(map[string]bool, len(fs.deleted))
+	for name := range fs.deleted {
+		deleted[name] = true
+	}
+
+	for name, d := range fs.written {
+		if d {
+			deleted[name] = true
+		} else {
+			delete(deleted, name)
+		}
+	}
+
+	return deleted, nil
 }
 
+// getIndex returns the index of the files of the filesystem.
 func (fs *sivaFS) getIndex() (siva.OrderedIndex, error) {
+	index, err := fs.getFullIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return visibleEntries(index), nil
+}
+
+// getFullIndex returns the index including the entries used internally.
+func (fs *sivaFS) getFullIndex() (siva.OrderedIndex, error) {
+	start := time.Now()
+	index, err := fs.loadIndex()
+	fs.observe(OpIndex, fs.path, int64(len(index)), start, err)
+	return index, err
+}
+
+func (fs *sivaFS) loadIndex() (siva.OrderedIndex, error) {
 	index, err := fs.getReader().Index()
 	if err != nil {
 		return nil, err
 	}
 
-	if fs.options.UnsafePaths {
-		return siva.OrderedIndex(index), nil
+	if !fs.options.UnsafePaths {
+		index = index.ToSafePaths()
 	}
 
-	return siva.OrderedIndex(index.ToSafePaths()), nil
+	return fs.resolveNames(siva.OrderedIndex(index))
 }
 
-func listFiles(index siva.OrderedIndex, dir string) ([]os.FileInfo, error) {
+// infoFunc returns the FileInfo of an index entry.
+type infoFunc func(e *siva.IndexEntry) (os.FileInfo, error)
+
+func listFiles(ctx context.Context, index siva.OrderedIndex, dir string, info infoFunc) ([]os.FileInfo, error) {
 	dir = addTrailingSlash(dir)
 
 	entries, err := index.Glob(fmt.Sprintf("%s*", dir))
@@ -488,20 +1511,33 @@ func listFiles(index siva.OrderedIndex, dir string) ([]os.FileInfo, error) {
 	}
 
 	contents := []os.FileInfo{}
-	for _, e := range entries {
-		contents = append(contents, newFileInfo(e))
+	for i, e := range entries {
+		if err := checkContext(ctx, i); err != nil {
+			return nil, err
+		}
+
+		fi, err := info(e)
+		if err != nil {
+			return nil, err
+		}
+
+		contents = append(contents, fi)
 	}
 
 	return contents, nil
 }
 
-func getDir(index siva.OrderedIndex, dir string) (os.FileInfo, error) {
+func getDir(ctx context.Context, index siva.OrderedIndex, dir string) (os.FileInfo, error) {
 	dir = addTrailingSlash(dir)
 	lenDir := len(dir)
 
 	entries := make([]*siva.IndexEntry, 0)
 
-	for _, e := range index {
+	for i, e := range index {
+		if err := checkContext(ctx, i); err != nil {
+			return nil, err
+		}
+
 		if len(e.Name) > lenDir {
 			if e.Name[:lenDir] == dir {
 				entries = append(entries, e)
@@ -523,13 +1559,17 @@ func getDir(index siva.OrderedIndex, dir string) (os.FileInfo, error) {
 	return newDirFileInfo(path.Clean(dir), oldDir), nil
 }
 
-func listDirs(index siva.OrderedIndex, dir string) ([]os.FileInfo, error) {
+func listDirs(ctx context.Context, index siva.OrderedIndex, dir string) ([]os.FileInfo, error) {
 	dir = addTrailingSlash(dir)
 
 	depth := strings.Count(dir, "/")
 	dirs := map[string]time.Time{}
 	dirOrder := make([]string, 0)
-	for _, e := range index {
+	for i, e := range index {
+		if err := checkContext(ctx, i); err != nil {
+			return nil, err
+		}
+
 		if !strings.HasPrefix(e.Name, dir) {
 			continue
 		}
@@ -587,11 +1627,33 @@ func removeLeadingSlash(path string) string {
 	return path
 }
 
+// rootFS is a filesystem wrapped by the helper filesystems.
+type rootFS interface {
+	billy.Basic
+	billy.Dir
+	sivaRoot
+}
+
 type temp struct {
 	billy.Filesystem
-	SivaSync
+	forwarded
 
 	defaultDir string
+	tmpFs      billy.Filesystem
+}
+
+// newTemp wraps root mounting tmpFs as /tmp, where temporary files are
+// stored.
+func newTemp(root rootFS, tmpFs billy.Filesystem) *temp {
+	tempdir := "/tmp"
+	m := mount.New(root, tempdir, tmpFs)
+
+	return &temp{
+		defaultDir: tempdir,
+		tmpFs:      tmpFs,
+		forwarded:  forwarded{root},
+		Filesystem: chroot.New(m, "/"),
+	}
 }
 
 // Capability implements billy.Capable interface.
@@ -608,7 +1670,14 @@ func (h *temp) TempFile(dir, prefix string) (billy.File, error) {
 
 type readOnly struct {
 	billy.Filesystem
-	SivaSync
+	forwarded
+}
+
+func newReadOnly(root rootFS) *readOnly {
+	return &readOnly{
+		Filesystem: chroot.New(root, "/"),
+		forwarded:  forwarded{root},
+	}
 }
 
 // Capability implements billy.Capable interface.
@@ -519,3 +519,42 @@ func (s *ReadOnlyFilesystemSuite) TestOffset(c *C) {
 	_, err = f.FSOffset(c, false, 10)
 	c.Assert(err, Equals, ErrOffsetReadWrite)
 }
+
+// memSuite is embedded by the suites keeping their siva files in a memory
+// filesystem, created for every test.
+type memSuite struct {
+	mem billy.Filesystem
+}
+
+func (s *memSuite) SetUpTest(c *C) {
+	s.mem = memfs.New()
+}
+
+// open returns a filesystem for the siva file test.siva.
+func (s *memSuite) open(c *C, o SivaFSOptions) SivaFS {
+	return s.openPath(c, "test.siva", o)
+}
+
+// openPath returns a filesystem for the siva file with the given path.
+func (s *memSuite) openPath(c *C, path string, o SivaFSOptions) SivaFS {
+	fs, err := NewFilesystemWithOptions(s.mem, path, memfs.New(), o)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func writeFile(c *C, fs billy.Basic, name string, data []byte) {
+	f, err := fs.Create(name)
+	c.Assert(err, IsNil)
+	_, err = f.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func names(files []os.FileInfo) []string {
+	var result []string
+	for _, fi := range files {
+		result = append(result, fi.Name())
+	}
+
+	return result
+}
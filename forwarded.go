@@ -0,0 +1,175 @@
+package sivafs
+
+import (
+	"crypto"
+	"io"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// forwarded implements the optional siva interfaces in the helper
+// filesystems, forwarding them to the root filesystem. Methods the root does
+// not implement fail with billy.ErrNotSupported.
+type forwarded struct {
+	sivaRoot
+}
+
+// Bytes implements SivaBytes interface.
+func (f forwarded) Bytes(path string) ([]byte, io.Closer, error) {
+	b, ok := f.sivaRoot.(SivaBytes)
+	if !ok {
+		return nil, nil, billy.ErrNotSupported
+	}
+
+	return b.Bytes(path)
+}
+
+// CreateHeader implements SivaCreateHeader interface.
+func (f forwarded) CreateHeader(h *siva.Header) (billy.File, error) {
+	c, ok := f.sivaRoot.(SivaCreateHeader)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return c.CreateHeader(h)
+}
+
+// Hash implements SivaHash interface.
+func (f forwarded) Hash(path string) (crypto.Hash, []byte, error) {
+	h, ok := f.sivaRoot.(SivaHash)
+	if !ok {
+		return 0, nil, billy.ErrNotSupported
+	}
+
+	return h.Hash(path)
+}
+
+// FindByHash implements SivaHash interface.
+func (f forwarded) FindByHash(sum []byte) ([]string, error) {
+	h, ok := f.sivaRoot.(SivaHash)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return h.FindByHash(sum)
+}
+
+// CreateWithMeta implements SivaMeta interface.
+func (f forwarded) CreateWithMeta(path string, meta map[string]string) (billy.File, error) {
+	m, ok := f.sivaRoot.(SivaMeta)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return m.CreateWithMeta(path, meta)
+}
+
+// GetMeta implements SivaMeta interface.
+func (f forwarded) GetMeta(path, key string) (string, error) {
+	m, ok := f.sivaRoot.(SivaMeta)
+	if !ok {
+		return "", billy.ErrNotSupported
+	}
+
+	return m.GetMeta(path, key)
+}
+
+// SetMeta implements SivaMeta interface.
+func (f forwarded) SetMeta(path, key, value string) error {
+	m, ok := f.sivaRoot.(SivaMeta)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return m.SetMeta(path, key, value)
+}
+
+// RemoveMeta implements SivaMeta interface.
+func (f forwarded) RemoveMeta(path, key string) error {
+	m, ok := f.sivaRoot.(SivaMeta)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return m.RemoveMeta(path, key)
+}
+
+// ListMeta implements SivaMeta interface.
+func (f forwarded) ListMeta(path string) (map[string]string, error) {
+	m, ok := f.sivaRoot.(SivaMeta)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return m.ListMeta(path)
+}
+
+// Snapshot implements SivaSnapshot interface.
+func (f forwarded) Snapshot(name, message string) error {
+	s, ok := f.sivaRoot.(SivaSnapshot)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return s.Snapshot(name, message)
+}
+
+// Snapshots implements SivaSnapshot interface.
+func (f forwarded) Snapshots() ([]Snapshot, error) {
+	s, ok := f.sivaRoot.(SivaSnapshot)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return s.Snapshots()
+}
+
+// History implements SivaHistory interface.
+func (f forwarded) History() ([]Revision, error) {
+	h, ok := f.sivaRoot.(SivaHistory)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return h.History()
+}
+
+// Stats implements SivaStats interface.
+func (f forwarded) Stats() (*Stats, error) {
+	s, ok := f.sivaRoot.(SivaStats)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return s.Stats()
+}
+
+// Compact implements SivaCompact interface.
+func (f forwarded) Compact() error {
+	c, ok := f.sivaRoot.(SivaCompact)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return c.Compact()
+}
+
+func (f forwarded) compact(keepTombstones bool) error {
+	c, ok := f.sivaRoot.(tombstoneCompacter)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return c.compact(keepTombstones)
+}
+
+// Rollback implements SivaRollback interface.
+func (f forwarded) Rollback() error {
+	r, ok := f.sivaRoot.(SivaRollback)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return r.Rollback()
+}
@@ -0,0 +1,580 @@
+package gitstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/objfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+const (
+	objectsPath = "objects"
+	packPath    = "objects/pack"
+	packPrefix  = "pack-"
+	packExt     = ".pack"
+	idxExt      = ".idx"
+)
+
+var _ storer.PackfileWriter = &Storage{}
+
+// pack is a packfile of the storage along with its decoded idx file.
+type pack struct {
+	id  string
+	idx *idxfile.MemoryIndex
+
+	// m guards pf, opened on first use and shared by every lookup, as
+	// packfile.Packfile is not safe for concurrent use.
+	m  sync.Mutex
+	pf *packfile.Packfile
+}
+
+// close closes the packfile, if opened.
+func (p *pack) close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.pf == nil {
+		return nil
+	}
+
+	err := p.pf.Close()
+	p.pf = nil
+	return err
+}
+
+func packName(id, ext string) string {
+	return packPath + "/" + packPrefix + id + ext
+}
+
+func objectName(h plumbing.Hash) string {
+	name := h.String()
+	return objectsPath + "/" + name[:2] + "/" + name[2:]
+}
+
+// NewEncodedObject implements storer.EncodedObjectStorer interface.
+func (s *Storage) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+// SetEncodedObject implements storer.EncodedObjectStorer interface. Objects
+// are stored as loose objects, objects already stored are not written again.
+func (s *Storage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	switch obj.Type() {
+	case plumbing.CommitObject, plumbing.TreeObject,
+		plumbing.BlobObject, plumbing.TagObject:
+	default:
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	h := obj.Hash()
+	if err := s.HasEncodedObject(h); err == nil {
+		return h, nil
+	} else if err != plumbing.ErrObjectNotFound {
+		return plumbing.ZeroHash, err
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer r.Close()
+
+	f, err := s.fs.Create(s.path(objectName(h)))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	w := objfile.NewWriter(f)
+	err = w.WriteHeader(obj.Type(), obj.Size())
+	if err == nil {
+		_, err = io.Copy(w, r)
+	}
+
+	if err == nil {
+		err = w.Close()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return h, err
+}
+
+// EncodedObject implements storer.EncodedObjectStorer interface.
+func (s *Storage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.looseObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		obj, err = s.packedObject(h)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t != plumbing.AnyObject && obj.Type() != t {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return obj, nil
+}
+
+// HasEncodedObject implements storer.EncodedObjectStorer interface.
+func (s *Storage) HasEncodedObject(h plumbing.Hash) error {
+	_, err := s.fs.Stat(s.path(objectName(h)))
+	if err == nil {
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	p, err := s.findPack(h)
+	if err != nil {
+		return err
+	}
+
+	if p == nil {
+		return plumbing.ErrObjectNotFound
+	}
+
+	return nil
+}
+
+// EncodedObjectSize implements storer.EncodedObjectStorer interface.
+func (s *Storage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	f, err := s.fs.Open(s.path(objectName(h)))
+	if err == nil {
+		defer f.Close()
+
+		r, err := objfile.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+
+		_, size, err := r.Header()
+		return size, err
+	}
+
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	var size int64
+	err = s.withPack(h, func(p *packfile.Packfile, offset int64) error {
+		size, err = p.GetSizeByOffset(offset)
+		return err
+	})
+
+	return size, err
+}
+
+// IterEncodedObjects implements storer.EncodedObjectStorer interface. Objects
+// are read as the iterator advances.
+func (s *Storage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	hashes, err := s.objectHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectIter{s: s, t: t, hashes: hashes}, nil
+}
+
+// objectHashes returns the hashes of the loose and packed objects.
+func (s *Storage) objectHashes() ([]plumbing.Hash, error) {
+	seen := make(map[plumbing.Hash]bool)
+	var hashes []plumbing.Hash
+	add := func(h plumbing.Hash) {
+		if !seen[h] {
+			seen[h] = true
+			hashes = append(hashes, h)
+		}
+	}
+
+	dirs, err := s.fs.ReadDir(s.path(objectsPath))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+
+		files, err := s.fs.ReadDir(s.path(objectsPath, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			name := dir.Name() + f.Name()
+			if _, err := hex.DecodeString(name); err != nil || len(name) != 40 {
+				continue
+			}
+
+			add(plumbing.NewHash(name))
+		}
+	}
+
+	packs, err := s.getPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packs {
+		entries, err := p.idx.Entries()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			e, err := entries.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				entries.Close()
+				return nil, err
+			}
+
+			add(e.Hash)
+		}
+
+		entries.Close()
+	}
+
+	return hashes, nil
+}
+
+func (s *Storage) looseObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	f, err := s.fs.Open(s.path(objectName(h)))
+	if os.IsNotExist(err) {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := objfile.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	t, size, err := r.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := s.NewEncodedObject()
+	obj.SetType(t)
+	obj.SetSize(size)
+	w, err := obj.Writer()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+
+	return obj, w.Close()
+}
+
+func (s *Storage) packedObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	var obj plumbing.EncodedObject
+	err := s.withPack(h, func(p *packfile.Packfile, offset int64) error {
+		var err error
+		obj, err = p.GetByOffset(offset)
+		return err
+	})
+
+	return obj, err
+}
+
+// withPack calls fn with the packfile holding the object and its offset. It
+// returns plumbing.ErrObjectNotFound if no packfile holds it.
+func (s *Storage) withPack(h plumbing.Hash, fn func(*packfile.Packfile, int64) error) error {
+	p, err := s.findPack(h)
+	if err != nil {
+		return err
+	}
+
+	if p == nil {
+		return plumbing.ErrObjectNotFound
+	}
+
+	offset, err := p.idx.FindOffset(h)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.pf == nil {
+		f, err := s.fs.Open(s.path(packName(p.id, packExt)))
+		if err != nil {
+			return err
+		}
+
+		p.pf = packfile.NewPackfile(p.idx, nil, f)
+	}
+
+	return fn(p.pf, offset)
+}
+
+// Close closes the packfiles opened to read objects. Until then they keep
+// the siva file they were opened from open, even after the filesystem is
+// synced. The storage can still be used after Close.
+func (s *Storage) Close() error {
+	packs, err := s.getPacks()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range packs {
+		if cerr := p.close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// findPack returns the pack holding the object, nil if none does.
+func (s *Storage) findPack(h plumbing.Hash) (*pack, error) {
+	packs, err := s.getPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packs {
+		ok, err := p.idx.Contains(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return p, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// getPacks returns the packs of the storage, sorted by id. Their idx files
+// are read once.
+func (s *Storage) getPacks() ([]*pack, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.packs == nil {
+		if err := s.loadPacks(); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]string, 0, len(s.packs))
+	for id := range s.packs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	packs := make([]*pack, len(ids))
+	for i, id := range ids {
+		packs[i] = s.packs[id]
+	}
+
+	return packs, nil
+}
+
+func (s *Storage) loadPacks() error {
+	files, err := s.fs.ReadDir(s.path(packPath))
+	if err != nil {
+		return err
+	}
+
+	packs := make(map[string]*pack)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, packPrefix) ||
+			!strings.HasSuffix(name, idxExt) {
+			continue
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(name, packPrefix), idxExt)
+		data, err := s.readFile(packName(id, idxExt))
+		if err != nil {
+			return err
+		}
+
+		idx := idxfile.NewMemoryIndex()
+		if err := idxfile.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+			return err
+		}
+
+		packs[id] = &pack{id: id, idx: idx}
+	}
+
+	s.packs = packs
+	return nil
+}
+
+func (s *Storage) addPack(p *pack) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.packs != nil {
+		s.packs[p.id] = p
+	}
+}
+
+// PackfileWriter implements storer.PackfileWriter interface. The packfile is
+// written directly as a siva entry and indexed once closed. As siva needs
+// the name of an entry before its contents, packfiles are named after a
+// random id instead of their checksum. Names are only used to pair packfiles
+// with their idx files, so this is safe as long as ids do not collide, which
+// 160 random bits make negligible. Git uses the checksum to avoid storing a
+// packfile twice instead, so a packfile with the checksum of one already
+// stored is removed once closed.
+func (s *Storage) PackfileWriter() (io.WriteCloser, error) {
+	return &packWriter{s: s}, nil
+}
+
+type packWriter struct {
+	s  *Storage
+	id string
+	f  billy.File
+}
+
+func (w *packWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		b := make([]byte, 20)
+		if _, err := rand.Read(b); err != nil {
+			return 0, err
+		}
+
+		id := hex.EncodeToString(b)
+		f, err := w.s.fs.Create(w.s.path(packName(id, packExt)))
+		if err != nil {
+			return 0, err
+		}
+
+		w.id = id
+		w.f = f
+	}
+
+	return w.f.Write(p)
+}
+
+// Close closes the packfile entry and writes its idx file. Nothing is written
+// if the packfile is empty.
+func (w *packWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+
+	idx, err := w.index()
+	if err != nil {
+		return err
+	}
+
+	packs, err := w.s.getPacks()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range packs {
+		if p.idx.PackfileChecksum == idx.PackfileChecksum {
+			return w.s.removeFile(packName(w.id, packExt))
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := idxfile.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+
+	if err := w.s.writeFile(packName(w.id, idxExt), buf.Bytes()); err != nil {
+		return err
+	}
+
+	w.s.addPack(&pack{id: w.id, idx: idx})
+	return nil
+}
+
+// index parses the written packfile, returning its index.
+func (w *packWriter) index() (*idxfile.MemoryIndex, error) {
+	f, err := w.s.fs.Open(w.s.path(packName(w.id, packExt)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	writer := new(idxfile.Writer)
+	parser, err := packfile.NewParser(packfile.NewScanner(f), writer)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := parser.Parse(); err != nil {
+		return nil, err
+	}
+
+	return writer.Index()
+}
+
+type objectIter struct {
+	s      *Storage
+	t      plumbing.ObjectType
+	hashes []plumbing.Hash
+}
+
+// Next implements storer.EncodedObjectIter interface. Objects of other types
+// are skipped.
+func (i *objectIter) Next() (plumbing.EncodedObject, error) {
+	for len(i.hashes) > 0 {
+		h := i.hashes[0]
+		i.hashes = i.hashes[1:]
+
+		obj, err := i.s.EncodedObject(i.t, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		return obj, err
+	}
+
+	return nil, io.EOF
+}
+
+// ForEach implements storer.EncodedObjectIter interface.
+func (i *objectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	return storer.ForEachIterator(i, cb)
+}
+
+// Close implements storer.EncodedObjectIter interface.
+func (i *objectIter) Close() {
+	i.hashes = nil
+}
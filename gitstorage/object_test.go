@@ -0,0 +1,140 @@
+package gitstorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// encodePack returns a packfile holding similar blobs, so some of them are
+// stored as deltas.
+func encodePack(c *C, count int) ([]byte, []plumbing.EncodedObject) {
+	mem := memory.NewStorage()
+	var objects []plumbing.EncodedObject
+	var hashes []plumbing.Hash
+	for i := 0; i < count; i++ {
+		obj := mem.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, err := obj.Writer()
+		c.Assert(err, IsNil)
+		_, err = fmt.Fprintf(w, "%s%d\n", strings.Repeat("content ", 100), i)
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+
+		h, err := mem.SetEncodedObject(obj)
+		c.Assert(err, IsNil)
+		objects = append(objects, obj)
+		hashes = append(hashes, h)
+	}
+
+	var buf bytes.Buffer
+	_, err := packfile.NewEncoder(&buf, mem, false).Encode(hashes, 10)
+	c.Assert(err, IsNil)
+
+	return buf.Bytes(), objects
+}
+
+func (s *StorageSuite) writePack(c *C, data []byte) {
+	w, err := s.storage.PackfileWriter()
+	c.Assert(err, IsNil)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+}
+
+func (s *StorageSuite) TestPackfileWriter(c *C) {
+	data, objects := encodePack(c, 10)
+	s.writePack(c, data)
+
+	for i := 0; i < 2; i++ {
+		for _, expected := range objects {
+			obj, err := s.storage.EncodedObject(plumbing.BlobObject, expected.Hash())
+			c.Assert(err, IsNil)
+			objectEquals(c, obj, expected)
+
+			size, err := s.storage.EncodedObjectSize(expected.Hash())
+			c.Assert(err, IsNil)
+			c.Assert(size, Equals, expected.Size())
+		}
+
+		iter, err := s.storage.IterEncodedObjects(plumbing.AnyObject)
+		c.Assert(err, IsNil)
+		count := 0
+		c.Assert(iter.ForEach(func(plumbing.EncodedObject) error {
+			count++
+			return nil
+		}), IsNil)
+		c.Assert(count, Equals, len(objects))
+
+		s.reopen(c)
+	}
+
+	files, err := s.fs.ReadDir(packPath)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+}
+
+func (s *StorageSuite) TestPackfileWriterTwice(c *C) {
+	data, objects := encodePack(c, 2)
+	s.writePack(c, data)
+	s.writePack(c, data)
+
+	files, err := s.fs.ReadDir(packPath)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+
+	obj, err := s.storage.EncodedObject(plumbing.BlobObject, objects[1].Hash())
+	c.Assert(err, IsNil)
+	objectEquals(c, obj, objects[1])
+}
+
+func (s *StorageSuite) TestPackfileOpenedOnce(c *C) {
+	data, objects := encodePack(c, 2)
+	s.writePack(c, data)
+
+	packs, err := s.storage.getPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+
+	_, err = s.storage.EncodedObject(plumbing.BlobObject, objects[0].Hash())
+	c.Assert(err, IsNil)
+	pf := packs[0].pf
+	c.Assert(pf, NotNil)
+
+	_, err = s.storage.EncodedObjectSize(objects[1].Hash())
+	c.Assert(err, IsNil)
+	c.Assert(packs[0].pf, Equals, pf)
+
+	c.Assert(s.storage.Close(), IsNil)
+	c.Assert(packs[0].pf, IsNil)
+
+	obj, err := s.storage.EncodedObject(plumbing.BlobObject, objects[1].Hash())
+	c.Assert(err, IsNil)
+	objectEquals(c, obj, objects[1])
+}
+
+func (s *StorageSuite) TestPackfileWriterEmpty(c *C) {
+	w, err := s.storage.PackfileWriter()
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	_, err = s.fs.Stat(packPath)
+	c.Assert(err, NotNil)
+}
+
+func (s *StorageSuite) TestSetEncodedObjectPacked(c *C) {
+	data, objects := encodePack(c, 2)
+	s.writePack(c, data)
+
+	_, err := s.storage.SetEncodedObject(objects[0])
+	c.Assert(err, IsNil)
+
+	_, err = s.fs.Stat(objectName(objects[0].Hash()))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,303 @@
+package gitstorage
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+const (
+	refsPath       = "refs"
+	packedRefsPath = "packed-refs"
+)
+
+// SetReference implements storer.ReferenceStorer interface. References are
+// stored as loose references.
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	return s.setReference(ref)
+}
+
+func (s *Storage) setReference(ref *plumbing.Reference) error {
+	return s.writeFile(ref.Name().String(), []byte(ref.Strings()[1]+"\n"))
+}
+
+// CheckAndSetReference implements storer.ReferenceStorer interface.
+func (s *Storage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	if old != nil {
+		current, err := s.reference(ref.Name())
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		if current != nil && current.Hash() != old.Hash() {
+			return storage.ErrReferenceHasChanged
+		}
+	}
+
+	return s.setReference(ref)
+}
+
+// Reference implements storer.ReferenceStorer interface.
+func (s *Storage) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	return s.reference(name)
+}
+
+func (s *Storage) reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := s.looseReference(name.String())
+	if err != nil || ref != nil {
+		return ref, err
+	}
+
+	packed, err := s.packedReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range packed {
+		if ref.Name() == name {
+			return ref, nil
+		}
+	}
+
+	return nil, plumbing.ErrReferenceNotFound
+}
+
+// looseReference returns the loose reference with the given name, nil if it
+// does not exist.
+func (s *Storage) looseReference(name string) (*plumbing.Reference, error) {
+	data, err := s.readFile(name)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	target := strings.TrimSpace(string(data))
+	return plumbing.NewReferenceFromStrings(name, target), nil
+}
+
+// looseReferences returns the loose references under the refs directory.
+func (s *Storage) looseReferences() ([]*plumbing.Reference, error) {
+	var refs []*plumbing.Reference
+	err := s.walk(refsPath, func(name string) error {
+		ref, err := s.looseReference(name)
+		if ref != nil {
+			refs = append(refs, ref)
+		}
+
+		return err
+	})
+
+	return refs, err
+}
+
+// walk calls fn with the name of each file under the directory.
+func (s *Storage) walk(dir string, fn func(name string) error) error {
+	files, err := s.fs.ReadDir(s.path(dir))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		name := dir + "/" + f.Name()
+		if f.IsDir() {
+			err = s.walk(name, fn)
+		} else {
+			err = fn(name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) packedReferences() ([]*plumbing.Reference, error) {
+	data, err := s.readFile(packedRefsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = readLines(bytes.NewReader(data), func(line string) error {
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			return nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs = append(refs, plumbing.NewReferenceFromStrings(fields[1], fields[0]))
+		}
+
+		return nil
+	})
+
+	return refs, err
+}
+
+func (s *Storage) setPackedReferences(refs []*plumbing.Reference) error {
+	if len(refs) == 0 {
+		return s.removeFile(packedRefsPath)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name() < refs[j].Name()
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, ref := range refs {
+		buf.WriteString(ref.Hash().String() + " " + ref.Name().String() + "\n")
+	}
+
+	return s.writeFile(packedRefsPath, buf.Bytes())
+}
+
+// IterReferences implements storer.ReferenceStorer interface.
+func (s *Storage) IterReferences() (storer.ReferenceIter, error) {
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	refs, err := s.looseReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := s.looseReference(plumbing.HEAD.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if head != nil {
+		refs = append(refs, head)
+	}
+
+	seen := make(map[plumbing.ReferenceName]bool, len(refs))
+	for _, ref := range refs {
+		seen[ref.Name()] = true
+	}
+
+	packed, err := s.packedReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range packed {
+		if !seen[ref.Name()] {
+			refs = append(refs, ref)
+		}
+	}
+
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+// RemoveReference implements storer.ReferenceStorer interface. Removing a
+// reference that does not exist is not an error.
+func (s *Storage) RemoveReference(name plumbing.ReferenceName) error {
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	if err := s.removeFile(name.String()); err != nil {
+		return err
+	}
+
+	packed, err := s.packedReferences()
+	if err != nil {
+		return err
+	}
+
+	kept := packed[:0]
+	for _, ref := range packed {
+		if ref.Name() != name {
+			kept = append(kept, ref)
+		}
+	}
+
+	if len(kept) == len(packed) {
+		return nil
+	}
+
+	return s.setPackedReferences(kept)
+}
+
+// CountLooseRefs implements storer.ReferenceStorer interface.
+func (s *Storage) CountLooseRefs() (int, error) {
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	refs, err := s.looseReferences()
+	return len(refs), err
+}
+
+// PackRefs implements storer.ReferenceStorer interface. Loose references
+// under the refs directory are moved to the packed-refs file, symbolic
+// references are kept loose.
+func (s *Storage) PackRefs() error {
+	s.refs.Lock()
+	defer s.refs.Unlock()
+
+	loose, err := s.looseReferences()
+	if err != nil {
+		return err
+	}
+
+	packed, err := s.packedReferences()
+	if err != nil {
+		return err
+	}
+
+	refs := make(map[plumbing.ReferenceName]*plumbing.Reference)
+	for _, ref := range packed {
+		refs[ref.Name()] = ref
+	}
+
+	var moved []*plumbing.Reference
+	for _, ref := range loose {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name()] = ref
+			moved = append(moved, ref)
+		}
+	}
+
+	if len(moved) == 0 {
+		return nil
+	}
+
+	all := make([]*plumbing.Reference, 0, len(refs))
+	for _, ref := range refs {
+		all = append(all, ref)
+	}
+
+	if err := s.setPackedReferences(all); err != nil {
+		return err
+	}
+
+	for _, ref := range moved {
+		if err := s.removeFile(ref.Name().String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package gitstorage
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+func (s *StorageSuite) TestSetReferenceAndGetReference(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"bar", "482e0eada5de4039e6f216b45b3c9b683b83bfa")), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewSymbolicReference(
+		plumbing.HEAD, "refs/heads/master")), IsNil)
+	s.reopen(c)
+
+	ref, err := s.storage.Reference("foo")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+
+	ref, err = s.storage.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Type(), Equals, plumbing.SymbolicReference)
+	c.Assert(ref.Target(), Equals, plumbing.ReferenceName("refs/heads/master"))
+}
+
+func (s *StorageSuite) TestCheckAndSetReference(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")), IsNil)
+
+	err := s.storage.CheckAndSetReference(
+		plumbing.NewReferenceFromStrings("foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		plumbing.NewReferenceFromStrings("foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa"),
+	)
+	c.Assert(err, IsNil)
+
+	ref, err := s.storage.Reference("foo")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+}
+
+func (s *StorageSuite) TestCheckAndSetReferenceNil(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")), IsNil)
+
+	err := s.storage.CheckAndSetReference(plumbing.NewReferenceFromStrings(
+		"foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"), nil)
+	c.Assert(err, IsNil)
+
+	ref, err := s.storage.Reference("foo")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+}
+
+func (s *StorageSuite) TestCheckAndSetReferenceError(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"foo", "c3f4688a08fd86f1bf8e055724c84b7a40a09733")), IsNil)
+
+	err := s.storage.CheckAndSetReference(
+		plumbing.NewReferenceFromStrings("foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		plumbing.NewReferenceFromStrings("foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa"),
+	)
+	c.Assert(err, Equals, storage.ErrReferenceHasChanged)
+
+	ref, err := s.storage.Reference("foo")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+}
+
+func (s *StorageSuite) TestRemoveReference(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+
+	c.Assert(s.storage.RemoveReference("nonexistent"), IsNil)
+	c.Assert(s.storage.RemoveReference("foo"), IsNil)
+
+	_, err := s.storage.Reference("foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *StorageSuite) TestIterReferences(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"refs/foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+
+	iter, err := s.storage.IterReferences()
+	c.Assert(err, IsNil)
+
+	ref, err := iter.Next()
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+
+	ref, err = iter.Next()
+	c.Assert(ref, IsNil)
+	c.Assert(err, NotNil)
+}
+
+func (s *StorageSuite) TestPackRefs(c *C) {
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"refs/heads/master", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewReferenceFromStrings(
+		"refs/tags/v1", "c3f4688a08fd86f1bf8e055724c84b7a40a09733")), IsNil)
+	c.Assert(s.storage.SetReference(plumbing.NewSymbolicReference(
+		plumbing.HEAD, "refs/heads/master")), IsNil)
+
+	count, err := s.storage.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 2)
+
+	c.Assert(s.storage.PackRefs(), IsNil)
+	s.reopen(c)
+
+	count, err = s.storage.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+
+	ref, err := s.storage.Reference("refs/tags/v1")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+
+	iter, err := s.storage.IterReferences()
+	c.Assert(err, IsNil)
+	refs := make(map[plumbing.ReferenceName]bool)
+	c.Assert(iter.ForEach(func(ref *plumbing.Reference) error {
+		refs[ref.Name()] = true
+		return nil
+	}), IsNil)
+	c.Assert(refs, HasLen, 3)
+
+	c.Assert(s.storage.RemoveReference("refs/tags/v1"), IsNil)
+	_, err = s.storage.Reference("refs/tags/v1")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
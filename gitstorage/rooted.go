@@ -0,0 +1,321 @@
+package gitstorage
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	ErrInvalidRefPattern   = errors.New("reference pattern must hold {id} as a directory")
+	ErrInvalidRepositoryID = errors.New("invalid repository id")
+	ErrRepositoryExists    = errors.New("repository already exists")
+)
+
+// DefaultRefPattern is the pattern of the references of the repositories of
+// a rooted repository, as in the layout produced by borges.
+const DefaultRefPattern = "refs/remotes/{id}/"
+
+const idPlaceholder = "{id}"
+
+// Rooted is a rooted repository: a siva file holding the objects of many
+// repositories, usually forks of the same project, with the references of
+// each of them namespaced by its id.
+type Rooted struct {
+	*Storage
+	prefix string
+	suffix string
+}
+
+// RootedOptions holds configuration options for rooted repositories.
+type RootedOptions struct {
+	// RefPattern is the name of the directory holding the references of a
+	// repository, with {id} in place of its id. DefaultRefPattern is used if
+	// empty. References of a repository are named after the reference
+	// pattern followed by the reference name without "refs/", so with the
+	// default pattern refs/heads/master of the repository with id "foo" is
+	// stored as refs/remotes/foo/heads/master.
+	RefPattern string
+}
+
+// NewRooted creates a rooted repository backed by the given siva filesystem.
+func NewRooted(fs sivafs.SivaBasicFS) (*Rooted, error) {
+	return NewRootedWithOptions(fs, RootedOptions{})
+}
+
+// NewRootedWithOptions creates a rooted repository backed by the given siva
+// filesystem with the given options.
+func NewRootedWithOptions(fs sivafs.SivaBasicFS, o RootedOptions) (*Rooted, error) {
+	pattern := o.RefPattern
+	if pattern == "" {
+		pattern = DefaultRefPattern
+	}
+
+	i := strings.Index(pattern, idPlaceholder)
+	if i < 0 || strings.Count(pattern, idPlaceholder) != 1 {
+		return nil, ErrInvalidRefPattern
+	}
+
+	prefix := pattern[:i]
+	suffix := pattern[i+len(idPlaceholder):]
+	if !strings.HasPrefix(prefix, "refs/") || !strings.HasSuffix(prefix, "/") ||
+		!strings.HasPrefix(suffix, "/") || !strings.HasSuffix(suffix, "/") {
+		return nil, ErrInvalidRefPattern
+	}
+
+	return &Rooted{
+		Storage: New(fs),
+		prefix:  prefix,
+		suffix:  suffix,
+	}, nil
+}
+
+// repositoryID returns the id of the repository the reference belongs to and
+// the rest of its name, false if it does not match the reference pattern.
+func (r *Rooted) repositoryID(name plumbing.ReferenceName) (string, string, bool) {
+	s := name.String()
+	if !strings.HasPrefix(s, r.prefix) {
+		return "", "", false
+	}
+
+	s = s[len(r.prefix):]
+	i := strings.Index(s, "/")
+	if i <= 0 || !strings.HasPrefix(s[i:], r.suffix) {
+		return "", "", false
+	}
+
+	rest := s[i+len(r.suffix):]
+	if rest == "" {
+		return "", "", false
+	}
+
+	return s[:i], rest, true
+}
+
+// Repositories returns the ids of the repositories with references in the
+// rooted repository, sorted.
+func (r *Rooted) Repositories() ([]string, error) {
+	iter, err := r.Storage.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if id, _, ok := r.repositoryID(ref.Name()); ok {
+			seen[id] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Repository returns the storage of the repository with the given id. It
+// shares objects, config and modules with the rooted repository and only
+// sees the references of the repository, with their namespace removed.
+func (r *Rooted) Repository(id string) (*Repository, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, ErrInvalidRepositoryID
+	}
+
+	return &Repository{
+		Storage: r.Storage,
+		rooted:  r,
+		id:      id,
+	}, nil
+}
+
+// AddRepository adds the references of a new repository with the given id,
+// named as seen by the repository. Pending changes are synced first, so the
+// references are written together in an index block of their own.
+func (r *Rooted) AddRepository(id string, refs []*plumbing.Reference) error {
+	repo, err := r.Repository(id)
+	if err != nil {
+		return err
+	}
+
+	ids, err := r.Repositories()
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range ids {
+		if existing == id {
+			return ErrRepositoryExists
+		}
+	}
+
+	if err := r.fs.Sync(); err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if err := repo.SetReference(ref); err != nil {
+			return err
+		}
+	}
+
+	return r.fs.Sync()
+}
+
+// Repository is the storage of a repository of a rooted repository.
+type Repository struct {
+	*Storage
+	rooted *Rooted
+	id     string
+}
+
+// ID returns the id of the repository.
+func (r *Repository) ID() string {
+	return r.id
+}
+
+// storedName returns the name of the reference in the rooted repository.
+func (r *Repository) storedName(name plumbing.ReferenceName) plumbing.ReferenceName {
+	rest := strings.TrimPrefix(name.String(), "refs/")
+	return plumbing.ReferenceName(r.rooted.prefix + r.id + r.rooted.suffix + rest)
+}
+
+// repositoryName returns the name of the stored reference as seen by the
+// repository, false if it belongs to another repository.
+func (r *Repository) repositoryName(name plumbing.ReferenceName) (plumbing.ReferenceName, bool) {
+	id, rest, ok := r.rooted.repositoryID(name)
+	if !ok || id != r.id {
+		return "", false
+	}
+
+	if rest == plumbing.HEAD.String() {
+		return plumbing.HEAD, true
+	}
+
+	return plumbing.ReferenceName("refs/" + rest), true
+}
+
+func (r *Repository) toStored(ref *plumbing.Reference) *plumbing.Reference {
+	if ref.Type() == plumbing.SymbolicReference {
+		return plumbing.NewSymbolicReference(
+			r.storedName(ref.Name()), r.storedName(ref.Target()))
+	}
+
+	return plumbing.NewHashReference(r.storedName(ref.Name()), ref.Hash())
+}
+
+// fromStored returns the stored reference as seen by the repository, nil if
+// it belongs to another repository.
+func (r *Repository) fromStored(ref *plumbing.Reference) *plumbing.Reference {
+	name, ok := r.repositoryName(ref.Name())
+	if !ok {
+		return nil
+	}
+
+	if ref.Type() == plumbing.SymbolicReference {
+		target, ok := r.repositoryName(ref.Target())
+		if !ok {
+			target = ref.Target()
+		}
+
+		return plumbing.NewSymbolicReference(name, target)
+	}
+
+	return plumbing.NewHashReference(name, ref.Hash())
+}
+
+// SetReference implements storer.ReferenceStorer interface.
+func (r *Repository) SetReference(ref *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	return r.Storage.SetReference(r.toStored(ref))
+}
+
+// CheckAndSetReference implements storer.ReferenceStorer interface.
+func (r *Repository) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	if old != nil {
+		old = r.toStored(old)
+	}
+
+	return r.Storage.CheckAndSetReference(r.toStored(ref), old)
+}
+
+// Reference implements storer.ReferenceStorer interface.
+func (r *Repository) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := r.Storage.Reference(r.storedName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.fromStored(ref), nil
+}
+
+// IterReferences implements storer.ReferenceStorer interface.
+func (r *Repository) IterReferences() (storer.ReferenceIter, error) {
+	iter, err := r.Storage.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref = r.fromStored(ref); ref != nil {
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+// RemoveReference implements storer.ReferenceStorer interface.
+func (r *Repository) RemoveReference(name plumbing.ReferenceName) error {
+	return r.Storage.RemoveReference(r.storedName(name))
+}
+
+// CountLooseRefs implements storer.ReferenceStorer interface.
+func (r *Repository) CountLooseRefs() (int, error) {
+	r.refs.Lock()
+	defer r.refs.Unlock()
+
+	refs, err := r.looseReferences()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, ref := range refs {
+		if _, ok := r.repositoryName(ref.Name()); ok {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// PackRefs implements storer.ReferenceStorer interface. References of all the
+// repositories are packed.
+func (r *Repository) PackRefs() error {
+	return r.Storage.PackRefs()
+}
@@ -0,0 +1,157 @@
+package gitstorage
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+type RootedSuite struct {
+	underlying billy.Filesystem
+	fs         sivafs.SivaFS
+}
+
+var _ = Suite(&RootedSuite{})
+
+func (s *RootedSuite) SetUpTest(c *C) {
+	s.underlying = osfs.New(c.MkDir())
+	s.fs = s.open(c)
+}
+
+func (s *RootedSuite) open(c *C) sivafs.SivaFS {
+	fs, err := sivafs.NewFilesystem(s.underlying, "repo.siva", memfs.New())
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *RootedSuite) reopen(c *C) {
+	c.Assert(s.fs.Sync(), IsNil)
+	s.fs = s.open(c)
+}
+
+func (s *RootedSuite) rooted(c *C, o RootedOptions) *Rooted {
+	r, err := NewRootedWithOptions(s.fs, o)
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *RootedSuite) blocks(c *C) int {
+	blocks, err := sivafs.VerifySignatures(s.underlying, "repo.siva", nil)
+	c.Assert(err, IsNil)
+	return len(blocks)
+}
+
+func repositoryRefs(hash string) []*plumbing.Reference {
+	return []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/master"),
+		plumbing.NewReferenceFromStrings("refs/heads/master", hash),
+		plumbing.NewReferenceFromStrings("refs/tags/v1", hash),
+	}
+}
+
+func (s *RootedSuite) TestRepositories(c *C) {
+	r := s.rooted(c, RootedOptions{})
+	c.Assert(r.AddRepository("foo", repositoryRefs(
+		"bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+
+	blocks := s.blocks(c)
+	c.Assert(r.AddRepository("bar", repositoryRefs(
+		"c3f4688a08fd86f1bf8e055724c84b7a40a09733")), IsNil)
+	c.Assert(s.blocks(c), Equals, blocks+1)
+
+	c.Assert(r.AddRepository("foo", nil), Equals, ErrRepositoryExists)
+
+	s.reopen(c)
+	r = s.rooted(c, RootedOptions{})
+	ids, err := r.Repositories()
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"bar", "foo"})
+
+	ref, err := r.Storage.Reference("refs/remotes/foo/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+
+	repo, err := r.Repository("bar")
+	c.Assert(err, IsNil)
+	c.Assert(repo.ID(), Equals, "bar")
+
+	head, err := repo.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.ReferenceName("refs/heads/master"))
+
+	gr, err := git.Open(repo, nil)
+	c.Assert(err, IsNil)
+	head, err = gr.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash().String(), Equals, "c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+
+	iter, err := repo.IterReferences()
+	c.Assert(err, IsNil)
+	refs := make(map[plumbing.ReferenceName]string)
+	c.Assert(iter.ForEach(func(ref *plumbing.Reference) error {
+		refs[ref.Name()] = ref.Strings()[1]
+		return nil
+	}), IsNil)
+	c.Assert(refs, DeepEquals, map[plumbing.ReferenceName]string{
+		"HEAD":              "ref: refs/heads/master",
+		"refs/heads/master": "c3f4688a08fd86f1bf8e055724c84b7a40a09733",
+		"refs/tags/v1":      "c3f4688a08fd86f1bf8e055724c84b7a40a09733",
+	})
+
+	count, err := repo.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 3)
+}
+
+func (s *RootedSuite) TestRepositoryReferences(c *C) {
+	r := s.rooted(c, RootedOptions{})
+	foo, err := r.Repository("foo")
+	c.Assert(err, IsNil)
+	bar, err := r.Repository("bar")
+	c.Assert(err, IsNil)
+
+	c.Assert(foo.SetReference(plumbing.NewReferenceFromStrings(
+		"refs/heads/master", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+
+	_, err = bar.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	c.Assert(foo.RemoveReference("refs/heads/master"), IsNil)
+	_, err = r.Storage.Reference("refs/remotes/foo/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RootedSuite) TestRefPattern(c *C) {
+	r := s.rooted(c, RootedOptions{RefPattern: "refs/namespaces/{id}/refs/"})
+	c.Assert(r.AddRepository("foo", repositoryRefs(
+		"bc9968d75e48de59f0870ffb71f5e160bbbdcf52")), IsNil)
+
+	ref, err := r.Storage.Reference("refs/namespaces/foo/refs/tags/v1")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+
+	ids, err := r.Repositories()
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"foo"})
+}
+
+func (s *RootedSuite) TestErrors(c *C) {
+	for _, pattern := range []string{
+		"refs/remotes/",
+		"refs/{id}{id}/",
+		"refs/remotes/{id}",
+		"remotes/{id}/",
+		"refs/remotes/x{id}/",
+	} {
+		_, err := NewRootedWithOptions(s.fs, RootedOptions{RefPattern: pattern})
+		c.Assert(err, Equals, ErrInvalidRefPattern, Commentf(pattern))
+	}
+
+	r := s.rooted(c, RootedOptions{})
+	_, err := r.Repository("foo/bar")
+	c.Assert(err, Equals, ErrInvalidRepositoryID)
+}
@@ -0,0 +1,208 @@
+// Package gitstorage implements a go-git storage.Storer that keeps a git
+// repository inside a siva file, writing objects, packfiles, references and
+// configuration directly as siva entries.
+package gitstorage
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+const (
+	configPath  = "config"
+	shallowPath = "shallow"
+	indexPath   = "index"
+	modulesPath = "modules"
+)
+
+// Storage is a go-git storage.Storer backed by a siva filesystem. Its layout
+// mirrors the one of a bare repository: loose objects, packfiles, references,
+// config, shallow and index are stored at the same paths they would have in a
+// .git directory.
+//
+// Changes are written to the siva filesystem, which must be synced to make
+// them persistent.
+type Storage struct {
+	fs     sivafs.SivaBasicFS
+	prefix string
+
+	m     sync.Mutex
+	packs map[string]*pack
+
+	refs sync.Mutex
+}
+
+var _ storage.Storer = &Storage{}
+
+// New creates a new Storage writing into the given siva filesystem.
+func New(fs sivafs.SivaBasicFS) *Storage {
+	return newStorage(fs, "")
+}
+
+func newStorage(fs sivafs.SivaBasicFS, prefix string) *Storage {
+	return &Storage{fs: fs, prefix: prefix}
+}
+
+func (s *Storage) path(elem ...string) string {
+	return s.prefix + path.Join(elem...)
+}
+
+// readFile returns the contents of the file, nil if it does not exist.
+func (s *Storage) readFile(name string) ([]byte, error) {
+	f, err := s.fs.Open(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func (s *Storage) writeFile(name string, data []byte) error {
+	f, err := s.fs.Create(s.path(name))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *Storage) removeFile(name string) error {
+	err := s.fs.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Config implements config.ConfigStorer interface.
+func (s *Storage) Config() (*config.Config, error) {
+	data, err := s.readFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.NewConfig()
+	if data == nil {
+		return cfg, nil
+	}
+
+	if err := cfg.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// SetConfig implements config.ConfigStorer interface.
+func (s *Storage) SetConfig(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	data, err := cfg.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return s.writeFile(configPath, data)
+}
+
+// Shallow implements storer.ShallowStorer interface.
+func (s *Storage) Shallow() ([]plumbing.Hash, error) {
+	data, err := s.readFile(shallowPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []plumbing.Hash
+	err = readLines(bytes.NewReader(data), func(line string) error {
+		if line = strings.TrimSpace(line); line != "" {
+			hashes = append(hashes, plumbing.NewHash(line))
+		}
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+// SetShallow implements storer.ShallowStorer interface.
+func (s *Storage) SetShallow(commits []plumbing.Hash) error {
+	var buf bytes.Buffer
+	for _, h := range commits {
+		buf.WriteString(h.String())
+		buf.WriteByte('\n')
+	}
+
+	return s.writeFile(shallowPath, buf.Bytes())
+}
+
+// Index implements storer.IndexStorer interface.
+func (s *Storage) Index() (*index.Index, error) {
+	data, err := s.readFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index.Index{Version: 2}
+	if data == nil {
+		return idx, nil
+	}
+
+	if err := index.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// SetIndex implements storer.IndexStorer interface.
+func (s *Storage) SetIndex(idx *index.Index) error {
+	var buf bytes.Buffer
+	if err := index.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+
+	return s.writeFile(indexPath, buf.Bytes())
+}
+
+// Module implements storage.ModuleStorer interface. Modules are stored in
+// the same siva file, under the modules directory.
+func (s *Storage) Module(name string) (storage.Storer, error) {
+	return newStorage(s.fs, s.path(modulesPath, name)+"/"), nil
+}
+
+// readLines calls fn with each line of the reader.
+func readLines(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := fn(scanner.Text()); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,308 @@
+package gitstorage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/test"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type StorageSuite struct {
+	underlying billy.Filesystem
+	fs         sivafs.SivaFS
+	storage    *Storage
+	objects    map[plumbing.ObjectType]plumbing.EncodedObject
+}
+
+var _ = Suite(&StorageSuite{})
+
+func (s *StorageSuite) SetUpTest(c *C) {
+	s.underlying = osfs.New(c.MkDir())
+	s.fs = s.open(c)
+	s.storage = New(s.fs)
+
+	s.objects = make(map[plumbing.ObjectType]plumbing.EncodedObject)
+	for _, t := range []plumbing.ObjectType{
+		plumbing.CommitObject,
+		plumbing.TreeObject,
+		plumbing.BlobObject,
+		plumbing.TagObject,
+	} {
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(t)
+		s.objects[t] = obj
+	}
+}
+
+func (s *StorageSuite) open(c *C) sivafs.SivaFS {
+	fs, err := sivafs.NewFilesystem(s.underlying, "repo.siva", memfs.New())
+	c.Assert(err, IsNil)
+	return fs
+}
+
+// GoGitSuite runs the tests go-git provides for every storage.Storer.
+type GoGitSuite struct {
+	test.BaseStorageSuite
+}
+
+var _ = Suite(&GoGitSuite{})
+
+func (s *GoGitSuite) SetUpTest(c *C) {
+	fs, err := sivafs.NewFilesystem(osfs.New(c.MkDir()), "repo.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	s.BaseStorageSuite = test.NewBaseStorageSuite(New(fs))
+	s.BaseStorageSuite.SetUpTest(c)
+}
+
+// reopen syncs the siva file and opens a new storage reading it.
+func (s *StorageSuite) reopen(c *C) {
+	c.Assert(s.storage.Close(), IsNil)
+	c.Assert(s.fs.Sync(), IsNil)
+	s.fs = s.open(c)
+	s.storage = New(s.fs)
+}
+
+func objectEquals(c *C, a, b plumbing.EncodedObject) {
+	c.Assert(a.Hash(), Equals, b.Hash())
+	c.Assert(a.Type(), Equals, b.Type())
+	c.Assert(a.Size(), Equals, b.Size())
+
+	ra, err := a.Reader()
+	c.Assert(err, IsNil)
+	ca, err := ioutil.ReadAll(ra)
+	c.Assert(err, IsNil)
+	c.Assert(ra.Close(), IsNil)
+
+	rb, err := b.Reader()
+	c.Assert(err, IsNil)
+	cb, err := ioutil.ReadAll(rb)
+	c.Assert(err, IsNil)
+	c.Assert(rb.Close(), IsNil)
+
+	c.Assert(ca, DeepEquals, cb)
+}
+
+func (s *StorageSuite) TestSetEncodedObjectAndEncodedObject(c *C) {
+	for _, expected := range s.objects {
+		h, err := s.storage.SetEncodedObject(expected)
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, expected.Hash())
+	}
+
+	s.reopen(c)
+	for t, expected := range s.objects {
+		comment := Commentf("failed for type %s", t)
+		h := expected.Hash()
+
+		obj, err := s.storage.EncodedObject(t, h)
+		c.Assert(err, IsNil, comment)
+		objectEquals(c, obj, expected)
+
+		obj, err = s.storage.EncodedObject(plumbing.AnyObject, h)
+		c.Assert(err, IsNil, comment)
+		objectEquals(c, obj, expected)
+
+		for other := range s.objects {
+			if other == t {
+				continue
+			}
+
+			obj, err = s.storage.EncodedObject(other, h)
+			c.Assert(obj, IsNil)
+			c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+		}
+
+		c.Assert(s.storage.HasEncodedObject(h), IsNil)
+		size, err := s.storage.EncodedObjectSize(h)
+		c.Assert(err, IsNil)
+		c.Assert(size, Equals, expected.Size())
+	}
+
+	c.Assert(s.storage.HasEncodedObject(plumbing.ZeroHash),
+		Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *StorageSuite) TestSetEncodedObjectInvalid(c *C) {
+	obj := s.storage.NewEncodedObject()
+	obj.SetType(plumbing.REFDeltaObject)
+
+	_, err := s.storage.SetEncodedObject(obj)
+	c.Assert(err, NotNil)
+}
+
+func (s *StorageSuite) TestIterEncodedObjects(c *C) {
+	for _, obj := range s.objects {
+		_, err := s.storage.SetEncodedObject(obj)
+		c.Assert(err, IsNil)
+	}
+
+	for t, expected := range s.objects {
+		comment := Commentf("failed for type %s", t)
+		iter, err := s.storage.IterEncodedObjects(t)
+		c.Assert(err, IsNil, comment)
+
+		obj, err := iter.Next()
+		c.Assert(err, IsNil, comment)
+		objectEquals(c, obj, expected)
+
+		obj, err = iter.Next()
+		c.Assert(obj, IsNil)
+		c.Assert(err, Equals, io.EOF, comment)
+	}
+
+	iter, err := s.storage.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+
+	found := make(map[plumbing.Hash]bool)
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		found[obj.Hash()] = true
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(found, HasLen, len(s.objects))
+	for _, obj := range s.objects {
+		c.Assert(found[obj.Hash()], Equals, true)
+	}
+}
+
+func (s *StorageSuite) TestSetShallowAndShallow(c *C) {
+	expected := []plumbing.Hash{
+		plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
+		plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733"),
+		plumbing.NewHash("c78874f116be67ecf54df225a613162b84cc6ebf"),
+	}
+
+	c.Assert(s.storage.SetShallow(expected), IsNil)
+	s.reopen(c)
+
+	result, err := s.storage.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, expected)
+}
+
+func (s *StorageSuite) TestSetConfigAndConfig(c *C) {
+	expected := config.NewConfig()
+	expected.Core.IsBare = true
+	expected.Remotes["foo"] = &config.RemoteConfig{
+		Name: "foo",
+		URLs: []string{"http://foo/bar.git"},
+	}
+
+	c.Assert(s.storage.SetConfig(expected), IsNil)
+	s.reopen(c)
+
+	cfg, err := s.storage.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.IsBare, Equals, true)
+	c.Assert(cfg.Remotes["foo"].URLs, DeepEquals, expected.Remotes["foo"].URLs)
+}
+
+func (s *StorageSuite) TestSetConfigInvalid(c *C) {
+	cfg := config.NewConfig()
+	cfg.Remotes["foo"] = &config.RemoteConfig{}
+
+	c.Assert(s.storage.SetConfig(cfg), NotNil)
+}
+
+func (s *StorageSuite) TestIndex(c *C) {
+	idx, err := s.storage.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx, DeepEquals, &index.Index{Version: 2})
+}
+
+func (s *StorageSuite) TestSetIndexAndIndex(c *C) {
+	expected := &index.Index{Version: 2}
+	expected.Entries = append(expected.Entries, &index.Entry{
+		Name:       "foo",
+		Hash:       plumbing.NewHash("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"),
+		ModifiedAt: time.Unix(1500000000, 0),
+	})
+
+	c.Assert(s.storage.SetIndex(expected), IsNil)
+	s.reopen(c)
+
+	idx, err := s.storage.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 1)
+	c.Assert(idx.Entries[0].Name, Equals, "foo")
+	c.Assert(idx.Entries[0].Hash, Equals, expected.Entries[0].Hash)
+}
+
+func (s *StorageSuite) TestModule(c *C) {
+	m, err := s.storage.Module("foo")
+	c.Assert(err, IsNil)
+
+	obj := s.objects[plumbing.BlobObject]
+	_, err = m.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	s.reopen(c)
+
+	m, err = s.storage.Module("foo")
+	c.Assert(err, IsNil)
+	c.Assert(m.HasEncodedObject(obj.Hash()), IsNil)
+	c.Assert(s.storage.HasEncodedObject(obj.Hash()),
+		Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *StorageSuite) TestRepository(c *C) {
+	worktree := memfs.New()
+	r, err := git.Init(s.storage, worktree)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	signature := &object.Signature{Name: "foo", When: time.Unix(1500000000, 0)}
+	var commits []plumbing.Hash
+	for i := 0; i < 3; i++ {
+		f, err := worktree.Create("file")
+		c.Assert(err, IsNil)
+		_, err = fmt.Fprintf(f, "version %d\n", i)
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		_, err = w.Add("file")
+		c.Assert(err, IsNil)
+
+		h, err := w.Commit(fmt.Sprintf("commit %d", i), &git.CommitOptions{
+			Author: signature,
+		})
+		c.Assert(err, IsNil)
+		commits = append(commits, h)
+	}
+
+	s.reopen(c)
+	r, err = git.Open(s.storage, nil)
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, commits[2])
+
+	log, err := r.Log(&git.LogOptions{})
+	c.Assert(err, IsNil)
+
+	var messages []string
+	err = log.ForEach(func(commit *object.Commit) error {
+		messages = append(messages, commit.Message)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(messages, DeepEquals, []string{"commit 2", "commit 1", "commit 0"})
+}
@@ -1,9 +1,19 @@
 module gopkg.in/src-d/go-billy-siva.v4
 
 require (
+	github.com/golang/snappy v1.0.0
+	github.com/klauspost/compress v1.18.0
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127
 	gopkg.in/src-d/go-billy.v4 v4.3.2
 	gopkg.in/src-d/go-siva.v1 v1.7.0
 )
 
-go 1.13
+require (
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
+
+// The library only needs Go 1.13, but klauspost/compress v1.18.0, used for
+// the Zstd compression, needs Go 1.22.
+go 1.22
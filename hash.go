@@ -0,0 +1,271 @@
+package sivafs
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"hash"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrNoHash          = errors.New("file has no stored hash")
+	ErrUnavailableHash = errors.New("hash function is not available")
+)
+
+// SivaHash is implemented by siva filesystems storing hashes of file
+// contents, see the Hash option.
+type SivaHash interface {
+	// Hash returns the hash function and the hash of the contents of the
+	// file. It returns ErrNoHash if the file was written without hash.
+	Hash(path string) (crypto.Hash, []byte, error)
+	// FindByHash returns the paths of the files with the given hash, in
+	// name order.
+	FindByHash(sum []byte) ([]string, error)
+}
+
+// EntryInfo holds the information stored for a file besides its siva entry.
+// It is returned by the Sys method of the FileInfo of files having any.
+type EntryInfo struct {
+	// Hash is the hash function used to compute Sum, zero if the file was
+	// written without hash.
+	Hash crypto.Hash
+	// Sum is the hash of the file contents.
+	Sum []byte
+	// Meta holds the metadata of the file, see SivaMeta.
+	Meta map[string]string
+}
+
+// metaDir holds the sidecars of files.
+const metaDir = reservedDir + "/meta"
+
+// sidecar is stored for a file, in an entry of metaDir named after the
+// stored name of the file, to keep information that does not fit in siva
+// headers. The offset, size and CRC32 of the file entry are recorded so
+// sidecars of files written again without them are ignored. Sidecars written
+// by older versions have no offset.
+type sidecar struct {
+	Offset *int64            `json:"offset,omitempty"`
+	Size   uint64            `json:"size"`
+	CRC32  uint32            `json:"crc32"`
+	Hash   crypto.Hash       `json:"hash,omitempty"`
+	Sum    []byte            `json:"sum,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"`
+}
+
+// describes returns true if the sidecar was written for the entry.
+func (s *sidecar) describes(e *siva.IndexEntry) bool {
+	if s.Offset != nil && *s.Offset != entryOffset(e) {
+		return false
+	}
+
+	return s.Size == e.Size && s.CRC32 == e.CRC32
+}
+
+func (s *sidecar) info() *EntryInfo {
+	return &EntryInfo{Hash: s.Hash, Sum: s.Sum, Meta: copyMeta(s.Meta)}
+}
+
+// sidecarPath returns the path of the sidecar of the file with the given
+// stored name.
+func sidecarPath(stored string) string {
+	return metaDir + "/" + stored
+}
+
+// newHash returns the hash computed while writing files, or nil if files are
+// written without hash.
+func (fs *sivaFS) newHash() (hash.Hash, error) {
+	if fs.options.Hash == 0 {
+		return nil, nil
+	}
+
+	if !fs.options.Hash.Available() {
+		return nil, ErrUnavailableHash
+	}
+
+	return fs.options.Hash.New(), nil
+}
+
+// storedName returns the name stored in the siva file for the entry of the
+// index.
+func (fs *sivaFS) storedName(e *siva.IndexEntry) (string, error) {
+	if e.Flags&flagEncryptedName == 0 {
+		return e.Name, nil
+	}
+
+	return hashName(fs.options.Keys, e.Name)
+}
+
+// writeFileSidecar writes the sidecar of the file with the given stored
+// name, just written in this session, holding the hash of its contents
+// computed by h and its metadata. Nothing is written if both are empty.
+func (fs *sivaFS) writeFileSidecar(stored string, h hash.Hash, meta map[string]string) error {
+	if h == nil && len(meta) == 0 {
+		return nil
+	}
+
+	s := &sidecar{Meta: meta}
+	if h != nil {
+		s.Hash = fs.options.Hash
+		s.Sum = h.Sum(nil)
+	}
+
+	return fs.writeSidecar(stored, s)
+}
+
+// writeSidecar writes the sidecar of the file with the given stored name,
+// just written in this session.
+func (fs *sivaFS) writeSidecar(stored string, s *sidecar) error {
+	stored = siva.ToSafePath(stored)
+	index, err := fs.getReadWriter().Index()
+	if err != nil {
+		return err
+	}
+
+	e := siva.OrderedIndex(index).Find(stored)
+	if e == nil {
+		return os.ErrNotExist
+	}
+
+	offset := entryOffset(e)
+	s.Offset = &offset
+	s.Size = e.Size
+	s.CRC32 = e.CRC32
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return fs.writeEntry(&siva.Header{
+		Name:    sidecarPath(stored),
+		Mode:    0644,
+		ModTime: time.Now(),
+	}, data)
+}
+
+// readSidecar returns the sidecar of the entry, looked up in the full index
+// of the siva file, or nil if it does not have one.
+func (fs *sivaFS) readSidecar(index siva.OrderedIndex, e *siva.IndexEntry) (*sidecar, error) {
+	stored, err := fs.storedName(e)
+	if err != nil {
+		return nil, err
+	}
+
+	se := index.Find(sidecarPath(stored))
+	if se == nil {
+		return nil, nil
+	}
+
+	r, err := fs.contentReader(se)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sidecar{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+
+	if !s.describes(e) {
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+// sysFunc returns a function loading the EntryInfo of the entry once.
+func (fs *sivaFS) sysFunc(e *siva.IndexEntry) func() interface{} {
+	var once sync.Once
+	var info *EntryInfo
+	return func() interface{} {
+		once.Do(func() {
+			index, err := fs.getFullIndex()
+			if err != nil {
+				return
+			}
+
+			s, err := fs.readSidecar(index, e)
+			if err == nil && s != nil {
+				info = s.info()
+			}
+		})
+
+		if info == nil {
+			return nil
+		}
+
+		return info
+	}
+}
+
+// Hash implements SivaHash interface.
+func (fs *sivaFS) Hash(path string) (crypto.Hash, []byte, error) {
+	_, s, err := fs.pathSidecar(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if s.Sum == nil {
+		return 0, nil, ErrNoHash
+	}
+
+	return s.Hash, s.Sum, nil
+}
+
+// FindByHash implements SivaHash interface.
+func (fs *sivaFS) FindByHash(sum []byte) ([]string, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	hashes, err := fs.getHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string(nil), hashes[string(sum)]...), nil
+}
+
+// getHashes returns the paths of the files by their hash, in name order.
+// They are read from the sidecars once per generation, see indexedFS.
+func (fs *sivaFS) getHashes() (map[string][]string, error) {
+	gen := fs.generation()
+	fs.mu.Lock()
+	hashes := fs.hashes
+	fresh := fs.hashesGen == gen
+	fs.mu.Unlock()
+	if hashes != nil && fresh {
+		return hashes, nil
+	}
+
+	full, err := fs.getFullIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes = make(map[string][]string)
+	for _, e := range visibleEntries(full) {
+		s, err := fs.readSidecar(full, e)
+		if err != nil {
+			return nil, err
+		}
+
+		if s != nil && s.Sum != nil {
+			hashes[string(s.Sum)] = append(hashes[string(s.Sum)], e.Name)
+		}
+	}
+
+	for _, paths := range hashes {
+		sort.Strings(paths)
+	}
+
+	fs.mu.Lock()
+	fs.hashes, fs.hashesGen = hashes, gen
+	fs.mu.Unlock()
+	return hashes, nil
+}
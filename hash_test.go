@@ -0,0 +1,172 @@
+package sivafs
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type HashSuite struct {
+	memSuite
+}
+
+var _ = Suite(&HashSuite{})
+
+func sha256Sum(data string) []byte {
+	sum := sha256.Sum256([]byte(data))
+	return sum[:]
+}
+
+func (s *HashSuite) testHash(c *C, o SivaFSOptions) {
+	o.Hash = crypto.SHA256
+	fs := s.open(c, o)
+	writeFile(c, fs, "dir/one", []byte("same"))
+	writeFile(c, fs, "two", []byte("same"))
+	writeFile(c, fs, "three", []byte("other"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, o)
+	h, sum, err := fs.(SivaHash).Hash("dir/one")
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, crypto.SHA256)
+	c.Assert(sum, DeepEquals, sha256Sum("same"))
+
+	fi, err := fs.Stat("three")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Sys(), DeepEquals, &EntryInfo{
+		Hash: crypto.SHA256,
+		Sum:  sha256Sum("other"),
+	})
+
+	paths, err := fs.(SivaHash).FindByHash(sha256Sum("same"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"dir/one", "two"})
+
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"dir", "three", "two"})
+	testFileContent(c, fs, "two", "same")
+}
+
+func (s *HashSuite) TestHash(c *C) {
+	s.testHash(c, SivaFSOptions{})
+}
+
+func (s *HashSuite) TestHashEncoded(c *C) {
+	s.testHash(c, SivaFSOptions{
+		Compression:  Gzip,
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+	})
+}
+
+func (s *HashSuite) TestHashDedup(c *C) {
+	s.testHash(c, SivaFSOptions{Dedup: true})
+}
+
+func (s *HashSuite) TestNoHash(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "file", []byte("data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	_, _, err := fs.(SivaHash).Hash("file")
+	c.Assert(err, Equals, ErrNoHash)
+
+	fi, err := fs.Stat("file")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Sys(), IsNil)
+
+	_, _, err = fs.(SivaHash).Hash("missing")
+	c.Assert(err, NotNil)
+}
+
+func (s *HashSuite) TestRewrittenWithoutHash(c *C) {
+	fs := s.open(c, SivaFSOptions{Hash: crypto.SHA256})
+	writeFile(c, fs, "file", []byte("data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "file", []byte("new data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	_, _, err := fs.(SivaHash).Hash("file")
+	c.Assert(err, Equals, ErrNoHash)
+
+	paths, err := fs.(SivaHash).FindByHash(sha256Sum("data"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 0)
+}
+
+func (s *HashSuite) TestRewrittenSameContents(c *C) {
+	fs := s.open(c, SivaFSOptions{Hash: crypto.SHA256})
+	writeFile(c, fs, "file", []byte("data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "file", []byte("data"))
+
+	_, _, err := fs.(SivaHash).Hash("file")
+	c.Assert(err, Equals, ErrNoHash)
+
+	paths, err := fs.(SivaHash).FindByHash(sha256Sum("data"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 0)
+}
+
+func (s *HashSuite) TestFindByHashWritten(c *C) {
+	fs := s.open(c, SivaFSOptions{Hash: crypto.SHA256})
+	writeFile(c, fs, "one", []byte("data"))
+
+	paths, err := fs.(SivaHash).FindByHash(sha256Sum("data"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"one"})
+
+	writeFile(c, fs, "two", []byte("data"))
+	c.Assert(fs.Remove("one"), IsNil)
+	paths, err = fs.(SivaHash).FindByHash(sha256Sum("data"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"two"})
+}
+
+func (s *HashSuite) TestFindByHashIndexOnce(c *C) {
+	observer := &testObserver{}
+	fs := s.open(c, SivaFSOptions{Hash: crypto.SHA256, Observer: observer})
+	for i := 0; i < 10; i++ {
+		writeFile(c, fs, fmt.Sprintf("file%d", i), []byte("data"))
+	}
+
+	before := observer.count(OpIndex)
+	paths, err := fs.(SivaHash).FindByHash(sha256Sum("data"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 10)
+	c.Assert(observer.count(OpIndex)-before, Equals, 1)
+}
+
+func (s *HashSuite) TestUnavailableHash(c *C) {
+	fs := s.open(c, SivaFSOptions{Hash: crypto.MD4})
+	_, err := fs.Create("file")
+	c.Assert(err, Equals, ErrUnavailableHash)
+}
+
+func (s *HashSuite) TestSharded(c *C) {
+	o := ShardedOptions{SivaFSOptions: SivaFSOptions{Hash: crypto.SHA256}}
+	fs, err := NewShardedWithOptions(s.mem, []string{"0.siva", "1.siva"}, memfs.New(), o)
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(c, fs, name, []byte("same"))
+	}
+	writeFile(c, fs, "e", []byte("other"))
+	c.Assert(fs.Sync(), IsNil)
+
+	paths, err := fs.(SivaHash).FindByHash(sha256Sum("same"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"a", "b", "c", "d"})
+
+	_, sum, err := fs.(SivaHash).Hash("e")
+	c.Assert(err, IsNil)
+	c.Assert(sum, DeepEquals, sha256Sum("other"))
+}
@@ -0,0 +1,93 @@
+package sivafs
+
+import (
+	"io"
+	"sort"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// SivaHistory is implemented by siva filesystems able to list the states of
+// the siva file.
+type SivaHistory interface {
+	// History returns the revisions of the siva file, from the oldest to the
+	// newest.
+	History() ([]Revision, error)
+}
+
+// Revision is a state of a siva file, left by writing an index block.
+type Revision struct {
+	// Offset is the offset of the index of the revision, it can be used as
+	// the Offset option.
+	Offset uint64
+	// Written holds the paths of the files written in the revision, sorted.
+	Written []string
+	// Deleted holds the paths of the files deleted in the revision, sorted.
+	Deleted []string
+}
+
+// History implements SivaHistory interface. Blocks holding only signatures
+// are part of the revision they sign.
+func (fs *sivaFS) History() ([]Revision, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	blocks, err := readIndexBlocks(fs.f, fs.end)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	for _, b := range blocks {
+		if isSignatureBlock(b) && len(revisions) > 0 {
+			revisions[len(revisions)-1].Offset = b.End
+			continue
+		}
+
+		r, err := fs.revision(b)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, *r)
+	}
+
+	return revisions, nil
+}
+
+// revision returns the revision left by the block.
+func (fs *sivaFS) revision(b *indexBlock) (*Revision, error) {
+	deleted := make(map[string]bool)
+	for _, e := range b.Entries {
+		name := e.Name
+		if !fs.options.UnsafePaths {
+			name = siva.ToSafePath(name)
+		}
+
+		if e.Flags&flagEncryptedName != 0 {
+			r := io.NewSectionReader(fs.f, int64(b.Offset(e)), int64(e.Size))
+			var err error
+			if name, err = fs.realName(name, r); err != nil {
+				return nil, err
+			}
+		}
+
+		if !isReserved(name) {
+			deleted[name] = e.Flags&siva.FlagDeleted != 0
+		}
+	}
+
+	r := &Revision{Offset: b.End}
+	for name, d := range deleted {
+		if d {
+			r.Deleted = append(r.Deleted, name)
+		} else {
+			r.Written = append(r.Written, name)
+		}
+	}
+
+	sort.Strings(r.Written)
+	sort.Strings(r.Deleted)
+	return r, nil
+}
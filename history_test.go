@@ -0,0 +1,73 @@
+package sivafs
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+type HistorySuite struct {
+	memSuite
+}
+
+var _ = Suite(&HistorySuite{})
+
+func (s *HistorySuite) testHistory(c *C, o SivaFSOptions) {
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte("one"))
+	writeFile(c, fs, "dir/two", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	c.Assert(fs.Remove("one"), IsNil)
+	writeFile(c, fs, "dir/two", []byte("new two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, o)
+	history, err := fs.(SivaHistory).History()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 2)
+	c.Assert(history[0].Written, DeepEquals, []string{"dir/two", "one"})
+	c.Assert(history[0].Deleted, HasLen, 0)
+	c.Assert(history[1].Written, DeepEquals, []string{"dir/two"})
+	c.Assert(history[1].Deleted, DeepEquals, []string{"one"})
+
+	ro := o
+	ro.ReadOnly = true
+	ro.Offset = history[0].Offset
+	fs = s.open(c, ro)
+	testFileContent(c, fs, "one", "one")
+	testFileContent(c, fs, "dir/two", "two")
+
+	history, err = fs.(SivaHistory).History()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 1)
+}
+
+func (s *HistorySuite) TestHistory(c *C) {
+	s.testHistory(c, SivaFSOptions{})
+}
+
+func (s *HistorySuite) TestHistoryEncoded(c *C) {
+	s.testHistory(c, SivaFSOptions{
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+		Dedup:        true,
+		Hash:         crypto.SHA256,
+	})
+}
+
+func (s *HistorySuite) TestSigned(c *C) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	fs := s.open(c, SivaFSOptions{SigningKey: priv})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	history, err := fs.(SivaHistory).History()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 1)
+	c.Assert(history[0].Offset, Equals, readBlocks(c, s.mem, "test.siva")[1].End)
+}
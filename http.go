@@ -0,0 +1,287 @@
+package sivafs
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrRangeNotSupported = errors.New("server does not support range requests")
+	ErrUnknownSize       = errors.New("server did not report the file size")
+	ErrRemoteChanged     = errors.New("remote file changed since it was opened")
+	ErrInvalidRange      = errors.New("server returned a range other than the requested")
+)
+
+const (
+	defaultHTTPBlockSize = 64 * 1024
+	defaultHTTPCacheSize = 64
+)
+
+// HTTPOptions holds configuration options for filesystems read over HTTP.
+type HTTPOptions struct {
+	SivaFSOptions
+	// Client is the client used to make the requests. If it is nil
+	// http.DefaultClient is used.
+	Client *http.Client
+	// BlockSize is the size of the blocks requested to the server and kept
+	// in the cache. Reads bigger than a block are requested directly. If
+	// it is 0 a block size of 64 KiB is used.
+	BlockSize int64
+	// CacheSize is the number of blocks kept in the cache. If it is 0, 64
+	// blocks are cached.
+	CacheSize int
+}
+
+// NewFilesystemHTTP creates a read only filesystem backed by the siva file
+// served at url. Only the index blocks and the requested entries are
+// downloaded, using HTTP range requests, so the server must support them.
+// offset is the index offset inside the siva file. Set it to 0 to use the
+// last index.
+func NewFilesystemHTTP(url string, offset uint64) (SivaFS, error) {
+	return NewFilesystemHTTPWithOptions(url, HTTPOptions{
+		SivaFSOptions: SivaFSOptions{Offset: offset},
+	})
+}
+
+// NewFilesystemHTTPWithOptions creates a read only filesystem backed by the
+// siva file served at url and accepts options. See NewFilesystemHTTP
+// documentation.
+func NewFilesystemHTTPWithOptions(url string, o HTTPOptions) (SivaFS, error) {
+	r, err := newHTTPReaderAt(url, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFilesystemFromReaderAtWithOptions(r, r.size, o.SivaFSOptions)
+}
+
+// httpReaderAt is an io.ReaderAt reading a remote file with range requests.
+// Small reads are served from a LRU cache of fixed size blocks.
+type httpReaderAt struct {
+	client    *http.Client
+	url       string
+	size      int64
+	blockSize int64
+	cacheSize int
+	// etag and lastModified are the validators returned by the server
+	// when the file was opened. Ranges are only read from the same version
+	// of the file.
+	etag         string
+	lastModified string
+
+	mu     sync.Mutex
+	lru    *list.List
+	blocks map[int64]*list.Element
+}
+
+type httpBlock struct {
+	n    int64
+	data []byte
+}
+
+func newHTTPReaderAt(url string, o HTTPOptions) (*httpReaderAt, error) {
+	r := &httpReaderAt{
+		client:    o.Client,
+		url:       url,
+		blockSize: o.BlockSize,
+		cacheSize: o.CacheSize,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+	}
+
+	if r.client == nil {
+		r.client = http.DefaultClient
+	}
+
+	if r.blockSize <= 0 {
+		r.blockSize = defaultHTTPBlockSize
+	}
+
+	if r.cacheSize <= 0 {
+		r.cacheSize = defaultHTTPCacheSize
+	}
+
+	if err := r.head(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// head requests the size and the validators of the remote file.
+func (r *httpReaderAt) head() error {
+	res, err := r.client.Head(r.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q requesting %s", res.Status, r.url)
+	}
+
+	if res.ContentLength < 0 {
+		return ErrUnknownSize
+	}
+
+	r.size = res.ContentLength
+	r.etag = res.Header.Get("ETag")
+	r.lastModified = res.Header.Get("Last-Modified")
+	return nil
+}
+
+// setConditions makes the request fail if the remote file is not the one
+// opened. Weak entity tags cannot be used in range requests, so the
+// modification time is used instead.
+func (r *httpReaderAt) setConditions(req *http.Request) {
+	switch {
+	case r.etag != "" && !strings.HasPrefix(r.etag, "W/"):
+		req.Header.Set("If-Range", r.etag)
+		req.Header.Set("If-Match", r.etag)
+	case r.lastModified != "":
+		req.Header.Set("If-Range", r.lastModified)
+		req.Header.Set("If-Unmodified-Since", r.lastModified)
+	}
+}
+
+// changed returns true if the response is from a version of the remote file
+// different from the one opened.
+func (r *httpReaderAt) changed(res *http.Response) bool {
+	switch {
+	case r.etag != "" && !strings.HasPrefix(r.etag, "W/"):
+		return res.Header.Get("ETag") != r.etag
+	case r.lastModified != "":
+		return res.Header.Get("Last-Modified") != r.lastModified
+	default:
+		return false
+	}
+}
+
+func (r *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	var eof error
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		eof = io.EOF
+	}
+
+	if int64(len(p)) >= r.blockSize {
+		if err := r.fetch(p, off); err != nil {
+			return 0, err
+		}
+
+		return len(p), eof
+	}
+
+	var read int
+	for read < len(p) {
+		pos := off + int64(read)
+		data, err := r.block(pos / r.blockSize)
+		if err != nil {
+			return read, err
+		}
+
+		read += copy(p[read:], data[pos%r.blockSize:])
+	}
+
+	return read, eof
+}
+
+// block returns the contents of the block number n, fetching it from the
+// server if it is not cached.
+func (r *httpReaderAt) block(n int64) ([]byte, error) {
+	r.mu.Lock()
+	if e, ok := r.blocks[n]; ok {
+		r.lru.MoveToFront(e)
+		r.mu.Unlock()
+		return e.Value.(*httpBlock).data, nil
+	}
+	r.mu.Unlock()
+
+	start := n * r.blockSize
+	end := start + r.blockSize
+	if end > r.size {
+		end = r.size
+	}
+
+	data := make([]byte, end-start)
+	if err := r.fetch(data, start); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.blocks[n]; !ok {
+		r.blocks[n] = r.lru.PushFront(&httpBlock{n: n, data: data})
+	}
+
+	for r.lru.Len() > r.cacheSize {
+		e := r.lru.Back()
+		r.lru.Remove(e)
+		delete(r.blocks, e.Value.(*httpBlock).n)
+	}
+
+	return data, nil
+}
+
+// isRange returns true if the Content-Range of the response is the n bytes
+// starting at off of the remote file.
+func (r *httpReaderAt) isRange(res *http.Response, off, n int64) bool {
+	var start, end, size int64
+	_, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size)
+	return err == nil && start == off && end == off+n-1 && size == r.size
+}
+
+// fetch fills p with the remote contents starting at off.
+func (r *httpReaderAt) fetch(p []byte, off int64) error {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	r.setConditions(req)
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		if r.changed(res) {
+			return ErrRemoteChanged
+		}
+
+		if !r.isRange(res, off, int64(len(p))) {
+			return ErrInvalidRange
+		}
+	case http.StatusOK:
+		if r.changed(res) {
+			return ErrRemoteChanged
+		}
+
+		return ErrRangeNotSupported
+	case http.StatusPreconditionFailed:
+		return ErrRemoteChanged
+	default:
+		return fmt.Errorf("unexpected status %q requesting %s", res.Status, r.url)
+	}
+
+	_, err = io.ReadFull(res.Body, p)
+	return err
+}
@@ -0,0 +1,225 @@
+package sivafs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type HTTPFilesystemSuite struct{}
+
+var _ = Suite(&HTTPFilesystemSuite{})
+
+type countingHandler struct {
+	data     []byte
+	requests int64
+	bytes    int64
+	ranges   bool
+
+	mu      sync.Mutex
+	etag    string
+	modTime time.Time
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&h.requests, 1)
+	if !h.ranges {
+		r.Header.Del("Range")
+	}
+
+	h.mu.Lock()
+	data, etag, modTime := h.data, h.etag, h.modTime
+	h.mu.Unlock()
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	cw := &countingWriter{ResponseWriter: w, n: &h.bytes}
+	http.ServeContent(cw, r, "file.siva", modTime, bytes.NewReader(data))
+}
+
+// replace changes the file served by the handler.
+func (h *countingHandler) replace(data []byte, etag string, modTime time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.data, h.etag, h.modTime = data, etag, modTime
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func (s *HTTPFilesystemSuite) TestReadFs(c *C) {
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(fixture.Path())
+		c.Assert(err, IsNil)
+
+		srv := httptest.NewServer(&countingHandler{data: data, ranges: true})
+
+		fs, err := NewFilesystemHTTPWithOptions(srv.URL, HTTPOptions{
+			SivaFSOptions: SivaFSOptions{UnsafePaths: fixture.unsafe},
+			BlockSize:     16,
+			CacheSize:     4,
+		})
+		c.Assert(err, IsNil)
+
+		testOpenAndRead(c, fixture, fs)
+		testReadDir(c, fixture, fs)
+		testStat(c, fixture, fs)
+		testNested(c, fixture, fs)
+
+		srv.Close()
+	}
+}
+
+func (s *HTTPFilesystemSuite) TestSparseRead(c *C) {
+	mem := memfs.New()
+	fs := New(mem, "big.siva")
+
+	big := bytes.Repeat([]byte("0123456789"), 100*1024)
+	writeFile(c, fs, "big.bin", big)
+	writeFile(c, fs, "small.txt", []byte("small"))
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err := mem.Open("big.siva")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	h := &countingHandler{data: data, ranges: true}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	hfs, err := NewFilesystemHTTP(srv.URL, 0)
+	c.Assert(err, IsNil)
+
+	sf, err := hfs.Open("small.txt")
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(sf)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "small")
+	c.Assert(sf.Close(), IsNil)
+
+	c.Assert(atomic.LoadInt64(&h.bytes) < int64(len(data)/10), Equals, true)
+
+	bf, err := hfs.Open("big.bin")
+	c.Assert(err, IsNil)
+	content, err = ioutil.ReadAll(bf)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(content, big), Equals, true)
+	c.Assert(bf.Close(), IsNil)
+}
+
+func (s *HTTPFilesystemSuite) TestRangeNotSupported(c *C) {
+	data, err := ioutil.ReadFile(fixtures[0].Path())
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(&countingHandler{data: data})
+	defer srv.Close()
+
+	fs, err := NewFilesystemHTTP(srv.URL, 0)
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("gopher.txt")
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), ErrRangeNotSupported.Error()), Equals, true)
+}
+
+func (s *HTTPFilesystemSuite) TestRemoteChanged(c *C) {
+	data, err := ioutil.ReadFile(fixtures[0].Path())
+	c.Assert(err, IsNil)
+
+	modTime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	validators := []struct {
+		etag    string
+		modTime time.Time
+	}{
+		{`"v1"`, time.Time{}},
+		{"", modTime},
+	}
+
+	for _, v := range validators {
+		h := &countingHandler{ranges: true}
+		h.replace(data, v.etag, v.modTime)
+		srv := httptest.NewServer(h)
+
+		fs, err := NewFilesystemHTTPWithOptions(srv.URL, HTTPOptions{
+			BlockSize: 16,
+			CacheSize: 1,
+		})
+		c.Assert(err, IsNil)
+		_, err = fs.Stat("gopher.txt")
+		c.Assert(err, IsNil)
+
+		changed := append([]byte("changed"), data...)
+		if v.etag != "" {
+			h.replace(changed, `"v2"`, v.modTime)
+		} else {
+			h.replace(changed, v.etag, modTime.Add(time.Hour))
+		}
+
+		f, err := fs.Open("gopher.txt")
+		if err == nil {
+			_, err = ioutil.ReadAll(f)
+		}
+		c.Assert(err, NotNil)
+		c.Assert(strings.Contains(err.Error(), ErrRemoteChanged.Error()), Equals, true)
+
+		srv.Close()
+	}
+}
+
+// shiftedHandler serves the range following the one requested.
+type shiftedHandler struct {
+	countingHandler
+}
+
+func (h *shiftedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var start, end int64
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start+1, end+1))
+	}
+
+	h.countingHandler.ServeHTTP(w, r)
+}
+
+func (s *HTTPFilesystemSuite) TestInvalidRange(c *C) {
+	data, err := ioutil.ReadFile(fixtures[0].Path())
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(&shiftedHandler{countingHandler{data: data, ranges: true}})
+	defer srv.Close()
+
+	fs, err := NewFilesystemHTTP(srv.URL, 0)
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("gopher.txt")
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), ErrInvalidRange.Error()), Equals, true)
+}
+
+func (s *HTTPFilesystemSuite) TestNotFound(c *C) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := NewFilesystemHTTP(srv.URL, 0)
+	c.Assert(err, NotNil)
+}
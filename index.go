@@ -0,0 +1,175 @@
+package sivafs
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// indexFooterSize is the size of siva.IndexFooter once written.
+const indexFooterSize = 24
+
+// indexBlock is a block of a siva file: the entries written in a session
+// followed by their index.
+type indexBlock struct {
+	// Start is the offset where the block starts.
+	Start uint64
+	// End is the offset where the block ends. It is the offset used to open
+	// the siva file as it was when the block was written.
+	End    uint64
+	Footer siva.IndexFooter
+	// Entries holds the index entries in the order they were written.
+	Entries []*siva.IndexEntry
+}
+
+// Offset returns the absolute offset of the entry contents in the siva file.
+func (b *indexBlock) Offset(e *siva.IndexEntry) uint64 {
+	return b.Start + e.Start
+}
+
+// entryOffset returns the absolute offset of the entry contents in the siva
+// file. siva does not export it, so it is read back from the section reader
+// returned by siva.Reader.Get.
+func entryOffset(e *siva.IndexEntry) int64 {
+	// empty entries are given a size so the section reader reads something.
+	copied := *e
+	copied.Size = 1
+
+	var p offsetProbe
+	sr, err := siva.NewReader(&p).Get(&copied)
+	if err != nil {
+		return 0
+	}
+
+	sr.ReadAt(make([]byte, 1), 0)
+	return p.offset
+}
+
+// deleteFails returns true if siva fails writing a deletion entry for the
+// name in a block with the given index. siva only fails deleting names
+// missing from the index that sort after all of its names, so an empty entry
+// has to be written first in that case only.
+func deleteFails(index siva.OrderedIndex, name string) bool {
+	return len(index) > 0 && index.Pos(name) == len(index)
+}
+
+// offsetProbe is an io.ReaderAt recording the offset it is read from.
+type offsetProbe struct {
+	offset int64
+}
+
+func (p *offsetProbe) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (p *offsetProbe) ReadAt(b []byte, off int64) (int, error) {
+	p.offset = off
+	return 0, io.EOF
+}
+
+func (p *offsetProbe) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+// readIndexBlocks reads the index blocks of a siva file ending at end,
+// returning them from the oldest to the newest.
+func readIndexBlocks(r io.ReaderAt, end uint64) ([]*indexBlock, error) {
+	var blocks []*indexBlock
+	for end > 0 {
+		b, err := readIndexBlock(r, end)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, b)
+		end = b.Start
+	}
+
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	return blocks, nil
+}
+
+// readIndexBlock reads the index block ending at end.
+func readIndexBlock(r io.ReaderAt, end uint64) (*indexBlock, error) {
+	if end < indexFooterSize {
+		return nil, &siva.IndexReadError{Err: siva.ErrInvalidIndexEntry}
+	}
+
+	b := &indexBlock{End: end}
+	footer := io.NewSectionReader(r, int64(end-indexFooterSize), indexFooterSize)
+	if err := b.Footer.ReadFrom(footer); err != nil {
+		return nil, &siva.IndexReadError{Err: err}
+	}
+
+	indexStart := int64(end) - indexFooterSize - int64(b.Footer.IndexSize)
+	if indexStart < 0 || b.Footer.BlockSize > end {
+		return nil, &siva.IndexReadError{Err: siva.ErrInvalidIndexEntry}
+	}
+
+	b.Start = end - b.Footer.BlockSize
+
+	data := make([]byte, b.Footer.IndexSize)
+	if _, err := r.ReadAt(data, indexStart); err != nil {
+		return nil, &siva.IndexReadError{Err: err}
+	}
+
+	if crc32.ChecksumIEEE(data) != b.Footer.CRC32 {
+		return nil, &siva.IndexReadError{Err: siva.ErrCRC32Missmatch}
+	}
+
+	entries, err := readIndexEntries(data, b.Footer.EntryCount)
+	if err != nil {
+		return nil, &siva.IndexReadError{Err: err}
+	}
+
+	b.Entries = entries
+	return b, nil
+}
+
+func readIndexEntries(data []byte, count uint32) ([]*siva.IndexEntry, error) {
+	sig := len(siva.IndexSignature)
+	if len(data) < sig+1 || !bytes.Equal(data[:sig], siva.IndexSignature) {
+		return nil, siva.ErrInvalidSignature
+	}
+
+	if data[sig] != siva.IndexVersion {
+		return nil, siva.ErrUnsupportedIndexVersion
+	}
+
+	r := bytes.NewReader(data[sig+1:])
+	entries := make([]*siva.IndexEntry, count)
+	for i := range entries {
+		e := &siva.IndexEntry{}
+		if err := e.ReadFrom(r); err != nil {
+			return nil, err
+		}
+
+		entries[i] = e
+	}
+
+	return entries, nil
+}
+
+// blockEntry is an index entry and the block holding it.
+type blockEntry struct {
+	*siva.IndexEntry
+	Block *indexBlock
+}
+
+// latestEntries returns the newest entry of every path in the blocks,
+// including the deleted ones.
+func latestEntries(blocks []*indexBlock) map[string]blockEntry {
+	latest := make(map[string]blockEntry)
+	for _, b := range blocks {
+		for _, e := range b.Entries {
+			latest[e.Name] = blockEntry{e, b}
+		}
+	}
+
+	return latest
+}
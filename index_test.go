@@ -0,0 +1,88 @@
+package sivafs
+
+import (
+	"io"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+type IndexSuite struct{}
+
+var _ = Suite(&IndexSuite{})
+
+// readBlocks returns the index blocks of the siva file.
+func readBlocks(c *C, fs billy.Filesystem, path string) []*indexBlock {
+	f, err := fs.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	end, err := f.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+
+	blocks, err := readIndexBlocks(f, uint64(end))
+	c.Assert(err, IsNil)
+	return blocks
+}
+
+func (s *IndexSuite) TestReadIndexBlocks(c *C) {
+	mem := memfs.New()
+	fs := New(mem, "test.siva")
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+	writeFile(c, fs, "two.txt", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	writeFile(c, fs, "three.txt", []byte("three"))
+	c.Assert(fs.Remove("one.txt"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	blocks := readBlocks(c, mem, "test.siva")
+	c.Assert(blocks, HasLen, 2)
+
+	c.Assert(blocks[0].Start, Equals, uint64(0))
+	c.Assert(blocks[1].Start, Equals, blocks[0].End)
+	c.Assert(blocks[0].Entries, HasLen, 2)
+	c.Assert(blocks[1].Entries, HasLen, 2)
+
+	c.Assert(blocks[1].Entries[0].Name, Equals, "three.txt")
+	c.Assert(blocks[1].Entries[1].Name, Equals, "one.txt")
+	c.Assert(blocks[1].Entries[1].Flags, Equals, siva.FlagDeleted)
+
+	f, err := mem.Open("test.siva")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	e := blocks[1].Entries[0]
+	content := make([]byte, e.Size)
+	_, err = f.ReadAt(content, int64(blocks[1].Offset(e)))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "three")
+
+	latest := latestEntries(blocks)
+	c.Assert(latest, HasLen, 3)
+	c.Assert(latest["one.txt"].Flags, Equals, siva.FlagDeleted)
+}
+
+func (s *IndexSuite) TestReadIndexBlocksFixtures(c *C) {
+	for _, fixture := range fixtures {
+		fs, err := fixture.FSOffset(c, true, 0)
+		c.Assert(err, IsNil)
+
+		root := fs.(*readOnly).sivaRoot.(*sivaFS)
+		c.Assert(root.ensureOpen(), IsNil)
+
+		blocks, err := readIndexBlocks(root.f, root.end)
+		c.Assert(err, IsNil)
+		c.Assert(len(blocks) > 0, Equals, true)
+		c.Assert(blocks[0].Start, Equals, uint64(0))
+	}
+}
+
+func (s *IndexSuite) TestReadIndexBlocksCorrupted(c *C) {
+	data := []byte("this is not a siva file, this is not a siva file")
+	_, err := readIndexBlocks(bytesReaderAt(data), uint64(len(data)))
+	c.Assert(err, NotNil)
+}
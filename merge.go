@@ -0,0 +1,267 @@
+package sivafs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var ErrUnsupportedFilesystem = errors.New("filesystem is not a siva filesystem of this package")
+
+// MergePolicy chooses the file kept by Merge when both filesystems have a
+// file with the same path.
+type MergePolicy int
+
+const (
+	// MergeKeepDst keeps the file of the destination.
+	MergeKeepDst MergePolicy = iota
+	// MergeTakeSrc replaces the file of the destination with the one of the
+	// source.
+	MergeTakeSrc
+	// MergeNewest keeps the file with the newest modification time. The
+	// file of the destination is kept when both have the same time.
+	MergeNewest
+	// MergeFail does not merge anything and returns a *MergeConflictError
+	// when there is any conflict.
+	MergeFail
+)
+
+// MergeConflictError is returned by Merge with the MergeFail policy and
+// holds the paths found in both filesystems.
+type MergeConflictError struct {
+	Paths []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict in %d paths: %s",
+		len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// Merge appends every file of src to dst, using policy to choose the file
+// kept when both have the same path, and syncs dst. Entries are copied as
+// they are stored in the source siva file, with their modification time,
+// flags, hash and metadata, without decoding their contents. Encrypted
+// files, and every file when dst has the Keys option, are decrypted instead
+// and written again like new files of dst, so src must be able to read them.
+//
+// Both filesystems must be created by this package.
+func Merge(dst, src SivaFS, policy MergePolicy) error {
+	d, ok := dst.(indexedFS)
+	if !ok {
+		return ErrUnsupportedFilesystem
+	}
+
+	s, ok := src.(indexedFS)
+	if !ok {
+		return ErrUnsupportedFilesystem
+	}
+
+	srcIndex, err := s.liveIndex()
+	if err != nil {
+		return err
+	}
+
+	dstIndex, err := d.liveIndex()
+	if err != nil {
+		return err
+	}
+
+	var entries []*siva.IndexEntry
+	var conflicts []string
+	for _, e := range srcIndex {
+		old := dstIndex.Find(e.Name)
+		if old == nil {
+			entries = append(entries, e)
+			continue
+		}
+
+		conflicts = append(conflicts, e.Name)
+		if takeSrc(policy, old, e) {
+			entries = append(entries, e)
+		}
+	}
+
+	if policy == MergeFail && len(conflicts) > 0 {
+		return &MergeConflictError{Paths: conflicts}
+	}
+
+	for _, e := range entries {
+		if err := d.copyFile(src, e); err != nil {
+			return err
+		}
+	}
+
+	return dst.Sync()
+}
+
+// takeSrc returns true if the policy replaces the dst entry with the src one.
+func takeSrc(policy MergePolicy, dst, src *siva.IndexEntry) bool {
+	switch policy {
+	case MergeTakeSrc:
+		return true
+	case MergeNewest:
+		return src.ModTime.After(dst.ModTime)
+	default:
+		return false
+	}
+}
+
+// copyFile implements indexedFS interface.
+func (fs *sivaFS) copyFile(src SivaFS, e *siva.IndexEntry) error {
+	if err := fs.ensureOpen(); err != nil {
+		return err
+	}
+
+	if fs.getReadWriter() == nil {
+		return ErrReadOnlyFilesystem
+	}
+
+	if fs.fileWriteModeOpen {
+		return ErrFileWriteModeAlreadyOpen
+	}
+
+	info, err := copiedInfo(src, e.Name)
+	if err != nil {
+		return err
+	}
+
+	stored, r, err := src.(indexedFS).openRaw(e.Name)
+	if err != nil {
+		return err
+	}
+
+	if stored.Flags&(flagEncrypted|flagEncryptedName) != 0 || fs.options.Keys != nil {
+		return fs.copyDecoded(src, stored, info)
+	}
+
+	return fs.copyStored(stored, r, info)
+}
+
+// copiedInfo returns the hash and metadata of the file of src copied by
+// copyFile.
+func copiedInfo(src SivaFS, path string) (*EntryInfo, error) {
+	info := &EntryInfo{}
+	if h, ok := src.(SivaHash); ok {
+		var err error
+		info.Hash, info.Sum, err = h.Hash(path)
+		if err != nil && err != ErrNoHash {
+			return nil, err
+		}
+	}
+
+	if m, ok := src.(SivaMeta); ok {
+		var err error
+		if info.Meta, err = m.ListMeta(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// copyStored writes the entry of another siva file with the bytes stored for
+// it, read from r, and a sidecar holding info. If the copy fails, the entry
+// it replaced, if any, is written again.
+func (fs *sivaFS) copyStored(e *siva.IndexEntry, r io.Reader, info *EntryInfo) error {
+	rw := fs.getReadWriter()
+	index, err := rw.Index()
+	if err != nil {
+		return err
+	}
+
+	prev := siva.OrderedIndex(index).Find(siva.ToSafePath(e.Name))
+	err = rw.WriteHeader(&siva.Header{
+		Name:    e.Name,
+		Mode:    e.Mode,
+		ModTime: e.ModTime,
+		Flags:   e.Flags &^ (siva.FlagDeleted | flagReference),
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.setWritten(e.Name, false)
+	_, err = copyContext(fs.ctx, rw, r)
+	if ferr := rw.Flush(); err == nil {
+		err = ferr
+	}
+
+	if err != nil {
+		if derr := fs.dropWritten(e.Name, e.Name, prev); derr != nil {
+			return derr
+		}
+
+		return err
+	}
+
+	if info.Sum == nil && len(info.Meta) == 0 {
+		return nil
+	}
+
+	return fs.writeSidecar(e.Name, &sidecar{
+		Hash: info.Hash,
+		Sum:  info.Sum,
+		Meta: copyMeta(info.Meta),
+	})
+}
+
+// copyDecoded writes the file of src with the entry as a new file, encoded
+// with the options of fs, with the metadata of info. The hash of info is kept
+// if fs does not compute its own.
+func (fs *sivaFS) copyDecoded(src billy.Basic, e *siva.IndexEntry, info *EntryInfo) error {
+	in, err := src.Open(e.Name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	keepHash := fs.options.Hash == 0 && info.Sum != nil
+	meta := copyMeta(info.Meta)
+	if keepHash {
+		meta = nil
+	}
+
+	f, err := fs.createFile(&siva.Header{
+		Name:    e.Name,
+		Mode:    e.Mode,
+		ModTime: e.ModTime,
+		Flags:   e.Flags &^ (siva.FlagDeleted | flagReference | flagCompressionMask | flagEncrypted | flagEncryptedName),
+	}, os.O_WRONLY, meta)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if !keepHash {
+		return nil
+	}
+
+	index, err := fs.getIndex()
+	if err != nil {
+		return err
+	}
+
+	stored, err := fs.storedName(index.Find(e.Name))
+	if err != nil {
+		return err
+	}
+
+	return fs.writeSidecar(stored, &sidecar{
+		Hash: info.Hash,
+		Sum:  info.Sum,
+		Meta: copyMeta(info.Meta),
+	})
+}
@@ -0,0 +1,206 @@
+package sivafs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+type MergeSuite struct {
+	memSuite
+	old time.Time
+	new time.Time
+}
+
+var _ = Suite(&MergeSuite{})
+
+func (s *MergeSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+	s.old = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.new = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dst := s.open(c, "dst.siva")
+	s.write(c, dst, "both-old-src", "dst", s.new)
+	s.write(c, dst, "both-new-src", "dst", s.old)
+	s.write(c, dst, "dst-only", "dst", s.old)
+	c.Assert(dst.Sync(), IsNil)
+
+	src := s.open(c, "src.siva")
+	s.write(c, src, "both-old-src", "src", s.old)
+	s.write(c, src, "both-new-src", "src", s.new)
+	s.write(c, src, "dir/src-only", "src", s.old)
+	c.Assert(src.Sync(), IsNil)
+}
+
+func (s *MergeSuite) open(c *C, name string) SivaFS {
+	return s.openPath(c, name, SivaFSOptions{})
+}
+
+func (s *MergeSuite) write(c *C, fs SivaFS, name, data string, modTime time.Time) {
+	f, err := fs.(SivaCreateHeader).CreateHeader(&siva.Header{
+		Name:    name,
+		Mode:    0644,
+		ModTime: modTime,
+	})
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *MergeSuite) merge(c *C, policy MergePolicy) (SivaFS, error) {
+	dst := s.open(c, "dst.siva")
+	err := Merge(dst, s.open(c, "src.siva"), policy)
+	return s.open(c, "dst.siva"), err
+}
+
+func (s *MergeSuite) TestKeepDst(c *C) {
+	dst, err := s.merge(c, MergeKeepDst)
+	c.Assert(err, IsNil)
+
+	testFileContent(c, dst, "both-old-src", "dst")
+	testFileContent(c, dst, "both-new-src", "dst")
+	testFileContent(c, dst, "dst-only", "dst")
+	testFileContent(c, dst, "dir/src-only", "src")
+}
+
+func (s *MergeSuite) TestTakeSrc(c *C) {
+	dst, err := s.merge(c, MergeTakeSrc)
+	c.Assert(err, IsNil)
+
+	testFileContent(c, dst, "both-old-src", "src")
+	testFileContent(c, dst, "both-new-src", "src")
+	testFileContent(c, dst, "dst-only", "dst")
+	testFileContent(c, dst, "dir/src-only", "src")
+}
+
+func (s *MergeSuite) TestNewest(c *C) {
+	dst, err := s.merge(c, MergeNewest)
+	c.Assert(err, IsNil)
+
+	testFileContent(c, dst, "both-old-src", "dst")
+	testFileContent(c, dst, "both-new-src", "src")
+	testFileContent(c, dst, "dst-only", "dst")
+	testFileContent(c, dst, "dir/src-only", "src")
+
+	fi, err := dst.Stat("both-new-src")
+	c.Assert(err, IsNil)
+	c.Assert(fi.ModTime().Equal(s.new), Equals, true)
+}
+
+func (s *MergeSuite) TestFail(c *C) {
+	blocks := readBlocks(c, s.mem, "dst.siva")
+
+	dst, err := s.merge(c, MergeFail)
+	c.Assert(err, DeepEquals, &MergeConflictError{
+		Paths: []string{"both-new-src", "both-old-src"},
+	})
+
+	_, err = dst.Stat("dir/src-only")
+	c.Assert(err, NotNil)
+	c.Assert(readBlocks(c, s.mem, "dst.siva"), HasLen, len(blocks))
+}
+
+func (s *MergeSuite) TestSingleBlock(c *C) {
+	blocks := readBlocks(c, s.mem, "dst.siva")
+
+	_, err := s.merge(c, MergeTakeSrc)
+	c.Assert(err, IsNil)
+	c.Assert(readBlocks(c, s.mem, "dst.siva"), HasLen, len(blocks)+1)
+}
+
+func (s *MergeSuite) TestRawCopyKeepsFlags(c *C) {
+	src := s.open(c, "flags.siva")
+	f, err := src.(SivaCreateHeader).CreateHeader(&siva.Header{
+		Name:    "flagged",
+		Mode:    0644,
+		ModTime: s.old,
+		Flags:   1 << 8,
+	})
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("stored"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(src.Sync(), IsNil)
+
+	c.Assert(Merge(s.open(c, "dst.siva"), src, MergeFail), IsNil)
+
+	blocks := readBlocks(c, s.mem, "dst.siva")
+	entries := blocks[len(blocks)-1].Entries
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Name, Equals, "flagged")
+	c.Assert(entries[0].Flags, Equals, siva.Flag(1<<8))
+	c.Assert(entries[0].ModTime.Equal(s.old), Equals, true)
+}
+
+func (s *MergeSuite) TestSidecars(c *C) {
+	src, err := NewFilesystemWithOptions(s.mem, "hashed.siva", memfs.New(), SivaFSOptions{
+		Hash: crypto.SHA256,
+	})
+	c.Assert(err, IsNil)
+	f, err := src.(SivaMeta).CreateWithMeta("file", map[string]string{"key": "value"})
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("contents"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(src.Sync(), IsNil)
+
+	c.Assert(Merge(s.open(c, "dst.siva"), src, MergeFail), IsNil)
+
+	dst := s.open(c, "dst.siva")
+	value, err := dst.(SivaMeta).GetMeta("file", "key")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "value")
+
+	h, sum, err := dst.(SivaHash).Hash("file")
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, crypto.SHA256)
+	expected := sha256.Sum256([]byte("contents"))
+	c.Assert(sum, DeepEquals, expected[:])
+}
+
+func (s *MergeSuite) TestEncrypted(c *C) {
+	src, err := NewFilesystemWithOptions(s.mem, "encrypted.siva", memfs.New(), SivaFSOptions{
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+		Hash:         crypto.SHA256,
+	})
+	c.Assert(err, IsNil)
+	writeFile(c, src, "dir/secret", []byte("secret"))
+	c.Assert(src.(SivaMeta).SetMeta("dir/secret", "key", "value"), IsNil)
+	c.Assert(src.Sync(), IsNil)
+
+	other := newTestKeys()
+	other.keys["one"] = bytes.Repeat([]byte{4}, 32)
+	other.keys[NamesKeyID] = bytes.Repeat([]byte{5}, 32)
+
+	for _, o := range []SivaFSOptions{{}, {Keys: other, EncryptNames: true}} {
+		s.mem.Remove("copy.siva")
+		dst, err := NewFilesystemWithOptions(s.mem, "copy.siva", memfs.New(), o)
+		c.Assert(err, IsNil)
+		c.Assert(Merge(dst, src, MergeFail), IsNil)
+
+		dst, err = NewFilesystemWithOptions(s.mem, "copy.siva", memfs.New(), o)
+		c.Assert(err, IsNil)
+		testFileContent(c, dst, "dir/secret", "secret")
+
+		value, err := dst.(SivaMeta).GetMeta("dir/secret", "key")
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, "value")
+
+		_, sum, err := dst.(SivaHash).Hash("dir/secret")
+		c.Assert(err, IsNil)
+		expected := sha256.Sum256([]byte("secret"))
+		c.Assert(sum, DeepEquals, expected[:])
+	}
+}
+
+func (s *MergeSuite) TestUnsupported(c *C) {
+	err := Merge(s.open(c, "dst.siva"), nil, MergeKeepDst)
+	c.Assert(err, Equals, ErrUnsupportedFilesystem)
+}
@@ -0,0 +1,165 @@
+package sivafs
+
+import (
+	"errors"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var ErrNoMeta = errors.New("file has no metadata with the given key")
+
+// SivaMeta is implemented by siva filesystems able to store key/value
+// metadata with their files, like extended attributes. Metadata is also
+// returned by the Sys method of the FileInfo of files, see EntryInfo.
+//
+// Metadata is kept while the file is not written again. Changing it does not
+// write the file contents again.
+type SivaMeta interface {
+	// CreateWithMeta creates a new file like Create, storing the given
+	// metadata with it once closed.
+	CreateWithMeta(path string, meta map[string]string) (billy.File, error)
+	// GetMeta returns the value of the key in the metadata of the file. It
+	// returns ErrNoMeta if the key is not set.
+	GetMeta(path, key string) (string, error)
+	// SetMeta sets the value of the key in the metadata of the file.
+	SetMeta(path, key, value string) error
+	// RemoveMeta removes the key from the metadata of the file.
+	RemoveMeta(path, key string) error
+	// ListMeta returns the metadata of the file.
+	ListMeta(path string) (map[string]string, error)
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(meta))
+	for k, v := range meta {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+// CreateWithMeta implements SivaMeta interface.
+func (fs *sivaFS) CreateWithMeta(path string, meta map[string]string) (billy.File, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	if fs.getReadWriter() == nil {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	return fs.create(normalizePath(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		0666, copyMeta(meta))
+}
+
+// GetMeta implements SivaMeta interface.
+func (fs *sivaFS) GetMeta(path, key string) (string, error) {
+	meta, err := fs.ListMeta(path)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := meta[key]
+	if !ok {
+		return "", ErrNoMeta
+	}
+
+	return value, nil
+}
+
+// ListMeta implements SivaMeta interface.
+func (fs *sivaFS) ListMeta(path string) (map[string]string, error) {
+	_, s, err := fs.pathSidecar(path)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := copyMeta(s.Meta)
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+
+	return meta, nil
+}
+
+// SetMeta implements SivaMeta interface.
+func (fs *sivaFS) SetMeta(path, key, value string) error {
+	return fs.updateMeta(path, func(meta map[string]string) {
+		meta[key] = value
+	})
+}
+
+// RemoveMeta implements SivaMeta interface.
+func (fs *sivaFS) RemoveMeta(path, key string) error {
+	return fs.updateMeta(path, func(meta map[string]string) {
+		delete(meta, key)
+	})
+}
+
+// pathSidecar returns the entry of the file and its sidecar, empty if it has
+// none.
+func (fs *sivaFS) pathSidecar(path string) (*siva.IndexEntry, *sidecar, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, nil, err
+	}
+
+	full, err := fs.getFullIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := visibleEntries(full).Find(normalizePath(path))
+	if e == nil {
+		return nil, nil, os.ErrNotExist
+	}
+
+	s, err := fs.readSidecar(full, e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s == nil {
+		s = &sidecar{}
+	}
+
+	return e, s, nil
+}
+
+// updateMeta writes a new sidecar of the file with its metadata changed by
+// update.
+func (fs *sivaFS) updateMeta(path string, update func(map[string]string)) error {
+	if err := fs.ensureOpen(); err != nil {
+		return err
+	}
+
+	if fs.getReadWriter() == nil {
+		return ErrReadOnlyFilesystem
+	}
+
+	if fs.fileWriteModeOpen {
+		return ErrFileWriteModeAlreadyOpen
+	}
+
+	e, s, err := fs.pathSidecar(path)
+	if err != nil {
+		return err
+	}
+
+	if s.Meta == nil {
+		s.Meta = make(map[string]string)
+	}
+
+	update(s.Meta)
+	stored, err := fs.storedName(e)
+	if err != nil {
+		return err
+	}
+
+	return fs.writeSidecar(stored, s)
+}
@@ -0,0 +1,135 @@
+package sivafs
+
+import (
+	"crypto"
+
+	. "gopkg.in/check.v1"
+)
+
+type MetaSuite struct {
+	memSuite
+}
+
+var _ = Suite(&MetaSuite{})
+
+func (s *MetaSuite) testMeta(c *C, o SivaFSOptions) {
+	fs := s.open(c, o)
+	f, err := fs.(SivaMeta).CreateWithMeta("dir/file", map[string]string{
+		"content-type": "text/plain",
+	})
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("data"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.(SivaMeta).SetMeta("dir/file", "commit", "abc"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, o)
+	value, err := fs.(SivaMeta).GetMeta("dir/file", "content-type")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "text/plain")
+
+	c.Assert(fs.(SivaMeta).RemoveMeta("dir/file", "content-type"), IsNil)
+	c.Assert(fs.(SivaMeta).SetMeta("dir/file", "job", "42"), IsNil)
+
+	meta, err := fs.(SivaMeta).ListMeta("dir/file")
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, map[string]string{"commit": "abc", "job": "42"})
+
+	_, err = fs.(SivaMeta).GetMeta("dir/file", "content-type")
+	c.Assert(err, Equals, ErrNoMeta)
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, o)
+	fi, err := fs.Stat("dir/file")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Sys().(*EntryInfo).Meta, DeepEquals,
+		map[string]string{"commit": "abc", "job": "42"})
+	testFileContent(c, fs, "dir/file", "data")
+
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"dir"})
+}
+
+func (s *MetaSuite) TestMeta(c *C) {
+	s.testMeta(c, SivaFSOptions{})
+}
+
+func (s *MetaSuite) TestMetaEncoded(c *C) {
+	s.testMeta(c, SivaFSOptions{
+		Compression:  Snappy,
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+		Dedup:        true,
+	})
+}
+
+func (s *MetaSuite) TestSetMetaKeepsContents(c *C) {
+	fs := s.open(c, SivaFSOptions{Hash: crypto.SHA256})
+	writeFile(c, fs, "file", []byte("data"))
+	c.Assert(fs.Sync(), IsNil)
+
+	blocks := readBlocks(c, s.mem, "test.siva")
+	c.Assert(fs.(SivaMeta).SetMeta("file", "key", "value"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	updated := readBlocks(c, s.mem, "test.siva")
+	c.Assert(updated, HasLen, len(blocks)+1)
+	for _, e := range updated[len(blocks)].Entries {
+		c.Assert(isReserved(e.Name), Equals, true)
+	}
+
+	_, sum, err := fs.(SivaHash).Hash("file")
+	c.Assert(err, IsNil)
+	c.Assert(sum, DeepEquals, sha256Sum("data"))
+}
+
+func (s *MetaSuite) TestRewritten(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "file", []byte("data"))
+	c.Assert(fs.(SivaMeta).SetMeta("file", "key", "value"), IsNil)
+	writeFile(c, fs, "file", []byte("new data"))
+
+	meta, err := fs.(SivaMeta).ListMeta("file")
+	c.Assert(err, IsNil)
+	c.Assert(meta, HasLen, 0)
+}
+
+func (s *MetaSuite) TestErrors(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	c.Assert(fs.(SivaMeta).SetMeta("missing", "key", "value"), NotNil)
+
+	f, err := fs.Create("file")
+	c.Assert(err, IsNil)
+	c.Assert(fs.(SivaMeta).SetMeta("file", "key", "value"),
+		Equals, ErrFileWriteModeAlreadyOpen)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{ReadOnly: true})
+	c.Assert(fs.(SivaMeta).SetMeta("file", "key", "value"),
+		Equals, ErrReadOnlyFilesystem)
+}
+
+func (s *MetaSuite) TestUnion(c *C) {
+	lower := s.open(c, SivaFSOptions{})
+	writeFile(c, lower, "lower", []byte("lower"))
+	c.Assert(lower.(SivaMeta).SetMeta("lower", "layer", "lower"), IsNil)
+	c.Assert(lower.Sync(), IsNil)
+
+	top := s.openPath(c, "top.siva", SivaFSOptions{})
+
+	u, err := NewUnionWithOptions([]SivaFS{lower, top}, UnionOptions{Writable: true})
+	c.Assert(err, IsNil)
+	writeFile(c, u, "top", []byte("top"))
+	c.Assert(u.(SivaMeta).SetMeta("top", "layer", "top"), IsNil)
+	c.Assert(u.(SivaMeta).SetMeta("lower", "layer", "top"),
+		Equals, ErrReadOnlyFilesystem)
+
+	for _, name := range []string{"lower", "top"} {
+		value, err := u.(SivaMeta).GetMeta(name, "layer")
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, name)
+	}
+}
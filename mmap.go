@@ -0,0 +1,201 @@
+package sivafs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-billy.v4/helper/polyfill"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+var ErrNotMapped = errors.New("file is not memory mapped")
+
+// minMmapSize is the minimum length of a mapping. Files are mapped with room
+// to grow so appending entries does not need a new mapping for every read.
+const minMmapSize = 1 << 20
+
+// mmapFile is a billy.File whose ReadAt calls are served from a read only
+// memory mapping of the file. The mapping is recreated when the file grows
+// past it, and only read up to the size of the file, refreshed when it grows
+// or is truncated. Previous mappings are kept until the file is closed, so the
+// slices returned by slice are valid until then.
+type mmapFile struct {
+	billy.File
+	os *os.File
+
+	mu     sync.RWMutex
+	data   []byte
+	size   int64
+	old    [][]byte
+	closed bool
+}
+
+// newMmapFile returns f, opened from the given path of fs, as a memory mapped
+// file if fs is the OS filesystem and the platform supports it. Otherwise f
+// is returned.
+func newMmapFile(fs billy.Basic, path string, f billy.File) billy.File {
+	path, ok := osPath(fs, path)
+	if !ok || !mmapSupported {
+		return f
+	}
+
+	osf, err := os.Open(path)
+	if err != nil {
+		return f
+	}
+
+	m := &mmapFile{File: f, os: osf}
+	if err := m.remap(0); err != nil {
+		osf.Close()
+		return f
+	}
+
+	return m
+}
+
+// osPath returns the path in the OS filesystem of the given path of fs, if
+// fs is backed by the OS filesystem.
+func osPath(fs billy.Basic, path string) (string, bool) {
+	for {
+		switch f := fs.(type) {
+		case *osfs.OS:
+			return path, true
+		case *chroot.ChrootHelper:
+			path = filepath.Join(f.Root(), path)
+			fs = f.Underlying()
+		case *polyfill.Polyfill:
+			fs = f.Underlying()
+		default:
+			return "", false
+		}
+	}
+}
+
+// remap refreshes the file size and maps the file again if it needs to read
+// up to end and it is not mapped. It must be called with the lock held.
+func (m *mmapFile) remap(end int64) error {
+	fi, err := m.os.Stat()
+	if err != nil {
+		return err
+	}
+
+	m.size = fi.Size()
+	if m.size < end {
+		end = m.size
+	}
+
+	if end <= int64(len(m.data)) && m.data != nil {
+		return nil
+	}
+
+	length := 2 * m.size
+	if length < minMmapSize {
+		length = minMmapSize
+	}
+
+	data, err := mmap(m.os.Fd(), int(length))
+	if err != nil {
+		return err
+	}
+
+	if m.data != nil {
+		m.old = append(m.old, m.data)
+	}
+
+	m.data = data
+	return nil
+}
+
+func (m *mmapFile) ReadAt(p []byte, off int64) (int, error) {
+	b, err := m.slice(off, int64(len(p)))
+	n := copy(p, b)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// slice returns the mapped contents of the file from off up to n bytes. It
+// returns less bytes if the file ends before.
+func (m *mmapFile) slice(off, n int64) ([]byte, error) {
+	if off < 0 {
+		return nil, errors.New("negative offset")
+	}
+
+	end := off + n
+
+	m.mu.RLock()
+	closed := m.closed
+	if !closed && end <= m.size && end <= int64(len(m.data)) {
+		b := m.data[off:end]
+		m.mu.RUnlock()
+		return b, nil
+	}
+	m.mu.RUnlock()
+
+	if closed {
+		return nil, os.ErrClosed
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.remap(end); err != nil {
+		return nil, err
+	}
+
+	if off >= m.size {
+		return nil, io.EOF
+	}
+
+	if end > m.size {
+		end = m.size
+	}
+
+	return m.data[off:end], nil
+}
+
+// Truncate truncates the file and shrinks the size read from the mapping, so
+// reads past the new end fail instead of faulting.
+func (m *mmapFile) Truncate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.File.Truncate(size); err != nil {
+		return err
+	}
+
+	if size < m.size {
+		m.size = size
+	}
+
+	return nil
+}
+
+func (m *mmapFile) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return os.ErrClosed
+	}
+
+	m.closed = true
+	for _, data := range append(m.old, m.data) {
+		if err := munmap(data); err != nil {
+			m.os.Close()
+			m.File.Close()
+			return err
+		}
+	}
+
+	m.data, m.old = nil, nil
+	m.os.Close()
+	return m.File.Close()
+}
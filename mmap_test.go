@@ -0,0 +1,167 @@
+package sivafs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type MmapSuite struct{}
+
+var _ = Suite(&MmapSuite{})
+
+func (s *MmapSuite) SetUpTest(c *C) {
+	if !mmapSupported {
+		c.Skip("mmap not supported")
+	}
+}
+
+func (s *MmapSuite) TestReadFs(c *C) {
+	for _, fixture := range fixtures {
+		tmp := c.MkDir()
+		err := copyFile(fixture.Path(), tmp+"/"+fixture.name)
+		c.Assert(err, IsNil)
+
+		for _, readOnly := range []bool{true, false} {
+			fs, err := NewFilesystemWithOptions(
+				osfs.New(tmp), fixture.name, memfs.New(),
+				SivaFSOptions{
+					UnsafePaths: fixture.unsafe,
+					ReadOnly:    readOnly,
+					MMap:        true,
+				},
+			)
+			c.Assert(err, IsNil)
+
+			testOpenAndRead(c, fixture, fs)
+			testReadDir(c, fixture, fs)
+			testStat(c, fixture, fs)
+			testNested(c, fixture, fs)
+			c.Assert(fs.Sync(), IsNil)
+		}
+	}
+}
+
+func (s *MmapSuite) TestBytes(c *C) {
+	fs := NewWithOptions(osfs.New(c.MkDir()), "test.siva", SivaFSOptions{
+		MMap: true,
+	})
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+	testFileBytes(c, fs, "one.txt", []byte("one"))
+
+	// the file grows past the initial mapping
+	big := bytes.Repeat([]byte("0123456789"), 300*1024)
+	writeFile(c, fs, "big.bin", big)
+	testFileBytes(c, fs, "big.bin", big)
+	testFileBytes(c, fs, "one.txt", []byte("one"))
+
+	b, closer, err := fs.(SivaBytes).Bytes("big.bin")
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(b, big), Equals, true)
+
+	// the slice is still mapped after syncing, until it is released.
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(bytes.Equal(b, big), Equals, true)
+	c.Assert(closer.Close(), IsNil)
+
+	ro, err := NewFilesystemWithOptions(
+		fs.(*sivaFS).underlying, "test.siva", nil,
+		SivaFSOptions{ReadOnly: true, MMap: true},
+	)
+	c.Assert(err, IsNil)
+
+	b, closer, err = ro.(SivaBytes).Bytes("big.bin")
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(b, big), Equals, true)
+	c.Assert(closer.Close(), IsNil)
+}
+
+func (s *MmapSuite) TestBytesOpenFile(c *C) {
+	fs := NewWithOptions(osfs.New(c.MkDir()), "test.siva", SivaFSOptions{
+		MMap: true,
+	})
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+	writeFile(c, fs, "empty", nil)
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err := fs.Open("one.txt")
+	c.Assert(err, IsNil)
+	b, err := f.(interface{ Bytes() ([]byte, error) }).Bytes()
+	c.Assert(err, IsNil)
+
+	writeFile(c, fs, "two.txt", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(string(b), Equals, "one")
+	c.Assert(f.Close(), IsNil)
+
+	_, err = f.(interface{ Bytes() ([]byte, error) }).Bytes()
+	c.Assert(err, Equals, os.ErrClosed)
+
+	testFileBytes(c, fs, "empty", []byte{})
+}
+
+func (s *MmapSuite) TestRollback(c *C) {
+	fs := NewWithOptions(osfs.New(c.MkDir()), "test.siva", SivaFSOptions{
+		MMap: true,
+	})
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	big := bytes.Repeat([]byte("0123456789"), 300*1024)
+	writeFile(c, fs, "big.bin", big)
+	f, err := fs.Open("big.bin")
+	c.Assert(err, IsNil)
+	_, err = f.Read(make([]byte, 1))
+	c.Assert(err, IsNil)
+
+	// the file is truncated under the mapping.
+	c.Assert(fs.(SivaRollback).Rollback(), IsNil)
+	read, _ := ioutil.ReadAll(f)
+	c.Assert(len(read) < len(big)-1, Equals, true)
+	c.Assert(f.Close(), IsNil)
+
+	testFileBytes(c, fs, "one.txt", []byte("one"))
+}
+
+func (s *MmapSuite) TestNotMapped(c *C) {
+	fs, err := NewFilesystem(memfs.New(), "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+
+	_, _, err = fs.(SivaBytes).Bytes("one.txt")
+	c.Assert(err, Equals, ErrNotMapped)
+
+	fs, err = NewFilesystemWithOptions(
+		osfs.New(c.MkDir()), "test.siva", memfs.New(), SivaFSOptions{},
+	)
+	c.Assert(err, IsNil)
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+
+	_, _, err = fs.(SivaBytes).Bytes("one.txt")
+	c.Assert(err, Equals, ErrNotMapped)
+}
+
+func testFileBytes(c *C, fs billy.Basic, name string, expected []byte) {
+	f, err := fs.Open(name)
+	c.Assert(err, IsNil)
+
+	b, err := f.(interface{ Bytes() ([]byte, error) }).Bytes()
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(b, expected), Equals, true)
+
+	read, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(read, expected), Equals, true)
+
+	c.Assert(f.Close(), IsNil)
+}
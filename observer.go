@@ -0,0 +1,127 @@
+package sivafs
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Observer receives the events of a siva filesystem, see the Observer option.
+// It is called synchronously by the goroutine using the filesystem, so it
+// must be fast and safe for concurrent use.
+type Observer interface {
+	Observe(e *Event)
+}
+
+// Op is the operation of an Event.
+type Op int
+
+const (
+	// OpOpen is the opening of the siva file, reading its index.
+	OpOpen Op = iota
+	// OpClose is the closing of the siva file, done by Sync.
+	OpClose
+	// OpRead is a read of the contents of a file.
+	OpRead
+	// OpWrite is a write of the contents of a file.
+	OpWrite
+	// OpFlush is the writing of a new index block, done by Sync.
+	OpFlush
+	// OpIndex is the loading of the index of the files.
+	OpIndex
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpOpen:
+		return "open"
+	case OpClose:
+		return "close"
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	case OpFlush:
+		return "flush"
+	case OpIndex:
+		return "index"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is an operation done by a siva filesystem.
+type Event struct {
+	Op Op
+	// Path is the path of the file read or written, or the path of the siva
+	// file for the other operations.
+	Path string
+	// Size is the number of bytes read or written, the size of the siva file
+	// once opened, the size of the index block flushed or the number of
+	// entries of the index loaded.
+	Size int64
+	// Duration is the time the operation took.
+	Duration time.Duration
+	// Err is the error returned by the operation, if any. io.EOF is not
+	// reported.
+	Err error
+	// Context is the context of the filesystem, see SivaContext.
+	Context context.Context
+}
+
+// observe sends the event of an operation started at start to the Observer
+// option, if any.
+func (fs *sivaFS) observe(op Op, path string, size int64, start time.Time, err error) {
+	o := fs.options.Observer
+	if o == nil {
+		return
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	o.Observe(&Event{
+		Op:       op,
+		Path:     path,
+		Size:     size,
+		Duration: time.Since(start),
+		Err:      err,
+		Context:  fs.ctx,
+	})
+}
+
+// observedReader reports the reads of a file to the Observer option.
+type observedReader struct {
+	fileReader
+	fs   *sivaFS
+	path string
+}
+
+func (r *observedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.fileReader.Read(p)
+	r.fs.observe(OpRead, r.path, int64(n), start, err)
+	return n, err
+}
+
+func (r *observedReader) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := r.fileReader.ReadAt(p, off)
+	r.fs.observe(OpRead, r.path, int64(n), start, err)
+	return n, err
+}
+
+// observedWriter reports the writes of a file to the Observer option.
+type observedWriter struct {
+	io.Writer
+	fs   *sivaFS
+	path string
+}
+
+func (w *observedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.Writer.Write(p)
+	w.fs.observe(OpWrite, w.path, int64(n), start, err)
+	return n, err
+}
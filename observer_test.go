@@ -0,0 +1,136 @@
+package sivafs
+
+import (
+	"io/ioutil"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type testObserver struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (o *testObserver) Observe(e *Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, *e)
+}
+
+// sizes returns the sum of the sizes of the events of the operation and the
+// number of events with errors.
+func (o *testObserver) sizes(op Op) (int64, int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var size int64
+	var errors int
+	for _, e := range o.events {
+		if e.Op != op {
+			continue
+		}
+
+		size += e.Size
+		if e.Err != nil {
+			errors++
+		}
+	}
+
+	return size, errors
+}
+
+func (o *testObserver) count(op Op) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var count int
+	for _, e := range o.events {
+		if e.Op == op {
+			count++
+		}
+	}
+
+	return count
+}
+
+type ObserverSuite struct {
+	memSuite
+	observer *testObserver
+}
+
+var _ = Suite(&ObserverSuite{})
+
+func (s *ObserverSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+	s.observer = &testObserver{}
+}
+
+func (s *ObserverSuite) open(c *C, o SivaFSOptions) SivaFS {
+	o.Observer = s.observer
+	return s.memSuite.open(c, o)
+}
+
+func (s *ObserverSuite) TestEvents(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	writeFile(c, fs, "two", []byte("two"))
+
+	written, _ := s.observer.sizes(OpWrite)
+	c.Assert(written, Equals, int64(6))
+	c.Assert(s.observer.count(OpOpen), Equals, 1)
+
+	c.Assert(fs.Sync(), IsNil)
+	fi, err := s.mem.Stat("test.siva")
+	c.Assert(err, IsNil)
+
+	flushed, _ := s.observer.sizes(OpFlush)
+	c.Assert(flushed, Equals, fi.Size())
+	c.Assert(s.observer.count(OpClose), Equals, 1)
+
+	f, err := fs.Open("one")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "one")
+	c.Assert(f.Close(), IsNil)
+
+	read, errors := s.observer.sizes(OpRead)
+	c.Assert(read, Equals, int64(3))
+	c.Assert(errors, Equals, 0)
+
+	opened, _ := s.observer.sizes(OpOpen)
+	c.Assert(opened, Equals, fi.Size())
+	c.Assert(s.observer.count(OpOpen), Equals, 2)
+	c.Assert(s.observer.count(OpIndex) > 0, Equals, true)
+
+	for _, e := range s.observer.events {
+		c.Assert(e.Duration >= 0, Equals, true)
+	}
+}
+
+func (s *ObserverSuite) TestNothingWritten(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	_, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	c.Assert(s.observer.count(OpFlush), Equals, 0)
+	c.Assert(s.observer.count(OpClose), Equals, 1)
+}
+
+func (s *ObserverSuite) TestOpenError(c *C) {
+	fs := s.open(c, SivaFSOptions{ReadOnly: true})
+	_, err := fs.ReadDir("/")
+	c.Assert(err, NotNil)
+
+	_, errors := s.observer.sizes(OpOpen)
+	c.Assert(errors, Equals, 1)
+}
+
+func (s *ObserverSuite) TestOpString(c *C) {
+	c.Assert(OpOpen.String(), Equals, "open")
+	c.Assert(OpIndex.String(), Equals, "index")
+	c.Assert(Op(-1).String(), Equals, "unknown")
+}
@@ -0,0 +1,29 @@
+module gopkg.in/src-d/go-billy-siva.v4/otelobserver
+
+require (
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/metric v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/sdk/metric v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+	gopkg.in/src-d/go-billy-siva.v4 v4.0.0-00010101000000-000000000000
+	gopkg.in/src-d/go-billy.v4 v4.3.2
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/snappy v1.0.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/rogpeppe/go-internal v1.10.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	gopkg.in/src-d/go-siva.v1 v1.7.0 // indirect
+)
+
+replace gopkg.in/src-d/go-billy-siva.v4 => ../
+
+go 1.22
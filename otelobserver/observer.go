@@ -0,0 +1,143 @@
+// Package otelobserver exports the events of siva filesystems as OpenTelemetry
+// metrics and spans.
+package otelobserver
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/src-d/go-billy-siva.v4"
+)
+
+// ScopeName is the instrumentation scope of the meter and tracer used.
+const ScopeName = "gopkg.in/src-d/go-billy-siva.v4/otelobserver"
+
+const (
+	opKey   = attribute.Key("sivafs.op")
+	pathKey = attribute.Key("sivafs.path")
+	sizeKey = attribute.Key("sivafs.size")
+)
+
+// Observer is a sivafs.Observer recording the number of operations, errors
+// and bytes and the duration of the operations, with the operation as
+// attribute. Openings, closings and flushes of siva files are also recorded
+// as spans; reads, writes and index loads, being too frequent, are not.
+type Observer struct {
+	tracer     trace.Tracer
+	operations metric.Int64Counter
+	errors     metric.Int64Counter
+	bytes      metric.Int64Counter
+	duration   metric.Float64Histogram
+}
+
+var _ sivafs.Observer = &Observer{}
+
+// Options holds configuration options for the observer.
+type Options struct {
+	// MeterProvider provides the meter of the metrics, the global one is
+	// used if nil.
+	MeterProvider metric.MeterProvider
+	// TracerProvider provides the tracer of the spans, the global one is
+	// used if nil.
+	TracerProvider trace.TracerProvider
+}
+
+// New creates a new observer using the global providers.
+func New() (*Observer, error) {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates a new observer with the given options.
+func NewWithOptions(o Options) (*Observer, error) {
+	if o.MeterProvider == nil {
+		o.MeterProvider = otel.GetMeterProvider()
+	}
+
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+
+	meter := o.MeterProvider.Meter(ScopeName)
+	obs := &Observer{tracer: o.TracerProvider.Tracer(ScopeName)}
+
+	var err error
+	obs.operations, err = meter.Int64Counter("sivafs.operations",
+		metric.WithDescription("Number of operations done by siva filesystems."))
+	if err != nil {
+		return nil, err
+	}
+
+	obs.errors, err = meter.Int64Counter("sivafs.errors",
+		metric.WithDescription("Number of operations of siva filesystems that failed."))
+	if err != nil {
+		return nil, err
+	}
+
+	obs.bytes, err = meter.Int64Counter("sivafs.io",
+		metric.WithDescription("Number of bytes read, written and flushed by siva filesystems."),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	obs.duration, err = meter.Float64Histogram("sivafs.operation.duration",
+		metric.WithDescription("Duration of the operations of siva filesystems."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	return obs, nil
+}
+
+// Observe implements sivafs.Observer interface. Spans are children of the
+// span in the context of the event, if any.
+func (o *Observer) Observe(e *sivafs.Event) {
+	ctx := e.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	attrs := metric.WithAttributes(opKey.String(e.Op.String()))
+
+	o.operations.Add(ctx, 1, attrs)
+	o.duration.Record(ctx, e.Duration.Seconds(), attrs)
+	if e.Err != nil {
+		o.errors.Add(ctx, 1, attrs)
+	}
+
+	switch e.Op {
+	case sivafs.OpRead, sivafs.OpWrite:
+		if e.Err == nil {
+			o.bytes.Add(ctx, e.Size, attrs)
+		}
+	case sivafs.OpFlush:
+		if e.Err == nil {
+			o.bytes.Add(ctx, e.Size, attrs)
+		}
+
+		o.span(ctx, e)
+	case sivafs.OpOpen, sivafs.OpClose:
+		o.span(ctx, e)
+	}
+}
+
+// span records the event as a span ended when it is observed.
+func (o *Observer) span(ctx context.Context, e *sivafs.Event) {
+	end := time.Now()
+	_, span := o.tracer.Start(ctx, "sivafs."+e.Op.String(),
+		trace.WithTimestamp(end.Add(-e.Duration)),
+		trace.WithAttributes(pathKey.String(e.Path), sizeKey.Int64(e.Size)))
+
+	if e.Err != nil {
+		span.RecordError(e.Err)
+		span.SetStatus(codes.Error, e.Err.Error())
+	}
+
+	span.End(trace.WithTimestamp(end))
+}
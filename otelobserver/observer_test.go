@@ -0,0 +1,122 @@
+package otelobserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ObserverSuite struct {
+	reader   *sdkmetric.ManualReader
+	recorder *tracetest.SpanRecorder
+	observer *Observer
+}
+
+var _ = Suite(&ObserverSuite{})
+
+func (s *ObserverSuite) SetUpTest(c *C) {
+	s.reader = sdkmetric.NewManualReader()
+	s.recorder = tracetest.NewSpanRecorder()
+
+	var err error
+	s.observer, err = NewWithOptions(Options{
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(s.reader)),
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder)),
+	})
+	c.Assert(err, IsNil)
+}
+
+// sums returns the values of the counter with the given name by operation.
+func (s *ObserverSuite) sums(c *C, name string) map[string]int64 {
+	var rm metricdata.ResourceMetrics
+	c.Assert(s.reader.Collect(context.Background(), &rm), IsNil)
+
+	sums := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				op, _ := dp.Attributes.Value(opKey)
+				sums[op.AsString()] = dp.Value
+			}
+		}
+	}
+
+	return sums
+}
+
+func (s *ObserverSuite) TestObserve(c *C) {
+	fs, err := sivafs.NewFilesystemWithOptions(memfs.New(), "test.siva",
+		memfs.New(), sivafs.SivaFSOptions{Observer: s.observer})
+	c.Assert(err, IsNil)
+
+	f, err := fs.Create("one")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("one"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	operations := s.sums(c, "sivafs.operations")
+	c.Assert(operations["open"], Equals, int64(1))
+	c.Assert(operations["write"], Equals, int64(1))
+	c.Assert(operations["flush"], Equals, int64(1))
+	c.Assert(operations["close"], Equals, int64(1))
+	c.Assert(s.sums(c, "sivafs.io")["write"], Equals, int64(3))
+
+	var names []string
+	for _, span := range s.recorder.Ended() {
+		names = append(names, span.Name())
+		c.Assert(span.StartTime().After(span.EndTime()), Equals, false)
+	}
+	c.Assert(names, DeepEquals, []string{"sivafs.open", "sivafs.flush", "sivafs.close"})
+}
+
+func (s *ObserverSuite) TestParent(c *C) {
+	ctx, parent := s.observer.tracer.Start(context.Background(), "parent")
+	fs, err := sivafs.NewFilesystemWithOptions(memfs.New(), "test.siva",
+		memfs.New(), sivafs.SivaFSOptions{Observer: s.observer})
+	c.Assert(err, IsNil)
+
+	child := fs.(sivafs.SivaContext).WithContext(ctx)
+	_, err = child.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(child.Sync(), IsNil)
+	parent.End()
+
+	spans := s.recorder.Ended()
+	c.Assert(spans, HasLen, 3)
+	for _, span := range spans[:2] {
+		c.Assert(span.Parent().SpanID(), Equals, parent.SpanContext().SpanID())
+		c.Assert(span.SpanContext().TraceID(), Equals, parent.SpanContext().TraceID())
+	}
+}
+
+func (s *ObserverSuite) TestError(c *C) {
+	s.observer.Observe(&sivafs.Event{Op: sivafs.OpOpen, Err: errors.New("foo")})
+	s.observer.Observe(&sivafs.Event{Op: sivafs.OpRead, Size: 10, Err: errors.New("foo")})
+
+	c.Assert(s.sums(c, "sivafs.errors"), DeepEquals, map[string]int64{
+		"open": 1,
+		"read": 1,
+	})
+	c.Assert(s.sums(c, "sivafs.io"), HasLen, 0)
+
+	spans := s.recorder.Ended()
+	c.Assert(spans, HasLen, 1)
+	c.Assert(spans[0].Status().Code, Equals, codes.Error)
+}
@@ -0,0 +1,271 @@
+package sivafs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// PackOptions holds configuration options for Pack and Unpack.
+type PackOptions struct {
+	// Include holds glob patterns, as in path.Match, of the paths to copy.
+	// Paths are relative to the root and patterns without slashes are also
+	// matched against the file names. A pattern matching a directory matches
+	// all its contents. If it is empty all the paths are included.
+	Include []string
+	// Exclude holds glob patterns of the paths to skip, with the same rules
+	// as Include. They take precedence over Include patterns.
+	Exclude []string
+}
+
+// match returns true if name, a path relative to the root, must be copied.
+func (o *PackOptions) match(name string) (bool, error) {
+	if len(o.Include) > 0 {
+		ok, err := matchAny(o.Include, name)
+		if !ok || err != nil {
+			return false, err
+		}
+	}
+
+	ok, err := matchAny(o.Exclude, name)
+	return !ok, err
+}
+
+// matchAny returns true if any of the patterns matches name or one of its
+// parent directories.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		base := !strings.Contains(p, "/")
+		for n := name; n != "." && n != "/" && n != ""; n = path.Dir(n) {
+			target := n
+			if base {
+				target = path.Base(n)
+			}
+
+			ok, err := path.Match(p, target)
+			if ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Pack copies the directory tree under root in src to dst, keeping the mode
+// and modification time of the files. Symbolic links are stored as files
+// with os.ModeSymlink containing the link target. dst is synced once all the
+// files are written, so the whole tree is committed as a single index block.
+// If it fails dst is not synced and, if it implements SivaRollback, the
+// changes not synced yet are discarded.
+func Pack(src billy.Filesystem, root string, dst SivaFS) error {
+	return PackWithOptions(src, root, dst, PackOptions{})
+}
+
+// PackWithOptions packs a directory tree and accepts options. See Pack
+// documentation.
+func PackWithOptions(
+	src billy.Filesystem,
+	root string,
+	dst SivaFS,
+	o PackOptions,
+) error {
+	err := walk(src, root, func(name string, fi os.FileInfo) error {
+		rel := relativePath(root, name)
+		ok, err := o.match(rel)
+		if !ok || err != nil {
+			return err
+		}
+
+		return packFile(src, name, dst, rel, fi)
+	})
+
+	if err != nil {
+		if r, ok := dst.(SivaRollback); ok {
+			r.Rollback()
+		}
+
+		return err
+	}
+
+	return dst.Sync()
+}
+
+func packFile(
+	src billy.Filesystem,
+	name string,
+	dst SivaFS,
+	rel string,
+	fi os.FileInfo,
+) error {
+	h := &siva.Header{
+		Name:    rel,
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}
+
+	switch {
+	case fi.IsDir():
+		return dst.MkdirAll(rel, fi.Mode().Perm())
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := src.Readlink(name)
+		if err != nil {
+			return err
+		}
+
+		return copyToFile(dst, h, strings.NewReader(target))
+	case !fi.Mode().IsRegular():
+		return nil
+	}
+
+	f, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return copyToFile(dst, h, f)
+}
+
+// Unpack copies the files of src to the directory root of dst, keeping their
+// mode. Modification times are kept when dst implements billy.Change or it
+// is backed by the OS filesystem. Files with os.ModeSymlink are created as
+// symbolic links. Paths and link targets pointing outside of root, and paths
+// going through a link created by the unpack, are rejected with
+// ErrUnsafePath.
+func Unpack(src SivaFS, dst billy.Filesystem, root string) error {
+	return UnpackWithOptions(src, dst, root, PackOptions{})
+}
+
+// UnpackWithOptions unpacks a siva filesystem and accepts options. See Unpack
+// documentation.
+func UnpackWithOptions(
+	src SivaFS,
+	dst billy.Filesystem,
+	root string,
+	o PackOptions,
+) error {
+	links := make(map[string]bool)
+	return walk(src, "", func(name string, fi os.FileInfo) error {
+		rel, err := safePath("unpack", name)
+		if err != nil {
+			return err
+		}
+
+		ok, err := o.match(rel)
+		if !ok || err != nil {
+			return err
+		}
+
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if links[dir] {
+				return &os.PathError{Op: "unpack", Path: name, Err: ErrUnsafePath}
+			}
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			links[rel] = true
+		}
+
+		return unpackFile(src, name, dst, path.Join(root, rel), fi)
+	})
+}
+
+func unpackFile(
+	src SivaFS,
+	name string,
+	dst billy.Filesystem,
+	target string,
+	fi os.FileInfo,
+) error {
+	mode := fi.Mode().Perm()
+	if fi.IsDir() {
+		if mode == 0 {
+			mode = 0755
+		}
+
+		return dst.MkdirAll(target, mode)
+	}
+
+	f, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		link, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		if !safeLink(name, string(link)) {
+			return &os.PathError{Op: "unpack", Path: name, Err: ErrUnsafePath}
+		}
+
+		return dst.Symlink(string(link), target)
+	}
+
+	if mode == 0 {
+		mode = 0644
+	}
+
+	w, err := dst.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return chtimes(dst, target, fi.ModTime())
+}
+
+// safeLink returns true if the target of the link with the given name, both
+// relative to the root, stays inside of the root.
+func safeLink(name, target string) bool {
+	target = filepath.ToSlash(target)
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return false
+	}
+
+	resolved := path.Join(path.Dir(name), target)
+	return resolved != ".." && !strings.HasPrefix(resolved, "../")
+}
+
+// chtimes sets the modification time of the file if fs supports it.
+func chtimes(fs billy.Basic, name string, modTime time.Time) error {
+	if c, ok := fs.(billy.Change); ok {
+		return c.Chtimes(name, modTime, modTime)
+	}
+
+	if p, ok := osPath(fs, name); ok {
+		return os.Chtimes(p, modTime, modTime)
+	}
+
+	return nil
+}
+
+// relativePath returns name relative to root. name must be inside root.
+func relativePath(root, name string) string {
+	root = normalizePath(root)
+	name = normalizePath(name)
+	if root == "" {
+		return name
+	}
+
+	return strings.TrimPrefix(name, root+"/")
+}
@@ -0,0 +1,205 @@
+package sivafs
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+type PackSuite struct{}
+
+var _ = Suite(&PackSuite{})
+
+var packModTime = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func (s *PackSuite) source(c *C) billy.Filesystem {
+	src := osfs.New(c.MkDir())
+	for name, content := range map[string]string{
+		"root/one.txt":         "one",
+		"root/dir/two.txt":     "two",
+		"root/dir/three.log":   "three",
+		"root/.git/config":     "config",
+		"root/.git/refs/heads": "heads",
+		"outside.txt":          "outside",
+	} {
+		f, err := src.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+		c.Assert(err, IsNil)
+		_, err = f.Write([]byte(content))
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		c.Assert(chtimes(src, name, packModTime), IsNil)
+	}
+
+	c.Assert(src.Symlink("one.txt", "root/link"), IsNil)
+	return src
+}
+
+func listAll(c *C, fs billy.Filesystem) []string {
+	var names []string
+	err := walk(fs, "", func(name string, fi os.FileInfo) error {
+		if !fi.IsDir() {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	sort.Strings(names)
+	return names
+}
+
+func (s *PackSuite) TestPackUnpack(c *C) {
+	src := s.source(c)
+	mem := memfs.New()
+	dst, err := NewFilesystem(mem, "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	c.Assert(Pack(src, "root", dst), IsNil)
+
+	// a single index block is written
+	c.Assert(readBlocks(c, mem, "test.siva"), HasLen, 1)
+
+	c.Assert(listAll(c, dst), DeepEquals, []string{
+		".git/config",
+		".git/refs/heads",
+		"dir/three.log",
+		"dir/two.txt",
+		"link",
+		"one.txt",
+	})
+
+	fi, err := dst.Stat("dir/two.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+	c.Assert(fi.ModTime().Equal(packModTime), Equals, true)
+
+	fi, err = dst.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Equals, os.ModeSymlink)
+	testFileContent(c, dst, "link", "one.txt")
+
+	out := osfs.New(c.MkDir())
+	c.Assert(Unpack(dst, out, "unpacked"), IsNil)
+
+	fi, err = out.Stat("unpacked/dir/two.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+	c.Assert(fi.ModTime().Equal(packModTime), Equals, true)
+	testFileContent(c, out, "unpacked/dir/two.txt", "two")
+
+	target, err := out.Readlink("unpacked/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "one.txt")
+}
+
+func (s *PackSuite) TestPackOptions(c *C) {
+	src := s.source(c)
+	dst, err := NewFilesystem(memfs.New(), "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	err = PackWithOptions(src, "root", dst, PackOptions{
+		Include: []string{"dir", "*.txt", ".git"},
+		Exclude: []string{"*.log", ".git/refs"},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(listAll(c, dst), DeepEquals, []string{
+		".git/config",
+		"dir/two.txt",
+		"one.txt",
+	})
+
+	out := memfs.New()
+	err = UnpackWithOptions(dst, out, "", PackOptions{Exclude: []string{"dir"}})
+	c.Assert(err, IsNil)
+
+	c.Assert(listAll(c, out), DeepEquals, []string{
+		".git/config",
+		"one.txt",
+	})
+}
+
+func (s *PackSuite) TestUnpackUnsafe(c *C) {
+	fixture := fixtures[2]
+	src, err := fixture.FSOffset(c, true, 0)
+	c.Assert(err, IsNil)
+
+	err = Unpack(src.(SivaFS), memfs.New(), "")
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+}
+
+// failingFS fails opening the file with the given name.
+type failingFS struct {
+	billy.Filesystem
+	name string
+}
+
+func (fs *failingFS) Open(name string) (billy.File, error) {
+	if name == fs.name {
+		return nil, os.ErrPermission
+	}
+
+	return fs.Filesystem.Open(name)
+}
+
+func (s *PackSuite) TestPackRollback(c *C) {
+	mem := memfs.New()
+	dst, err := NewFilesystem(mem, "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+	writeFile(c, dst, "old.txt", []byte("old"))
+	c.Assert(dst.Sync(), IsNil)
+
+	src := &failingFS{Filesystem: s.source(c), name: "root/one.txt"}
+	c.Assert(Pack(src, "root", dst), Equals, os.ErrPermission)
+	c.Assert(dst.Sync(), IsNil)
+
+	c.Assert(readBlocks(c, mem, "test.siva"), HasLen, 1)
+	c.Assert(listAll(c, dst), DeepEquals, []string{"old.txt"})
+}
+
+// linkSource returns a siva filesystem with a link with the given target and
+// a file going through it.
+func linkSource(c *C, target string) SivaFS {
+	fs, err := NewFilesystem(memfs.New(), "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	f, err := fs.(SivaCreateHeader).CreateHeader(&siva.Header{
+		Name: "dir",
+		Mode: os.ModeSymlink | 0777,
+	})
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte(target))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	writeFile(c, fs, "dir/x", []byte("x"))
+	c.Assert(fs.Sync(), IsNil)
+	return fs
+}
+
+func (s *PackSuite) TestUnpackSymlinkSlip(c *C) {
+	for _, target := range []string{"../outside", "/tmp", "sub"} {
+		base := c.MkDir()
+		out := osfs.New(base)
+		c.Assert(out.MkdirAll("root/sub", 0755), IsNil)
+		c.Assert(out.MkdirAll("outside", 0755), IsNil)
+
+		err := Unpack(linkSource(c, target), out, "root")
+		c.Assert(err, NotNil, Commentf("%s", target))
+		c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+
+		_, err = out.Stat("outside/x")
+		c.Assert(os.IsNotExist(err), Equals, true)
+		_, err = out.Stat("root/sub/x")
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+}
@@ -0,0 +1,114 @@
+// Package promobserver exports the events of siva filesystems as Prometheus
+// metrics.
+package promobserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/src-d/go-billy-siva.v4"
+)
+
+// DefaultNamespace is the namespace of the metrics if none is given.
+const DefaultNamespace = "sivafs"
+
+// Observer is a sivafs.Observer counting operations, errors and bytes and
+// keeping a histogram of the duration of the operations, labeled by
+// operation. It is a prometheus.Collector, so it has to be registered.
+type Observer struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	bytes      *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+var _ sivafs.Observer = &Observer{}
+var _ prometheus.Collector = &Observer{}
+
+// Options holds configuration options for the observer.
+type Options struct {
+	// Namespace is prepended to the names of the metrics, DefaultNamespace
+	// is used if empty.
+	Namespace string
+	// ConstLabels are added to every metric, for example to tell apart the
+	// siva filesystems of a process.
+	ConstLabels prometheus.Labels
+	// Buckets are the buckets of the duration histogram, in seconds.
+	// prometheus.DefBuckets is used if empty.
+	Buckets []float64
+}
+
+// New creates a new observer with the default options.
+func New() *Observer {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates a new observer with the given options.
+func NewWithOptions(o Options) *Observer {
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+
+	if len(o.Buckets) == 0 {
+		o.Buckets = prometheus.DefBuckets
+	}
+
+	labels := []string{"op"}
+	return &Observer{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "operations_total",
+			Help:        "Number of operations done by siva filesystems.",
+			ConstLabels: o.ConstLabels,
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "errors_total",
+			Help:        "Number of operations of siva filesystems that failed.",
+			ConstLabels: o.ConstLabels,
+		}, labels),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Name:        "bytes_total",
+			Help:        "Number of bytes read, written and flushed by siva filesystems.",
+			ConstLabels: o.ConstLabels,
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Name:        "operation_duration_seconds",
+			Help:        "Duration of the operations of siva filesystems.",
+			ConstLabels: o.ConstLabels,
+			Buckets:     o.Buckets,
+		}, labels),
+	}
+}
+
+// Observe implements sivafs.Observer interface.
+func (o *Observer) Observe(e *sivafs.Event) {
+	op := e.Op.String()
+	o.operations.WithLabelValues(op).Inc()
+	o.duration.WithLabelValues(op).Observe(e.Duration.Seconds())
+	if e.Err != nil {
+		o.errors.WithLabelValues(op).Inc()
+		return
+	}
+
+	switch e.Op {
+	case sivafs.OpRead, sivafs.OpWrite, sivafs.OpFlush:
+		o.bytes.WithLabelValues(op).Add(float64(e.Size))
+	}
+}
+
+// Describe implements prometheus.Collector interface.
+func (o *Observer) Describe(ch chan<- *prometheus.Desc) {
+	o.operations.Describe(ch)
+	o.errors.Describe(ch)
+	o.bytes.Describe(ch)
+	o.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector interface.
+func (o *Observer) Collect(ch chan<- prometheus.Metric) {
+	o.operations.Collect(ch)
+	o.errors.Collect(ch)
+	o.bytes.Collect(ch)
+	o.duration.Collect(ch)
+}
@@ -0,0 +1,68 @@
+package promobserver
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ObserverSuite struct{}
+
+var _ = Suite(&ObserverSuite{})
+
+func (s *ObserverSuite) TestObserve(c *C) {
+	o := New()
+	registry := prometheus.NewRegistry()
+	c.Assert(registry.Register(o), IsNil)
+
+	fs, err := sivafs.NewFilesystemWithOptions(memfs.New(), "test.siva",
+		memfs.New(), sivafs.SivaFSOptions{Observer: o})
+	c.Assert(err, IsNil)
+
+	f, err := fs.Create("one")
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("one"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	c.Assert(testutil.ToFloat64(o.operations.WithLabelValues("write")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(o.bytes.WithLabelValues("write")), Equals, float64(3))
+	c.Assert(testutil.ToFloat64(o.operations.WithLabelValues("flush")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(o.operations.WithLabelValues("close")), Equals, float64(1))
+
+	o.Observe(&sivafs.Event{Op: sivafs.OpRead, Size: 10, Err: sivafs.ErrReadOnlyFile})
+	c.Assert(testutil.ToFloat64(o.errors.WithLabelValues("read")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(o.bytes.WithLabelValues("read")), Equals, float64(0))
+
+	families, err := registry.Gather()
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+
+	c.Assert(names, DeepEquals, []string{
+		"sivafs_bytes_total",
+		"sivafs_errors_total",
+		"sivafs_operation_duration_seconds",
+		"sivafs_operations_total",
+	})
+}
+
+func (s *ObserverSuite) TestOptions(c *C) {
+	o := NewWithOptions(Options{
+		Namespace:   "test",
+		ConstLabels: prometheus.Labels{"fs": "repos"},
+	})
+	o.Observe(&sivafs.Event{Op: sivafs.OpOpen})
+
+	c.Assert(testutil.CollectAndCount(o, "test_operations_total"), Equals, 1)
+}
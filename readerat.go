@@ -0,0 +1,69 @@
+package sivafs
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// sivaFile is the siva file opened by a filesystem.
+type sivaFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+}
+
+// sectionFile is a sivaFile backed by an io.SectionReader. Closing it
+// does nothing, the ReaderAt is owned by the caller.
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (f *sectionFile) Close() error {
+	return nil
+}
+
+// NewFilesystemFromReaderAt creates a read only filesystem backed by a siva
+// file read from r, which must hold size bytes. This allows reading siva
+// files already held in memory or in any other storage without copying them
+// to a billy filesystem first. offset is the index offset inside the siva
+// file. Set it to 0 to use the last index.
+func NewFilesystemFromReaderAt(
+	r io.ReaderAt,
+	size int64,
+	offset uint64,
+) (SivaFS, error) {
+	return NewFilesystemFromReaderAtWithOptions(r, size, SivaFSOptions{
+		Offset: offset,
+	})
+}
+
+// NewFilesystemFromReaderAtWithOptions creates a read only filesystem backed
+// by a siva file read from r and accepts options. The filesystem is always
+// read only, regardless of the ReadOnly option. See NewFilesystemFromReaderAt
+// documentation.
+func NewFilesystemFromReaderAtWithOptions(
+	r io.ReaderAt,
+	size int64,
+	o SivaFSOptions,
+) (SivaFS, error) {
+	o.ReadOnly = true
+
+	root := &sivaFS{
+		sivaState: &sivaState{
+			readerAt: r,
+			size:     size,
+			options:  o,
+		},
+		ctx: context.Background(),
+	}
+
+	return newReadOnly(root), nil
+}
+
+// NewFilesystemFromBytes creates a read only filesystem backed by the siva
+// file contained in b, for example one embedded with go:embed. offset is the
+// index offset inside the siva file. Set it to 0 to use the last index.
+func NewFilesystemFromBytes(b []byte, offset uint64) (SivaFS, error) {
+	return NewFilesystemFromReaderAt(bytes.NewReader(b), int64(len(b)), offset)
+}
@@ -0,0 +1,88 @@
+package sivafs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+type ReaderAtFilesystemSuite struct{}
+
+var _ = Suite(&ReaderAtFilesystemSuite{})
+
+func (s *ReaderAtFilesystemSuite) TestReadFs(c *C) {
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(fixture.Path())
+		c.Assert(err, IsNil)
+
+		f, err := os.Open(fixture.Path())
+		c.Assert(err, IsNil)
+
+		fromReaderAt, err := NewFilesystemFromReaderAtWithOptions(
+			f, int64(len(data)), SivaFSOptions{UnsafePaths: fixture.unsafe},
+		)
+		c.Assert(err, IsNil)
+
+		fromMemory, err := NewFilesystemFromReaderAtWithOptions(
+			bytesReaderAt(data), int64(len(data)),
+			SivaFSOptions{UnsafePaths: fixture.unsafe},
+		)
+		c.Assert(err, IsNil)
+
+		for _, fs := range []billy.Filesystem{fromReaderAt, fromMemory} {
+			testOpenAndRead(c, fixture, fs)
+			testReadDir(c, fixture, fs)
+			testStat(c, fixture, fs)
+			testNested(c, fixture, fs)
+		}
+
+		c.Assert(f.Close(), IsNil)
+	}
+}
+
+func (s *ReaderAtFilesystemSuite) TestBytes(c *C) {
+	data, err := ioutil.ReadFile(fixtures[0].Path())
+	c.Assert(err, IsNil)
+
+	fs, err := NewFilesystemFromBytes(data, 0)
+	c.Assert(err, IsNil)
+
+	testOpenAndRead(c, fixtures[0], fs)
+	c.Assert(fs.Sync(), IsNil)
+	testReadDir(c, fixtures[0], fs)
+}
+
+func (s *ReaderAtFilesystemSuite) TestReadOnly(c *C) {
+	data, err := ioutil.ReadFile(fixtures[0].Path())
+	c.Assert(err, IsNil)
+
+	fs, err := NewFilesystemFromBytes(data, 0)
+	c.Assert(err, IsNil)
+
+	_, err = fs.Create("new.txt")
+	c.Assert(err, Equals, ErrReadOnlyFilesystem)
+
+	err = fs.Remove("gopher.txt")
+	c.Assert(err, Equals, ErrReadOnlyFilesystem)
+
+	caps := billy.Capabilities(fs)
+	c.Assert(caps, Equals, billy.ReadCapability|billy.SeekCapability)
+}
+
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
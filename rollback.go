@@ -0,0 +1,65 @@
+package sivafs
+
+import (
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// SivaRollback is implemented by siva filesystems able to discard the changes
+// not synced yet.
+type SivaRollback interface {
+	// Rollback discards the files written and removed since the filesystem
+	// was last synced, truncating the siva file to its last index block.
+	// Files opened for writing must be closed before.
+	Rollback() error
+}
+
+// Rollback implements SivaRollback interface.
+func (fs *sivaFS) Rollback() error {
+	if fs.fileWriteModeOpen {
+		return ErrFileWriteModeAlreadyOpen
+	}
+
+	if fs.getReadWriter() == nil {
+		return nil
+	}
+
+	f, ok := fs.f.(billy.File)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	if err := f.Truncate(int64(fs.end)); err != nil {
+		return err
+	}
+
+	fs.setReadWriter(nil)
+	return fs.release()
+}
+
+// Rollback implements SivaRollback interface. Only the top layer can have
+// changes to discard.
+func (u *union) Rollback() error {
+	if !u.writable {
+		return nil
+	}
+
+	top, ok := u.top().(SivaRollback)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return top.Rollback()
+}
+
+// Rollback implements SivaRollback interface. The changes of every shard are
+// discarded.
+func (s *sharded) Rollback() error {
+	var firstErr error
+	for _, shard := range s.shards {
+		if err := shard.Rollback(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
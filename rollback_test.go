@@ -0,0 +1,83 @@
+package sivafs
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+type RollbackSuite struct{}
+
+var _ = Suite(&RollbackSuite{})
+
+func (s *RollbackSuite) TestRollback(c *C) {
+	for _, o := range []SivaFSOptions{{}, {MMap: true}} {
+		underlying := osfs.New(c.MkDir())
+		fs, err := NewFilesystemWithOptions(underlying, "test.siva", memfs.New(), o)
+		c.Assert(err, IsNil)
+
+		writeFile(c, fs, "one", []byte("one"))
+		writeFile(c, fs, "two", []byte("two"))
+		c.Assert(fs.Sync(), IsNil)
+		synced, err := underlying.Stat("test.siva")
+		c.Assert(err, IsNil)
+
+		writeFile(c, fs, "one", []byte("changed"))
+		writeFile(c, fs, "three", []byte("three"))
+		c.Assert(fs.Remove("two"), IsNil)
+		c.Assert(fs.(SivaRollback).Rollback(), IsNil)
+
+		fi, err := underlying.Stat("test.siva")
+		c.Assert(err, IsNil)
+		c.Assert(fi.Size(), Equals, synced.Size())
+
+		testFileContent(c, fs, "one", "one")
+		testFileContent(c, fs, "two", "two")
+		_, err = fs.Stat("three")
+		c.Assert(err, NotNil)
+
+		writeFile(c, fs, "four", []byte("four"))
+		c.Assert(fs.Sync(), IsNil)
+		c.Assert(readBlocks(c, underlying, "test.siva"), HasLen, 2)
+		testFileContent(c, fs, "four", "four")
+	}
+}
+
+func (s *RollbackSuite) TestOpenFile(c *C) {
+	fs := newTestFilesystem(c)
+	f, err := fs.Create("one")
+	c.Assert(err, IsNil)
+	c.Assert(fs.(SivaRollback).Rollback(), Equals, ErrFileWriteModeAlreadyOpen)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(fs.(SivaRollback).Rollback(), IsNil)
+
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *RollbackSuite) TestUnion(c *C) {
+	mem := memfs.New()
+	var layers []SivaFS
+	for _, name := range []string{"base.siva", "top.siva"} {
+		fs, err := NewFilesystem(mem, name, memfs.New())
+		c.Assert(err, IsNil)
+		writeFile(c, fs, name, []byte(name))
+		c.Assert(fs.Sync(), IsNil)
+		layers = append(layers, fs)
+	}
+
+	u, err := NewUnionWithOptions(layers, UnionOptions{Writable: true})
+	c.Assert(err, IsNil)
+	c.Assert(u.Remove("base.siva"), IsNil)
+	writeFile(c, u, "new", []byte("new"))
+	c.Assert(u.(SivaRollback).Rollback(), IsNil)
+
+	testFileContent(c, u, "base.siva", "base.siva")
+	_, err = u.Stat("new")
+	c.Assert(err, NotNil)
+
+	files, err := u.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+}
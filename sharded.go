@@ -0,0 +1,537 @@
+package sivafs
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrNoShards      = errors.New("sharded filesystem needs at least one shard")
+	ErrInvalidShard  = errors.New("shard function returned an invalid shard")
+	ErrShardedOffset = errors.New("offset can not be used in sharded filesystems")
+)
+
+// ShardFunc returns the shard, from 0 to shards-1, storing the given path.
+// The path is relative to the root and uses slashes.
+type ShardFunc func(path string, shards int) int
+
+// HashShard spreads paths evenly between the shards using the FNV-1a hash of
+// the path.
+func HashShard(path string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// PrefixShard returns a ShardFunc storing the paths inside prefixes[i] in
+// shard i. Paths not inside any of the prefixes are stored in the last
+// shard.
+func PrefixShard(prefixes ...string) ShardFunc {
+	normalized := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		normalized[i] = normalizePath(p)
+	}
+
+	return func(path string, shards int) int {
+		for i, p := range normalized {
+			if path == p || strings.HasPrefix(path, addTrailingSlash(p)) {
+				return i
+			}
+		}
+
+		return shards - 1
+	}
+}
+
+// ShardedOptions holds configuration options for sharded filesystems.
+type ShardedOptions struct {
+	// SivaFSOptions are used to open every shard. Offset is not supported,
+	// but Snapshot opens every shard at its own offset.
+	SivaFSOptions
+	// Shard selects the shard of each path. HashShard is used by default.
+	Shard ShardFunc
+}
+
+// NewSharded creates a filesystem spreading its files between several siva
+// files, the shards, stored in fs with the given paths. Each file is stored
+// in the shard selected by HashShard. Reading directories merges the files of
+// every shard, and Sync syncs all of them. Temporary files are stored in
+// tmpFs, mounted as /tmp.
+//
+// The shard of a path depends on the number of shards, so the same paths must
+// be used every time the filesystem is opened.
+func NewSharded(fs billy.Filesystem, paths []string, tmpFs billy.Filesystem) (SivaFS, error) {
+	return NewShardedWithOptions(fs, paths, tmpFs, ShardedOptions{})
+}
+
+// NewShardedWithOptions creates a sharded filesystem and accepts options. See
+// NewSharded documentation.
+func NewShardedWithOptions(
+	fs billy.Filesystem,
+	paths []string,
+	tmpFs billy.Filesystem,
+	o ShardedOptions,
+) (SivaFS, error) {
+	if len(paths) == 0 {
+		return nil, ErrNoShards
+	}
+
+	if o.Offset != 0 {
+		return nil, ErrShardedOffset
+	}
+
+	if !o.ReadOnly && o.Snapshot != "" {
+		return nil, ErrOffsetReadWrite
+	}
+
+	if o.Shard == nil {
+		o.Shard = HashShard
+	}
+
+	s := &sharded{
+		shard:   o.Shard,
+		options: o.SivaFSOptions,
+		ctx:     context.Background(),
+	}
+
+	for _, p := range paths {
+		shard := newSivaFS(fs, p, o.SivaFSOptions)
+		shard.tmp = tmpFs
+		s.shards = append(s.shards, shard)
+	}
+
+	if o.ReadOnly {
+		return newReadOnly(s), nil
+	}
+
+	return newTemp(s, tmpFs), nil
+}
+
+type sharded struct {
+	shards  []*sivaFS
+	shard   ShardFunc
+	options SivaFSOptions
+	ctx     context.Context
+
+	// mu guards the merged view, kept until the generation of the shards
+	// changes.
+	mu      sync.Mutex
+	view    *indexView
+	viewGen uint64
+}
+
+// route returns the shard storing the path.
+func (s *sharded) route(path string) (*sivaFS, error) {
+	path = normalizePath(path)
+	if !s.options.UnsafePaths {
+		path = siva.ToSafePath(path)
+	}
+
+	i := s.shard(path, len(s.shards))
+	if i < 0 || i >= len(s.shards) {
+		return nil, ErrInvalidShard
+	}
+
+	return s.shards[i], nil
+}
+
+// merge returns a view of the files of every shard. The view is built again
+// only when a shard changes.
+func (s *sharded) merge() (*indexView, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gen := s.generation()
+	if s.view != nil && s.viewGen == gen {
+		return s.view, nil
+	}
+
+	v, err := s.mergeShards()
+	if err != nil {
+		return nil, err
+	}
+
+	s.view, s.viewGen = v, gen
+	return v, nil
+}
+
+func (s *sharded) mergeShards() (*indexView, error) {
+	v := newIndexView(s.ctx)
+	for _, shard := range s.shards {
+		index, err := shard.liveIndex()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range index {
+			v.add(e, shard)
+		}
+	}
+
+	v.sort()
+	return v, nil
+}
+
+func (s *sharded) Create(path string) (billy.File, error) {
+	return s.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *sharded) Open(path string) (billy.File, error) {
+	return s.OpenFile(path, os.O_RDONLY, 0)
+}
+
+func (s *sharded) OpenFile(path string, flag int, mode os.FileMode) (billy.File, error) {
+	shard, err := s.route(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.OpenFile(path, flag, mode)
+}
+
+func (s *sharded) Stat(path string) (os.FileInfo, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.stat(normalizePath(path))
+}
+
+func (s *sharded) ReadDir(path string) ([]os.FileInfo, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.readDir(normalizePath(path))
+}
+
+func (s *sharded) MkdirAll(path string, perm os.FileMode) error {
+	if s.options.ReadOnly {
+		return ErrReadOnlyFilesystem
+	}
+
+	v, err := s.merge()
+	if err != nil {
+		return err
+	}
+
+	return mkdirAll(v, normalizePath(path))
+}
+
+func (s *sharded) Remove(path string) error {
+	if s.options.ReadOnly {
+		return ErrReadOnlyFilesystem
+	}
+
+	v, err := s.merge()
+	if err != nil {
+		return err
+	}
+
+	path = normalizePath(path)
+	if err := checkRemove(v, path); err != nil {
+		return err
+	}
+
+	return v.owner(path).Remove(path)
+}
+
+func (s *sharded) Rename(from, to string) error {
+	return billy.ErrNotSupported
+}
+
+// Join joins the specified elements using the filesystem separator.
+func (s *sharded) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Sync syncs every shard.
+func (s *sharded) Sync() error {
+	var firstErr error
+	for _, shard := range s.shards {
+		if err := shard.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Bytes implements SivaBytes interface.
+func (s *sharded) Bytes(path string) ([]byte, io.Closer, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v.bytes(normalizePath(path))
+}
+
+// Hash implements SivaHash interface.
+func (s *sharded) Hash(path string) (crypto.Hash, []byte, error) {
+	v, err := s.merge()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return v.hash(normalizePath(path))
+}
+
+// FindByHash implements SivaHash interface.
+func (s *sharded) FindByHash(sum []byte) ([]string, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.findByHash(sum)
+}
+
+// CreateWithMeta implements SivaMeta interface.
+func (s *sharded) CreateWithMeta(path string, meta map[string]string) (billy.File, error) {
+	shard, err := s.route(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.CreateWithMeta(path, meta)
+}
+
+// GetMeta implements SivaMeta interface.
+func (s *sharded) GetMeta(path, key string) (string, error) {
+	shard, err := s.route(path)
+	if err != nil {
+		return "", err
+	}
+
+	return shard.GetMeta(path, key)
+}
+
+// SetMeta implements SivaMeta interface.
+func (s *sharded) SetMeta(path, key, value string) error {
+	shard, err := s.route(path)
+	if err != nil {
+		return err
+	}
+
+	return shard.SetMeta(path, key, value)
+}
+
+// RemoveMeta implements SivaMeta interface.
+func (s *sharded) RemoveMeta(path, key string) error {
+	shard, err := s.route(path)
+	if err != nil {
+		return err
+	}
+
+	return shard.RemoveMeta(path, key)
+}
+
+// ListMeta implements SivaMeta interface.
+func (s *sharded) ListMeta(path string) (map[string]string, error) {
+	shard, err := s.route(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.ListMeta(path)
+}
+
+// Snapshot implements SivaSnapshot interface. The snapshot is taken in every
+// shard.
+func (s *sharded) Snapshot(name, message string) error {
+	for _, shard := range s.shards {
+		if err := shard.Snapshot(name, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Snapshots implements SivaSnapshot interface. Only the snapshots taken in
+// every shard are returned, with the time of the newest one and zero offsets,
+// as each shard has its own. They can be opened with the Snapshot option.
+func (s *sharded) Snapshots() ([]Snapshot, error) {
+	var merged []Snapshot
+	for i, shard := range s.shards {
+		snapshots, err := shard.Snapshots()
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			merged = snapshots
+			continue
+		}
+
+		merged = intersectSnapshots(merged, snapshots)
+	}
+
+	for i := range merged {
+		merged[i].Offset = 0
+	}
+
+	return merged, nil
+}
+
+// intersectSnapshots returns the snapshots of a also taken in b, matching the
+// nth snapshot with a name in a with the nth one with that name in b.
+func intersectSnapshots(a, b []Snapshot) []Snapshot {
+	taken := make(map[string][]Snapshot)
+	for _, snapshot := range b {
+		taken[snapshot.Name] = append(taken[snapshot.Name], snapshot)
+	}
+
+	var result []Snapshot
+	for _, snapshot := range a {
+		other := taken[snapshot.Name]
+		if len(other) == 0 {
+			continue
+		}
+
+		if other[0].Time.After(snapshot.Time) {
+			snapshot.Time = other[0].Time
+		}
+
+		taken[snapshot.Name] = other[1:]
+		result = append(result, snapshot)
+	}
+
+	return result
+}
+
+// Stats implements SivaStats interface. The statistics of the shards are
+// added up.
+func (s *sharded) Stats() (*Stats, error) {
+	stats := &Stats{}
+	for _, shard := range s.shards {
+		shardStats, err := shard.Stats()
+		if err != nil {
+			return nil, err
+		}
+
+		stats.add(shardStats)
+	}
+
+	return stats, nil
+}
+
+// Compact implements SivaCompact interface. Every shard is compacted.
+func (s *sharded) Compact() error {
+	var firstErr error
+	for _, shard := range s.shards {
+		if err := shard.Compact(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (s *sharded) compact(keepTombstones bool) error {
+	var firstErr error
+	for _, shard := range s.shards {
+		if err := shard.compact(keepTombstones); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// CreateHeader implements SivaCreateHeader interface.
+func (s *sharded) CreateHeader(h *siva.Header) (billy.File, error) {
+	shard, err := s.route(h.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.CreateHeader(h)
+}
+
+func (s *sharded) liveIndex() (siva.OrderedIndex, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.index, nil
+}
+
+func (s *sharded) generation() uint64 {
+	var gen uint64
+	for _, shard := range s.shards {
+		gen += shard.generation()
+	}
+
+	return gen
+}
+
+func (s *sharded) deletedPaths() (map[string]bool, error) {
+	deleted := make(map[string]bool)
+	for _, shard := range s.shards {
+		d, err := shard.deletedPaths()
+		if err != nil {
+			return nil, err
+		}
+
+		for name := range d {
+			deleted[name] = true
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *sharded) writeTombstone(path string) error {
+	shard, err := s.route(path)
+	if err != nil {
+		return err
+	}
+
+	return shard.writeTombstone(path)
+}
+
+func (s *sharded) copyFile(src SivaFS, e *siva.IndexEntry) error {
+	shard, err := s.route(e.Name)
+	if err != nil {
+		return err
+	}
+
+	return shard.copyFile(src, e)
+}
+
+func (s *sharded) openRaw(path string) (*siva.IndexEntry, io.Reader, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v.openRaw(normalizePath(path))
+}
+
+func (s *sharded) fileInfo(e *siva.IndexEntry) (os.FileInfo, error) {
+	v, err := s.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.fileInfo(e)
+}
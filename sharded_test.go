@@ -0,0 +1,179 @@
+package sivafs
+
+import (
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type ShardedSuite struct {
+	memSuite
+	paths []string
+}
+
+var _ = Suite(&ShardedSuite{})
+
+func (s *ShardedSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+	s.paths = []string{"0.siva", "1.siva", "2.siva"}
+}
+
+func (s *ShardedSuite) sharded(c *C, o ShardedOptions) SivaFS {
+	fs, err := NewShardedWithOptions(s.mem, s.paths, memfs.New(), o)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *ShardedSuite) TestHashShard(c *C) {
+	fs := s.sharded(c, ShardedOptions{})
+
+	var files []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		files = append(files, name+".txt")
+		writeFile(c, fs, "dir/"+name+".txt", []byte(name))
+	}
+	c.Assert(fs.Sync(), IsNil)
+
+	for _, name := range files {
+		testFileContent(c, fs, "dir/"+name, name[:1])
+
+		shard := s.paths[HashShard("dir/"+name, len(s.paths))]
+		single, err := NewFilesystemReadOnly(s.mem, shard, 0)
+		c.Assert(err, IsNil)
+		testFileContent(c, single, "dir/"+name, name[:1])
+	}
+
+	dirs, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(dirs), DeepEquals, []string{"dir"})
+
+	list, err := fs.ReadDir("dir")
+	c.Assert(err, IsNil)
+	c.Assert(names(list), DeepEquals, files)
+
+	fi, err := fs.Stat("dir")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = fs.Stat("dir/a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(1))
+}
+
+func (s *ShardedSuite) TestPrefixShard(c *C) {
+	fs := s.sharded(c, ShardedOptions{
+		Shard: PrefixShard("objects", "/refs"),
+	})
+
+	writeFile(c, fs, "objects/aa/bb", []byte("object"))
+	writeFile(c, fs, "refs/heads/master", []byte("ref"))
+	writeFile(c, fs, "config", []byte("config"))
+	writeFile(c, fs, "objectsfile", []byte("other"))
+	c.Assert(fs.Sync(), IsNil)
+
+	expected := map[string][]string{
+		"0.siva": {"objects"},
+		"1.siva": {"refs"},
+		"2.siva": {"config", "objectsfile"},
+	}
+
+	for shard, files := range expected {
+		single, err := NewFilesystemReadOnly(s.mem, shard, 0)
+		c.Assert(err, IsNil)
+
+		list, err := single.ReadDir("/")
+		c.Assert(err, IsNil)
+		c.Assert(names(list), DeepEquals, files)
+	}
+
+	list, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(list), DeepEquals, []string{
+		"objects", "refs", "config", "objectsfile",
+	})
+}
+
+func (s *ShardedSuite) TestWriteInSeveralShards(c *C) {
+	fs := s.sharded(c, ShardedOptions{
+		Shard: PrefixShard("a", "b"),
+	})
+
+	fa, err := fs.Create("a/file")
+	c.Assert(err, IsNil)
+	fb, err := fs.Create("b/file")
+	c.Assert(err, IsNil)
+
+	_, err = fa.Write([]byte("a"))
+	c.Assert(err, IsNil)
+	_, err = fb.Write([]byte("b"))
+	c.Assert(err, IsNil)
+	c.Assert(fa.Close(), IsNil)
+	c.Assert(fb.Close(), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	testFileContent(c, fs, "a/file", "a")
+	testFileContent(c, fs, "b/file", "b")
+}
+
+func (s *ShardedSuite) TestRemove(c *C) {
+	fs := s.sharded(c, ShardedOptions{})
+
+	writeFile(c, fs, "dir/a", []byte("a"))
+	writeFile(c, fs, "dir/b", []byte("b"))
+	c.Assert(fs.Sync(), IsNil)
+
+	err := fs.Remove("dir")
+	c.Assert(os.IsExist(err), Equals, true)
+
+	c.Assert(fs.Remove("dir/a"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	_, err = fs.Stat("dir/a")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	testFileContent(c, fs, "dir/b", "b")
+
+	c.Assert(fs.Remove("dir/a"), Equals, os.ErrNotExist)
+}
+
+func (s *ShardedSuite) TestReadOnly(c *C) {
+	fs := s.sharded(c, ShardedOptions{})
+	writeFile(c, fs, "a", []byte("a"))
+	c.Assert(fs.Sync(), IsNil)
+
+	ro := s.sharded(c, ShardedOptions{
+		SivaFSOptions: SivaFSOptions{ReadOnly: true},
+	})
+
+	testFileContent(c, ro, "a", "a")
+
+	_, err := ro.Create("b")
+	c.Assert(err, Equals, ErrReadOnlyFilesystem)
+	c.Assert(ro.Remove("a"), Equals, ErrReadOnlyFilesystem)
+	c.Assert(ro.MkdirAll("dir", 0755), Equals, ErrReadOnlyFilesystem)
+}
+
+func (s *ShardedSuite) TestTempFile(c *C) {
+	fs := s.sharded(c, ShardedOptions{})
+
+	f, err := fs.TempFile("", "test")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *ShardedSuite) TestInvalidOptions(c *C) {
+	_, err := NewSharded(s.mem, nil, memfs.New())
+	c.Assert(err, Equals, ErrNoShards)
+
+	_, err = NewShardedWithOptions(s.mem, s.paths, nil, ShardedOptions{
+		SivaFSOptions: SivaFSOptions{ReadOnly: true, Offset: 10},
+	})
+	c.Assert(err, Equals, ErrShardedOffset)
+
+	fs := s.sharded(c, ShardedOptions{
+		Shard: func(string, int) int { return 3 },
+	})
+
+	_, err = fs.Create("a")
+	c.Assert(err, Equals, ErrInvalidShard)
+}
@@ -0,0 +1,455 @@
+package sivafs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var ErrInvalidSignatureEntry = errors.New("invalid signature entry")
+
+// SignScope tells which blocks of the siva file are covered by the signature
+// written on every Sync, see the SigningKey option.
+type SignScope int
+
+const (
+	// SignBlock signs the index block written by the Sync, bound to its
+	// position and to the block before it.
+	SignBlock SignScope = iota
+	// SignArchive signs every block from the start of the siva file, so its
+	// last signature vouches for all of them. Every Sync reads the whole
+	// siva file.
+	SignArchive
+)
+
+// SignatureStatus is the result of verifying the signature of an index
+// block.
+type SignatureStatus int
+
+const (
+	// SignatureUnsigned tells that the block is not signed.
+	SignatureUnsigned SignatureStatus = iota
+	// SignatureValid tells that the block is signed by a trusted key.
+	SignatureValid
+	// SignatureUntrusted tells that the block is signed, but not by the key
+	// used to verify it.
+	SignatureUntrusted
+	// SignatureInvalid tells that the block does not match its signature,
+	// it was modified after being signed.
+	SignatureInvalid
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureUnsigned:
+		return "unsigned"
+	case SignatureValid:
+		return "valid"
+	case SignatureUntrusted:
+		return "untrusted"
+	case SignatureInvalid:
+		return "invalid"
+	default:
+		return fmt.Sprintf("SignatureStatus(%d)", int(s))
+	}
+}
+
+// BlockSignature is the signature status of an index block.
+type BlockSignature struct {
+	// Offset is the offset where the block ends, used to open the siva file
+	// as it was when the block was written.
+	Offset uint64
+	// Signer is the public key that signed the block, nil if unsigned.
+	Signer ed25519.PublicKey
+	Status SignatureStatus
+}
+
+// SignatureError is returned when opening a siva file with a block not
+// signed by the key of the VerifyKey option.
+type SignatureError struct {
+	BlockSignature
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("block ending at offset %d: %s signature", e.Offset, e.Status)
+}
+
+// signaturePath is the name of the entry holding a signature. Signatures are
+// written in blocks of their own following the blocks they cover.
+const signaturePath = reservedDir + "/signature"
+
+const (
+	signatureVersion = 2
+	signatureSize    = 2 + ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+// signatureContext is written at the start of the signed digest.
+var signatureContext = []byte("sivafs signature\x00")
+
+// signature is stored in signature entries:
+//
+//	version (1 byte) | scope (1 byte) | public key (32 bytes) |
+//	signature (64 bytes)
+type signature struct {
+	Scope     SignScope
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+func (s *signature) marshal() []byte {
+	buf := make([]byte, 0, signatureSize)
+	buf = append(buf, signatureVersion, byte(s.Scope))
+	buf = append(buf, s.PublicKey...)
+	return append(buf, s.Signature...)
+}
+
+func readSignature(r io.ReaderAt, b *indexBlock) (*signature, error) {
+	e := b.Entries[0]
+	if e.Size != signatureSize {
+		return nil, ErrInvalidSignatureEntry
+	}
+
+	buf := make([]byte, signatureSize)
+	if _, err := r.ReadAt(buf, int64(b.Offset(e))); err != nil {
+		return nil, err
+	}
+
+	if buf[0] != signatureVersion || SignScope(buf[1]) > SignArchive {
+		return nil, ErrInvalidSignatureEntry
+	}
+
+	key := 2 + ed25519.PublicKeySize
+	return &signature{
+		Scope:     SignScope(buf[1]),
+		PublicKey: ed25519.PublicKey(buf[2:key]),
+		Signature: buf[key:],
+	}, nil
+}
+
+// isSignatureBlock returns true if the block holds a signature.
+func isSignatureBlock(b *indexBlock) bool {
+	return len(b.Entries) == 1 && b.Entries[0].Name == signaturePath
+}
+
+// covered returns the blocks covered by a signature with the given scope
+// stored in blocks[i], and the block preceding them, if any.
+func covered(blocks []*indexBlock, i int, scope SignScope) (cov []*indexBlock, prev *indexBlock) {
+	if scope == SignArchive {
+		return blocks[:i], nil
+	}
+
+	if i == 0 {
+		return nil, nil
+	}
+
+	if i > 1 {
+		prev = blocks[i-2]
+	}
+
+	return blocks[i-1 : i], prev
+}
+
+// signedDigest returns the digest signed for the blocks: their position and
+// index, the SHA-256 of the contents of every entry, taken from sums if not
+// nil, and the position and index of the block preceding them, so a signed
+// block cannot be replayed elsewhere in the siva file.
+func signedDigest(
+	r io.ReaderAt,
+	blocks []*indexBlock,
+	prev *indexBlock,
+	scope SignScope,
+	sums *contentSums,
+) ([]byte, error) {
+	h := sha256.New()
+	h.Write(signatureContext)
+	h.Write([]byte{byte(scope)})
+	if prev != nil {
+		if err := writeIndexDigest(h, r, prev); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range blocks {
+		if err := writeBlockDigest(h, r, b, sums); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.Sum(nil), nil
+}
+
+// writeIndexDigest writes to h the offsets where the block starts and ends
+// and its index.
+func writeIndexDigest(h hash.Hash, r io.ReaderAt, b *indexBlock) error {
+	var pos [16]byte
+	binary.BigEndian.PutUint64(pos[:8], b.Start)
+	binary.BigEndian.PutUint64(pos[8:], b.End)
+	h.Write(pos[:])
+
+	size := int64(b.Footer.IndexSize) + indexFooterSize
+	_, err := io.Copy(h, io.NewSectionReader(r, int64(b.End)-size, size))
+	return err
+}
+
+func writeBlockDigest(h hash.Hash, r io.ReaderAt, b *indexBlock, sums *contentSums) error {
+	if err := writeIndexDigest(h, r, b); err != nil {
+		return err
+	}
+
+	for _, e := range b.Entries {
+		sum, err := sums.sum(r, b.Offset(e), e)
+		if err != nil {
+			return err
+		}
+
+		h.Write(sum)
+	}
+
+	return nil
+}
+
+// contentSums holds the SHA-256 of the contents of the entries of a siva
+// file, so they are hashed once by the signatures made and verified while
+// it is used. The nil value hashes them every time.
+type contentSums struct {
+	mu   sync.Mutex
+	sums map[contentKey][]byte
+}
+
+type contentKey struct {
+	offset uint64
+	size   uint64
+	crc32  uint32
+}
+
+// sum returns the SHA-256 of the contents of the entry, stored at offset.
+func (s *contentSums) sum(r io.ReaderAt, offset uint64, e *siva.IndexEntry) ([]byte, error) {
+	key := contentKey{offset: offset, size: e.Size, crc32: e.CRC32}
+	if s != nil {
+		s.mu.Lock()
+		sum, ok := s.sums[key]
+		s.mu.Unlock()
+		if ok {
+			return sum, nil
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, int64(offset), int64(e.Size))); err != nil {
+		return nil, err
+	}
+
+	sum := h.Sum(nil)
+	if s != nil {
+		s.mu.Lock()
+		if s.sums == nil {
+			s.sums = make(map[contentKey][]byte)
+		}
+
+		s.sums[key] = sum
+		s.mu.Unlock()
+	}
+
+	return sum, nil
+}
+
+// reset forgets the sums, once the siva file is rewritten.
+func (s *contentSums) reset() {
+	s.mu.Lock()
+	s.sums = nil
+	s.mu.Unlock()
+}
+
+// signWritten signs the block written by the last Sync, if any.
+func (fs *sivaFS) signWritten() error {
+	end, err := fs.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if uint64(end) == fs.end {
+		return nil
+	}
+
+	w, ok := fs.f.(io.Writer)
+	if !ok {
+		return ErrReadOnlyFilesystem
+	}
+
+	return fs.sign(w, fs.f, uint64(end), &fs.sums)
+}
+
+// sign writes to w a signature of the blocks of r up to end, made with the
+// SigningKey option, in a new block.
+func (fs *sivaFS) sign(w io.Writer, r io.ReaderAt, end uint64, sums *contentSums) error {
+	blocks, err := readIndexBlocks(r, end)
+	if err != nil {
+		return err
+	}
+
+	scope := fs.options.SignScope
+	cov, prev := covered(blocks, len(blocks), scope)
+	digest, err := signedDigest(r, cov, prev, scope, sums)
+	if err != nil {
+		return err
+	}
+
+	key := fs.options.SigningKey
+	s := &signature{
+		Scope:     scope,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, digest),
+	}
+
+	sw := siva.NewWriter(w)
+	err = sw.WriteHeader(&siva.Header{
+		Name:    signaturePath,
+		Mode:    0644,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := sw.Write(s.marshal()); err != nil {
+		return err
+	}
+
+	return sw.Close()
+}
+
+// verifyBlocks returns the signature status of the blocks of the siva file
+// ending at end, except the ones holding signatures. Signatures not made with
+// key are reported as untrusted, unless it is nil.
+func verifyBlocks(
+	r io.ReaderAt,
+	end uint64,
+	key ed25519.PublicKey,
+	sums *contentSums,
+) ([]BlockSignature, error) {
+	blocks, err := readIndexBlocks(r, end)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make(map[*indexBlock]*BlockSignature)
+	var result []*BlockSignature
+	for _, b := range blocks {
+		if !isSignatureBlock(b) {
+			bs := &BlockSignature{Offset: b.End}
+			status[b] = bs
+			result = append(result, bs)
+		}
+	}
+
+	for i, b := range blocks {
+		if !isSignatureBlock(b) {
+			continue
+		}
+
+		var current BlockSignature
+		s, err := readSignature(r, b)
+		if err == ErrInvalidSignatureEntry {
+			s = &signature{Scope: SignBlock}
+			current.Status = SignatureInvalid
+		} else if err != nil {
+			return nil, err
+		}
+
+		cov, prev := covered(blocks, i, s.Scope)
+		if current.Status != SignatureInvalid {
+			digest, err := signedDigest(r, cov, prev, s.Scope, sums)
+			if err != nil {
+				return nil, err
+			}
+
+			current.Signer = s.PublicKey
+			switch {
+			case !ed25519.Verify(s.PublicKey, digest, s.Signature):
+				current.Status = SignatureInvalid
+			case key != nil && !bytes.Equal(key, s.PublicKey):
+				current.Status = SignatureUntrusted
+			default:
+				current.Status = SignatureValid
+			}
+		}
+
+		for _, c := range cov {
+			bs, ok := status[c]
+			if ok && statusPriority(current.Status) > statusPriority(bs.Status) {
+				bs.Signer = current.Signer
+				bs.Status = current.Status
+			}
+		}
+	}
+
+	signatures := make([]BlockSignature, len(result))
+	for i, bs := range result {
+		signatures[i] = *bs
+	}
+
+	return signatures, nil
+}
+
+// statusPriority orders the statuses of the signatures covering a block, the
+// block gets the status with the highest priority. A mismatching signature
+// always marks the block as invalid.
+func statusPriority(s SignatureStatus) int {
+	switch s {
+	case SignatureInvalid:
+		return 3
+	case SignatureValid:
+		return 2
+	case SignatureUntrusted:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// checkSignatures returns a SignatureError if a block of the siva file ending
+// at end is not validly signed by the VerifyKey option.
+func (fs *sivaFS) checkSignatures(r io.ReaderAt, end uint64) error {
+	signatures, err := verifyBlocks(r, end, fs.options.VerifyKey, &fs.sums)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range signatures {
+		if s.Status != SignatureValid {
+			return &SignatureError{s}
+		}
+	}
+
+	return nil
+}
+
+// VerifySignatures reads the siva file with the given path in fs and returns
+// the signature status of each of its index blocks, from the oldest to the
+// newest. Blocks signed with a key other than key are reported as
+// untrusted, unless key is nil.
+func VerifySignatures(fs billy.Filesystem, path string, key ed25519.PublicKey) ([]BlockSignature, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyBlocks(f, uint64(end), key, nil)
+}
@@ -0,0 +1,232 @@
+package sivafs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type SignSuite struct {
+	memSuite
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+var _ = Suite(&SignSuite{})
+
+func (s *SignSuite) SetUpTest(c *C) {
+	var err error
+	s.memSuite.SetUpTest(c)
+	s.pub, s.priv, err = ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+}
+
+func (s *SignSuite) write(c *C, o SivaFSOptions, names ...string) {
+	fs := s.open(c, o)
+	for _, name := range names {
+		writeFile(c, fs, name, []byte(name))
+	}
+	c.Assert(fs.Sync(), IsNil)
+}
+
+// tamper flips the first byte of the siva file, the contents of the first
+// file written.
+func (s *SignSuite) tamper(c *C) {
+	f, err := s.mem.Open("test.siva")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	data[0] ^= 0xff
+	c.Assert(util.WriteFile(s.mem, "test.siva", data, 0666), IsNil)
+}
+
+func (s *SignSuite) verify(c *C, key ed25519.PublicKey) []SignatureStatus {
+	signatures, err := VerifySignatures(s.mem, "test.siva", key)
+	c.Assert(err, IsNil)
+
+	var status []SignatureStatus
+	for _, sig := range signatures {
+		status = append(status, sig.Status)
+		if sig.Status == SignatureUnsigned {
+			c.Assert(sig.Signer, IsNil)
+		} else {
+			c.Assert(sig.Signer, DeepEquals, s.pub)
+		}
+	}
+
+	return status
+}
+
+func (s *SignSuite) TestSignBlock(c *C) {
+	o := SivaFSOptions{SigningKey: s.priv}
+	s.write(c, o, "one", "two")
+	s.write(c, o, "three")
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureValid, SignatureValid})
+
+	fs := s.open(c, SivaFSOptions{VerifyKey: s.pub, ReadOnly: true})
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"one", "three", "two"})
+	testFileContent(c, fs, "three", "three")
+}
+
+func (s *SignSuite) TestTampered(c *C) {
+	o := SivaFSOptions{SigningKey: s.priv}
+	s.write(c, o, "one")
+	s.write(c, o, "two")
+	s.tamper(c)
+
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureInvalid, SignatureValid})
+
+	fs := s.open(c, SivaFSOptions{VerifyKey: s.pub})
+	_, err := fs.Stat("one")
+	c.Assert(err, FitsTypeOf, &SignatureError{})
+	c.Assert(err.(*SignatureError).Status, Equals, SignatureInvalid)
+
+	fs = s.open(c, SivaFSOptions{})
+	testFileContent(c, fs, "two", "two")
+}
+
+func (s *SignSuite) TestReplayed(c *C) {
+	o := SivaFSOptions{SigningKey: s.priv}
+	fs := s.open(c, o)
+	writeFile(c, fs, "a", []byte("v1"))
+	c.Assert(fs.Sync(), IsNil)
+	writeFile(c, fs, "a", []byte("v2"))
+	c.Assert(fs.Sync(), IsNil)
+
+	f, err := s.mem.Open("test.siva")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	blocks, err := readIndexBlocks(bytes.NewReader(data), uint64(len(data)))
+	c.Assert(err, IsNil)
+	c.Assert(blocks, HasLen, 4)
+
+	// append the first block and its signature again
+	data = append(data, data[:blocks[1].End]...)
+	c.Assert(util.WriteFile(s.mem, "test.siva", data, 0666), IsNil)
+
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureValid, SignatureValid, SignatureInvalid})
+
+	_, err = s.open(c, SivaFSOptions{VerifyKey: s.pub}).Open("a")
+	c.Assert(err, FitsTypeOf, &SignatureError{})
+	c.Assert(err.(*SignatureError).Status, Equals, SignatureInvalid)
+}
+
+func (s *SignSuite) TestUnsigned(c *C) {
+	s.write(c, SivaFSOptions{SigningKey: s.priv}, "one")
+	s.write(c, SivaFSOptions{}, "two")
+
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureValid, SignatureUnsigned})
+
+	fs := s.open(c, SivaFSOptions{VerifyKey: s.pub, ReadOnly: true})
+	_, err := fs.Open("one")
+	c.Assert(err, FitsTypeOf, &SignatureError{})
+	c.Assert(err.(*SignatureError).Status, Equals, SignatureUnsigned)
+}
+
+func (s *SignSuite) TestUntrusted(c *C) {
+	s.write(c, SivaFSOptions{SigningKey: s.priv}, "one")
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+	c.Assert(s.verify(c, other), DeepEquals,
+		[]SignatureStatus{SignatureUntrusted})
+	c.Assert(s.verify(c, nil), DeepEquals,
+		[]SignatureStatus{SignatureValid})
+}
+
+func (s *SignSuite) TestSignArchive(c *C) {
+	s.write(c, SivaFSOptions{}, "one")
+	s.write(c, SivaFSOptions{}, "two")
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureUnsigned, SignatureUnsigned})
+
+	o := SivaFSOptions{SigningKey: s.priv, SignScope: SignArchive}
+	s.write(c, o, "three")
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureValid, SignatureValid, SignatureValid})
+
+	s.tamper(c)
+	c.Assert(s.verify(c, s.pub), DeepEquals,
+		[]SignatureStatus{SignatureInvalid, SignatureInvalid, SignatureInvalid})
+}
+
+// readCountingFS counts the bytes read from the files it opens.
+type readCountingFS struct {
+	billy.Filesystem
+	read int64
+}
+
+func (fs *readCountingFS) OpenFile(name string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := fs.Filesystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readCountingFile{File: f, fs: fs}, nil
+}
+
+type readCountingFile struct {
+	billy.File
+	fs *readCountingFS
+}
+
+func (f *readCountingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.fs.read += int64(n)
+	return n, err
+}
+
+func (f *readCountingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.fs.read += int64(n)
+	return n, err
+}
+
+func (s *SignSuite) TestVerifyOnce(c *C) {
+	data := bytes.Repeat([]byte("signed"), 100000)
+	underlying := &readCountingFS{Filesystem: s.mem}
+	fs, err := NewFilesystemWithOptions(underlying, "test.siva", memfs.New(), SivaFSOptions{
+		SigningKey: s.priv,
+		SignScope:  SignArchive,
+		VerifyKey:  s.pub,
+	})
+	c.Assert(err, IsNil)
+
+	writeFile(c, fs, "big", data)
+	c.Assert(fs.Sync(), IsNil)
+
+	underlying.read = 0
+	for _, name := range []string{"one", "two", "three"} {
+		writeFile(c, fs, name, []byte(name))
+		c.Assert(fs.Sync(), IsNil)
+	}
+
+	c.Assert(underlying.read < int64(len(data)), Equals, true)
+	c.Assert(s.verify(c, s.pub), HasLen, 4)
+}
+
+func (s *SignSuite) TestNothingWritten(c *C) {
+	o := SivaFSOptions{SigningKey: s.priv}
+	s.write(c, o, "one")
+	s.write(c, o)
+
+	c.Assert(readBlocks(c, s.mem, "test.siva"), HasLen, 2)
+}
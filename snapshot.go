@@ -0,0 +1,179 @@
+package sivafs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrSnapshotNotFound  = errors.New("snapshot not found")
+	ErrEmptySnapshotName = errors.New("snapshot name is empty")
+)
+
+// SivaSnapshot is implemented by siva filesystems able to name the states of
+// the siva file, see the Snapshot option.
+type SivaSnapshot interface {
+	// Snapshot syncs the filesystem, naming the state of the siva file
+	// after it. A name used again refers to the newest snapshot.
+	Snapshot(name, message string) error
+	// Snapshots returns the snapshots of the siva file, from the oldest to
+	// the newest.
+	Snapshots() ([]Snapshot, error)
+}
+
+// Snapshot is a named state of a siva file.
+type Snapshot struct {
+	Name    string
+	Message string
+	Time    time.Time
+	// Offset is the offset of the index of the snapshot, it can be used as
+	// the Offset option. It is zero for filesystems made of several siva
+	// files.
+	Offset uint64
+}
+
+// snapshotPath is the name of the entries holding snapshots. They are written
+// in the last block of the snapshot.
+const snapshotPath = reservedDir + "/snapshot"
+
+type snapshotEntry struct {
+	Name    string    `json:"name"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// NewFilesystemSnapshot creates a read only filesystem backed by a siva file
+// as it was when the snapshot with the given name was taken.
+func NewFilesystemSnapshot(fs billy.Filesystem, path, name string) (SivaFS, error) {
+	return NewFilesystemWithOptions(fs, path, nil, SivaFSOptions{
+		ReadOnly: true,
+		Snapshot: name,
+	})
+}
+
+// Snapshot implements SivaSnapshot interface.
+func (fs *sivaFS) Snapshot(name, message string) error {
+	if name == "" {
+		return ErrEmptySnapshotName
+	}
+
+	if err := fs.ensureOpen(); err != nil {
+		return err
+	}
+
+	if fs.getReadWriter() == nil {
+		return ErrReadOnlyFilesystem
+	}
+
+	if fs.fileWriteModeOpen {
+		return ErrFileWriteModeAlreadyOpen
+	}
+
+	now := time.Now()
+	data, err := json.Marshal(&snapshotEntry{
+		Name:    name,
+		Message: message,
+		Time:    now,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fs.writeEntry(&siva.Header{
+		Name:    snapshotPath,
+		Mode:    0644,
+		ModTime: now,
+	}, data)
+	if err != nil {
+		return err
+	}
+
+	return fs.Sync()
+}
+
+// Snapshots implements SivaSnapshot interface.
+func (fs *sivaFS) Snapshots() ([]Snapshot, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	return fs.readSnapshots(fs.f, fs.end)
+}
+
+// readSnapshots returns the snapshots of the siva file ending at end.
+func (fs *sivaFS) readSnapshots(r io.ReaderAt, end uint64) ([]Snapshot, error) {
+	blocks, err := readIndexBlocks(r, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for i, b := range blocks {
+		for _, e := range b.Entries {
+			if e.Name != snapshotPath {
+				continue
+			}
+
+			s, err := fs.readSnapshot(r, b, e)
+			if err != nil {
+				return nil, err
+			}
+
+			// signatures of the block are part of the snapshot.
+			s.Offset = b.End
+			for j := i + 1; j < len(blocks) && isSignatureBlock(blocks[j]); j++ {
+				s.Offset = blocks[j].End
+			}
+
+			snapshots = append(snapshots, *s)
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (fs *sivaFS) readSnapshot(r io.ReaderAt, b *indexBlock, e *siva.IndexEntry) (*Snapshot, error) {
+	sr := io.NewSectionReader(r, int64(b.Offset(e)), int64(e.Size))
+	var content io.Reader = sr
+	if isChunked(e.Flags) {
+		cr, err := newChunkReader(sr, sr.Size(), fs.entryCodec(e))
+		if err != nil {
+			return nil, err
+		}
+
+		content = cr
+	}
+
+	var se snapshotEntry
+	if err := json.NewDecoder(content).Decode(&se); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		Name:    se.Name,
+		Message: se.Message,
+		Time:    se.Time,
+	}, nil
+}
+
+// snapshotOffset returns the offset of the newest snapshot with the given
+// name in the siva file ending at end.
+func (fs *sivaFS) snapshotOffset(r io.ReaderAt, end uint64, name string) (uint64, error) {
+	snapshots, err := fs.readSnapshots(r, end)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Name == name {
+			return snapshots[i].Offset, nil
+		}
+	}
+
+	return 0, ErrSnapshotNotFound
+}
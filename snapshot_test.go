@@ -0,0 +1,148 @@
+package sivafs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type SnapshotSuite struct {
+	memSuite
+}
+
+var _ = Suite(&SnapshotSuite{})
+
+func (s *SnapshotSuite) testSnapshots(c *C, o SivaFSOptions) {
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.(SivaSnapshot).Snapshot("import 2026-10-01", "first import"), IsNil)
+	writeFile(c, fs, "two", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(fs.Remove("one"), IsNil)
+	c.Assert(fs.(SivaSnapshot).Snapshot("import 2026-10-02", ""), IsNil)
+
+	snapshots, err := fs.(SivaSnapshot).Snapshots()
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 2)
+	c.Assert(snapshots[0].Name, Equals, "import 2026-10-01")
+	c.Assert(snapshots[0].Message, Equals, "first import")
+	c.Assert(snapshots[1].Name, Equals, "import 2026-10-02")
+	c.Assert(snapshots[0].Offset < snapshots[1].Offset, Equals, true)
+
+	ro := o
+	ro.ReadOnly = true
+	ro.Snapshot = "import 2026-10-01"
+	fs = s.open(c, ro)
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"one"})
+
+	ro.Snapshot = ""
+	ro.Offset = snapshots[1].Offset
+	fs = s.open(c, ro)
+	files, err = fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"two"})
+}
+
+func (s *SnapshotSuite) TestSnapshots(c *C) {
+	s.testSnapshots(c, SivaFSOptions{})
+}
+
+func (s *SnapshotSuite) TestSnapshotsEncrypted(c *C) {
+	s.testSnapshots(c, SivaFSOptions{Keys: newTestKeys(), Compression: Zstd})
+}
+
+func (s *SnapshotSuite) TestSigned(c *C) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	fs := s.open(c, SivaFSOptions{SigningKey: priv})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.(SivaSnapshot).Snapshot("first", ""), IsNil)
+	writeFile(c, fs, "two", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{
+		ReadOnly:  true,
+		Snapshot:  "first",
+		VerifyKey: pub,
+	})
+	testFileContent(c, fs, "one", "one")
+	_, err = fs.Stat("two")
+	c.Assert(err, NotNil)
+}
+
+func (s *SnapshotSuite) TestNewFilesystemSnapshot(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.(SivaSnapshot).Snapshot("same", "old"), IsNil)
+	writeFile(c, fs, "two", []byte("two"))
+	c.Assert(fs.(SivaSnapshot).Snapshot("same", "new"), IsNil)
+	writeFile(c, fs, "three", []byte("three"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs, err := NewFilesystemSnapshot(s.mem, "test.siva", "same")
+	c.Assert(err, IsNil)
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"one", "two"})
+
+	fs, err = NewFilesystemSnapshot(s.mem, "test.siva", "missing")
+	c.Assert(err, IsNil)
+	_, err = fs.ReadDir("/")
+	c.Assert(err, Equals, ErrSnapshotNotFound)
+}
+
+func (s *SnapshotSuite) TestErrors(c *C) {
+	_, err := NewFilesystemWithOptions(s.mem, "test.siva", memfs.New(),
+		SivaFSOptions{Snapshot: "name"})
+	c.Assert(err, Equals, ErrOffsetReadWrite)
+
+	fs := s.open(c, SivaFSOptions{})
+	c.Assert(fs.(SivaSnapshot).Snapshot("", ""), Equals, ErrEmptySnapshotName)
+
+	writeFile(c, fs, "one", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fs = s.open(c, SivaFSOptions{ReadOnly: true})
+	c.Assert(fs.(SivaSnapshot).Snapshot("name", ""), Equals, ErrReadOnlyFilesystem)
+}
+
+func (s *SnapshotSuite) TestSharded(c *C) {
+	paths := []string{"0.siva", "1.siva"}
+	fs, err := NewSharded(s.mem, paths, memfs.New())
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(c, fs, name, []byte(name))
+	}
+	c.Assert(fs.(SivaSnapshot).Snapshot("first", ""), IsNil)
+	writeFile(c, fs, "e", []byte("e"))
+	c.Assert(fs.Sync(), IsNil)
+
+	// snapshots missing from some shards are not snapshots of the whole
+	// filesystem.
+	shard := s.openPath(c, paths[1], SivaFSOptions{})
+	c.Assert(shard.(SivaSnapshot).Snapshot("partial", ""), IsNil)
+
+	snapshots, err := fs.(SivaSnapshot).Snapshots()
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 1)
+	c.Assert(snapshots[0].Name, Equals, "first")
+	c.Assert(snapshots[0].Offset, Equals, uint64(0))
+
+	_, err = fs.(SivaHistory).History()
+	c.Assert(err, Equals, billy.ErrNotSupported)
+
+	fs, err = NewShardedWithOptions(s.mem, paths, nil, ShardedOptions{
+		SivaFSOptions: SivaFSOptions{ReadOnly: true, Snapshot: "first"},
+	})
+	c.Assert(err, IsNil)
+	files, err := fs.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"a", "b", "c", "d"})
+}
@@ -0,0 +1,130 @@
+package sivafs
+
+import (
+	"strings"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// SplitPart selects the files of a siva filesystem that Split copies to Dst.
+type SplitPart struct {
+	// Dst is the filesystem receiving the files.
+	Dst SivaFS
+	// Prefixes holds directories whose files, at any depth, are selected.
+	Prefixes []string
+	// Patterns holds patterns selecting the files whose path matches them.
+	// The syntax is the same as in path.Match.
+	Patterns []string
+}
+
+// SplitOptions holds configuration options for Split.
+type SplitOptions struct {
+	// Remove writes deletion entries in the source filesystem for the files
+	// copied, all of them in a single index block written once every
+	// destination is synced.
+	Remove bool
+}
+
+// Split copies the files of src selected by each of the parts to the part
+// destination and syncs the destinations. A file selected by several parts
+// is only copied to the first of them. Entries are copied as they are stored
+// in the source siva file with their hash and metadata, and encrypted files
+// are encrypted again with the keys of their destination, see Merge.
+//
+// Every filesystem must be created by this package.
+func Split(src SivaFS, parts []SplitPart) error {
+	return SplitWithOptions(src, parts, SplitOptions{})
+}
+
+// SplitWithOptions splits a siva filesystem and accepts options. See Split
+// documentation.
+func SplitWithOptions(src SivaFS, parts []SplitPart, o SplitOptions) error {
+	s, ok := src.(indexedFS)
+	if !ok {
+		return ErrUnsupportedFilesystem
+	}
+
+	for _, p := range parts {
+		if _, ok := p.Dst.(indexedFS); !ok {
+			return ErrUnsupportedFilesystem
+		}
+	}
+
+	index, err := s.liveIndex()
+	if err != nil {
+		return err
+	}
+
+	var moved []string
+	selected := make(map[string]bool)
+	for _, p := range parts {
+		entries, err := selectEntries(index, p)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if selected[e.Name] {
+				continue
+			}
+
+			selected[e.Name] = true
+			if err := p.Dst.(indexedFS).copyFile(src, e); err != nil {
+				return err
+			}
+
+			moved = append(moved, e.Name)
+		}
+
+		if err := p.Dst.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if !o.Remove || len(moved) == 0 {
+		return nil
+	}
+
+	for _, name := range moved {
+		if err := s.writeTombstone(name); err != nil {
+			return err
+		}
+	}
+
+	return src.Sync()
+}
+
+// selectEntries returns the entries of the index selected by the part, in
+// name order.
+func selectEntries(index siva.OrderedIndex, p SplitPart) ([]*siva.IndexEntry, error) {
+	matched := make(map[*siva.IndexEntry]bool)
+	for _, prefix := range p.Prefixes {
+		prefix = addTrailingSlash(normalizePath(prefix))
+
+		for _, e := range index {
+			if strings.HasPrefix(e.Name, prefix) {
+				matched[e] = true
+			}
+		}
+	}
+
+	for _, pattern := range p.Patterns {
+		entries, err := index.Glob(normalizePath(pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			matched[e] = true
+		}
+	}
+
+	var result []*siva.IndexEntry
+	for _, e := range index {
+		if matched[e] {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
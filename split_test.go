@@ -0,0 +1,130 @@
+package sivafs
+
+import (
+	"crypto"
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type SplitSuite struct {
+	memSuite
+}
+
+var _ = Suite(&SplitSuite{})
+
+func (s *SplitSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+
+	src := s.open(c, "src.siva")
+	writeFile(c, src, "objects/aa/1", []byte("object 1"))
+	writeFile(c, src, "objects/bb/2", []byte("object 2"))
+	writeFile(c, src, "objectsfile", []byte("other"))
+	writeFile(c, src, "refs/heads/master", []byte("master"))
+	writeFile(c, src, "packed-refs", []byte("packed"))
+	writeFile(c, src, "config", []byte("config"))
+	c.Assert(src.Sync(), IsNil)
+}
+
+func (s *SplitSuite) open(c *C, name string) SivaFS {
+	return s.openPath(c, name, SivaFSOptions{})
+}
+
+func (s *SplitSuite) files(c *C, fs SivaFS) []string {
+	var files []string
+	err := walk(fs, "", func(name string, fi os.FileInfo) error {
+		if !fi.IsDir() {
+			files = append(files, name)
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return files
+}
+
+func (s *SplitSuite) TestSplit(c *C) {
+	objects := s.open(c, "objects.siva")
+	refs := s.open(c, "refs.siva")
+
+	err := Split(s.open(c, "src.siva"), []SplitPart{
+		{Dst: objects, Prefixes: []string{"objects"}},
+		{Dst: refs, Prefixes: []string{"/refs/"}, Patterns: []string{"*-refs"}},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.files(c, s.open(c, "objects.siva")), DeepEquals, []string{
+		"objects/aa/1", "objects/bb/2",
+	})
+	c.Assert(s.files(c, s.open(c, "refs.siva")), DeepEquals, []string{
+		"packed-refs", "refs/heads/master",
+	})
+
+	testFileContent(c, s.open(c, "objects.siva"), "objects/aa/1", "object 1")
+	c.Assert(s.files(c, s.open(c, "src.siva")), HasLen, 6)
+}
+
+func (s *SplitSuite) TestFirstPartWins(c *C) {
+	first := s.open(c, "first.siva")
+	second := s.open(c, "second.siva")
+
+	err := Split(s.open(c, "src.siva"), []SplitPart{
+		{Dst: first, Patterns: []string{"config"}},
+		{Dst: second, Patterns: []string{"c*"}},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.files(c, s.open(c, "first.siva")), DeepEquals, []string{"config"})
+	c.Assert(s.files(c, s.open(c, "second.siva")), HasLen, 0)
+}
+
+func (s *SplitSuite) TestRemove(c *C) {
+	blocks := readBlocks(c, s.mem, "src.siva")
+
+	err := SplitWithOptions(s.open(c, "src.siva"), []SplitPart{
+		{Dst: s.open(c, "objects.siva"), Prefixes: []string{"objects"}},
+	}, SplitOptions{Remove: true})
+	c.Assert(err, IsNil)
+
+	c.Assert(readBlocks(c, s.mem, "src.siva"), HasLen, len(blocks)+1)
+	c.Assert(s.files(c, s.open(c, "src.siva")), DeepEquals, []string{
+		"config", "objectsfile", "packed-refs", "refs/heads/master",
+	})
+	c.Assert(s.files(c, s.open(c, "objects.siva")), HasLen, 2)
+}
+
+func (s *SplitSuite) TestEncrypted(c *C) {
+	o := SivaFSOptions{Keys: newTestKeys(), EncryptNames: true, Hash: crypto.SHA256}
+	src, err := NewFilesystemWithOptions(s.mem, "encrypted.siva", memfs.New(), o)
+	c.Assert(err, IsNil)
+	writeFile(c, src, "objects/aa/1", []byte("object 1"))
+	writeFile(c, src, "config", []byte("config"))
+	c.Assert(src.(SivaMeta).SetMeta("objects/aa/1", "key", "value"), IsNil)
+	c.Assert(src.Sync(), IsNil)
+
+	err = SplitWithOptions(src, []SplitPart{
+		{Dst: s.open(c, "objects.siva"), Prefixes: []string{"objects"}},
+	}, SplitOptions{Remove: true})
+	c.Assert(err, IsNil)
+
+	objects := s.open(c, "objects.siva")
+	testFileContent(c, objects, "objects/aa/1", "object 1")
+	value, err := objects.(SivaMeta).GetMeta("objects/aa/1", "key")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "value")
+	_, sum, err := objects.(SivaHash).Hash("objects/aa/1")
+	c.Assert(err, IsNil)
+	c.Assert(sum, HasLen, crypto.SHA256.Size())
+
+	src, err = NewFilesystemWithOptions(s.mem, "encrypted.siva", memfs.New(), o)
+	c.Assert(err, IsNil)
+	c.Assert(s.files(c, src), DeepEquals, []string{"config"})
+}
+
+func (s *SplitSuite) TestBadPattern(c *C) {
+	err := Split(s.open(c, "src.siva"), []SplitPart{
+		{Dst: s.open(c, "bad.siva"), Patterns: []string{"["}},
+	})
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,318 @@
+package sivafs
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// statsLargest is the number of files returned in Stats.Largest.
+const statsLargest = 10
+
+// SivaStats is implemented by siva filesystems able to report how the space of
+// the siva file is used.
+type SivaStats interface {
+	// Stats returns the statistics of the siva file. Changes not synced yet
+	// are not included. Index blocks are only read once, so it is cheap to
+	// call it after every Sync.
+	Stats() (*Stats, error)
+}
+
+// Stats holds statistics of a siva file.
+type Stats struct {
+	// Size is the size of the siva file up to the index in use.
+	Size uint64
+	// LiveBytes is the size of the contents of the entries in the live
+	// index, including the ones used internally, except blobs no longer
+	// referenced by any file and sidecars of files no longer live.
+	LiveBytes uint64
+	// DeadBytes is the size of the contents of superseded and deleted
+	// entries, and of the live ones not counted in LiveBytes. It is the
+	// size Compact reclaims.
+	DeadBytes uint64
+	// IndexBytes is the size of the indexes of the blocks.
+	IndexBytes uint64
+	// Blocks is the number of index blocks.
+	Blocks int
+	// Entries is the number of entries in all the index blocks.
+	Entries int
+	// Tombstones is the number of entries marking deletions.
+	Tombstones int
+	// Files is the number of files in the live index.
+	Files int
+	// Largest holds the files of the live index taking the most bytes in the
+	// siva file, the largest first.
+	Largest []FileStats
+}
+
+// FileStats holds the size of a file in a siva file.
+type FileStats struct {
+	Name string
+	// Size is the size of the contents of the file in the siva file, once
+	// compressed and encrypted.
+	Size uint64
+}
+
+// Fragmentation returns the ratio of the contents of the siva file held by
+// superseded and deleted entries, from 0 to 1.
+func (s *Stats) Fragmentation() float64 {
+	total := s.LiveBytes + s.DeadBytes
+	if total == 0 {
+		return 0
+	}
+
+	return float64(s.DeadBytes) / float64(total)
+}
+
+// add adds the statistics of another siva file.
+func (s *Stats) add(o *Stats) {
+	s.Size += o.Size
+	s.LiveBytes += o.LiveBytes
+	s.DeadBytes += o.DeadBytes
+	s.IndexBytes += o.IndexBytes
+	s.Blocks += o.Blocks
+	s.Entries += o.Entries
+	s.Tombstones += o.Tombstones
+	s.Files += o.Files
+	s.Largest = largest(append(s.Largest, o.Largest...))
+}
+
+func largest(files []FileStats) []FileStats {
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+
+		return files[i].Name < files[j].Name
+	})
+
+	if len(files) > statsLargest {
+		files = files[:statsLargest]
+	}
+
+	return files
+}
+
+// liveEntry is the newest entry of a path.
+type liveEntry struct {
+	blockEntry
+	stored string
+	// blob is the path of the blob referenced by the entry, if it has
+	// flagReference, read once by Stats.
+	blob     string
+	resolved bool
+}
+
+// statsCache holds the statistics of the index blocks read so far.
+type statsCache struct {
+	mu sync.Mutex
+	blockStats
+}
+
+// blockStats holds the statistics of a sequence of index blocks.
+type blockStats struct {
+	// end is the offset where the last block read ends and crc the CRC32 of
+	// its index, used to tell whether the siva file was replaced.
+	end  uint64
+	crc  uint32
+	live map[string]*liveEntry
+
+	contentBytes uint64
+	liveBytes    uint64
+	blocks       int
+	entries      int
+	tombstones   int
+}
+
+func (c *statsCache) reset() {
+	c.blockStats = blockStats{live: make(map[string]*liveEntry)}
+}
+
+func (c *statsCache) apply(b *indexBlock, unsafePaths bool) {
+	for _, e := range b.Entries {
+		name := e.Name
+		if !unsafePaths {
+			name = siva.ToSafePath(name)
+		}
+
+		c.entries++
+		c.contentBytes += e.Size
+		if prev, ok := c.live[name]; ok {
+			c.liveBytes -= prev.Size
+			delete(c.live, name)
+		}
+
+		if e.Flags&siva.FlagDeleted != 0 {
+			c.tombstones++
+			continue
+		}
+
+		c.live[name] = &liveEntry{blockEntry: blockEntry{e, b}, stored: name}
+		c.liveBytes += e.Size
+	}
+
+	c.blocks++
+	c.end = b.End
+	c.crc = b.Footer.CRC32
+}
+
+// Stats implements SivaStats interface.
+func (fs *sivaFS) Stats() (*Stats, error) {
+	if err := fs.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	c := &fs.stats
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := fs.updateStats(c); err != nil {
+		return nil, err
+	}
+
+	unused, err := fs.unusedBytes(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stats{
+		Size:       fs.end,
+		LiveBytes:  c.liveBytes - unused,
+		DeadBytes:  c.contentBytes - c.liveBytes + unused,
+		IndexBytes: fs.end - c.contentBytes,
+		Blocks:     c.blocks,
+		Entries:    c.entries,
+		Tombstones: c.tombstones,
+	}
+
+	var files []*liveEntry
+	for _, e := range c.live {
+		if e.Flags&flagEncryptedName == 0 && isReserved(e.stored) {
+			continue
+		}
+
+		files = append(files, e)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+
+		return files[i].stored < files[j].stored
+	})
+
+	for _, e := range files {
+		name := e.stored
+		if e.Flags&flagEncryptedName != 0 {
+			r := io.NewSectionReader(fs.f, int64(e.Block.Offset(e.IndexEntry)), int64(e.Size))
+			var err error
+			if name, err = fs.realName(name, r); err != nil {
+				return nil, err
+			}
+
+			if isReserved(name) {
+				continue
+			}
+		}
+
+		s.Files++
+		if len(s.Largest) < statsLargest {
+			s.Largest = append(s.Largest, FileStats{Name: name, Size: e.Size})
+		}
+	}
+
+	return s, nil
+}
+
+// unusedBytes returns the size of the live entries dropped by a compaction:
+// blobs no longer referenced and sidecars of files no longer live.
+func (fs *sivaFS) unusedBytes(c *statsCache) (uint64, error) {
+	referenced := make(map[string]bool)
+	for _, e := range c.live {
+		if e.Flags&flagReference == 0 {
+			continue
+		}
+
+		if !e.resolved {
+			id, err := fs.readBlockEntry(e.blockEntry)
+			if err != nil {
+				return 0, err
+			}
+
+			e.blob, e.resolved = blobPath(string(id)), true
+		}
+
+		referenced[e.blob] = true
+	}
+
+	var unused uint64
+	for name, e := range c.live {
+		if strings.HasPrefix(name, metaDir+"/") {
+			name = strings.TrimPrefix(name, metaDir+"/")
+			if _, ok := c.live[name]; !ok {
+				unused += e.Size
+				continue
+			}
+		}
+
+		if strings.HasPrefix(name, blobsDir+"/") && !referenced[name] {
+			unused += e.Size
+		}
+	}
+
+	return unused, nil
+}
+
+// updateStats reads the index blocks written after the ones in the cache. The
+// cache is rebuilt if the siva file does not hold them anymore.
+func (fs *sivaFS) updateStats(c *statsCache) error {
+	if c.live == nil || c.end > fs.end || !fs.sameBlock(c.end, c.crc) {
+		c.reset()
+	}
+
+	var blocks []*indexBlock
+	for end := fs.end; end > c.end; {
+		b, err := readIndexBlock(fs.f, end)
+		if err != nil {
+			return err
+		}
+
+		if b.Start < c.end {
+			c.reset()
+			return fs.updateStats(c)
+		}
+
+		blocks = append(blocks, b)
+		end = b.Start
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		c.apply(blocks[i], fs.options.UnsafePaths)
+	}
+
+	return nil
+}
+
+// sameBlock returns true if the siva file has a block ending at end with an
+// index with the given CRC32.
+func (fs *sivaFS) sameBlock(end uint64, crc uint32) bool {
+	if end == 0 {
+		return true
+	}
+
+	if end > fs.end {
+		return false
+	}
+
+	var footer siva.IndexFooter
+	r := io.NewSectionReader(fs.f, int64(end-indexFooterSize), indexFooterSize)
+	if err := footer.ReadFrom(r); err != nil {
+		return false
+	}
+
+	return footer.CRC32 == crc
+}
@@ -0,0 +1,161 @@
+package sivafs
+
+import (
+	"io/ioutil"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type StatsSuite struct {
+	memSuite
+}
+
+var _ = Suite(&StatsSuite{})
+
+func (s *StatsSuite) size(c *C) uint64 {
+	fi, err := s.mem.Stat("test.siva")
+	c.Assert(err, IsNil)
+	return uint64(fi.Size())
+}
+
+func (s *StatsSuite) TestStats(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte(strings.Repeat("1", 10)))
+	writeFile(c, fs, "dir/two", []byte(strings.Repeat("2", 20)))
+	c.Assert(fs.Sync(), IsNil)
+
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.LiveBytes, Equals, uint64(30))
+	c.Assert(stats.DeadBytes, Equals, uint64(0))
+	c.Assert(stats.Fragmentation(), Equals, float64(0))
+
+	writeFile(c, fs, "one", []byte(strings.Repeat("1", 5)))
+	c.Assert(fs.Remove("dir/two"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	stats, err = fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, &Stats{
+		Size:       s.size(c),
+		LiveBytes:  5,
+		DeadBytes:  30,
+		IndexBytes: s.size(c) - 35,
+		Blocks:     2,
+		Entries:    4,
+		Tombstones: 1,
+		Files:      1,
+		Largest:    []FileStats{{Name: "one", Size: 5}},
+	})
+	c.Assert(stats.Fragmentation(), Equals, float64(30)/35)
+
+	fresh, err := s.open(c, SivaFSOptions{}).(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(fresh, DeepEquals, stats)
+
+	ro := s.open(c, SivaFSOptions{ReadOnly: true, Offset: stats.Size})
+	old, err := ro.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(old.Blocks, Equals, 2)
+}
+
+func (s *StatsSuite) TestUnreferencedBlobs(c *C) {
+	o := SivaFSOptions{Dedup: true}
+	fs := s.open(c, o)
+	writeFile(c, fs, "one", []byte(strings.Repeat("1", 100)))
+	writeFile(c, fs, "two", []byte(strings.Repeat("1", 100)))
+	c.Assert(fs.Sync(), IsNil)
+
+	live, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+
+	c.Assert(fs.Remove("one"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.LiveBytes < live.LiveBytes, Equals, true)
+	c.Assert(stats.LiveBytes > 100, Equals, true)
+
+	c.Assert(fs.Remove("two"), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+	stats, err = fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.LiveBytes, Equals, uint64(0))
+
+	c.Assert(fs.(SivaCompact).Compact(), IsNil)
+	stats, err = fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.DeadBytes, Equals, uint64(0))
+}
+
+func (s *StatsSuite) TestLargest(c *C) {
+	fs := s.open(c, SivaFSOptions{
+		Keys:         newTestKeys(),
+		EncryptNames: true,
+		Dedup:        true,
+	})
+	for i := 1; i <= statsLargest+2; i++ {
+		name := strings.Repeat("f", i)
+		writeFile(c, fs, name, []byte(strings.Repeat("x", i*100)))
+	}
+	c.Assert(fs.Sync(), IsNil)
+
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Files, Equals, statsLargest+2)
+	c.Assert(stats.Largest, HasLen, statsLargest)
+	c.Assert(stats.Largest[0].Name, Equals, strings.Repeat("f", statsLargest+2))
+	for i := 1; i < len(stats.Largest); i++ {
+		c.Assert(stats.Largest[i].Size <= stats.Largest[i-1].Size, Equals, true)
+	}
+}
+
+func (s *StatsSuite) TestReplaced(c *C) {
+	fs := s.open(c, SivaFSOptions{})
+	writeFile(c, fs, "one", []byte("one"))
+	writeFile(c, fs, "two", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Files, Equals, 2)
+
+	other := memfs.New()
+	ofs, err := NewFilesystem(other, "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+	writeFile(c, ofs, "three", []byte("three"))
+	c.Assert(ofs.Sync(), IsNil)
+
+	f, err := other.Open("test.siva")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(fs.Sync(), IsNil)
+	c.Assert(util.WriteFile(s.mem, "test.siva", data, 0666), IsNil)
+
+	stats, err = fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Files, Equals, 1)
+	c.Assert(stats.Largest, DeepEquals, []FileStats{{Name: "three", Size: 5}})
+}
+
+func (s *StatsSuite) TestSharded(c *C) {
+	fs, err := NewSharded(s.mem, []string{"0.siva", "1.siva"}, memfs.New())
+	c.Assert(err, IsNil)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(c, fs, name, []byte(name))
+	}
+	c.Assert(fs.Sync(), IsNil)
+
+	stats, err := fs.(SivaStats).Stats()
+	c.Assert(err, IsNil)
+	c.Assert(stats.Files, Equals, 4)
+	c.Assert(stats.LiveBytes, Equals, uint64(4))
+	c.Assert(stats.Largest, HasLen, 4)
+	c.Assert(stats.Largest[0].Name, Equals, "a")
+}
@@ -0,0 +1,164 @@
+package sivafs
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// ImportTar writes the contents of the tar archive read from r into fs,
+// keeping the mode and modification time of the entries. Symbolic links are
+// stored as files with os.ModeSymlink containing the link target and hard
+// links as copies of their target. Directories are implicit in siva files so
+// they are only created for filesystems supporting them. Other entry types
+// are ignored, as is the root directory. Entries with absolute paths or paths
+// pointing outside of the root are rejected with ErrUnsafePath.
+//
+// Sync must be called afterwards to write the index of the siva file.
+func ImportTar(r io.Reader, fs SivaFS) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := importTarEntry(fs, tr, hdr); err != nil {
+			return err
+		}
+	}
+}
+
+func importTarEntry(fs SivaFS, tr *tar.Reader, hdr *tar.Header) error {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+	default:
+		return nil
+	}
+
+	if hdr.Typeflag == tar.TypeDir && isRoot(hdr.Name) {
+		return nil
+	}
+
+	name, err := safePath("import", hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	mode := hdr.FileInfo().Mode()
+	var content io.Reader = tr
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return fs.MkdirAll(name, mode.Perm())
+	case tar.TypeSymlink:
+		content = strings.NewReader(hdr.Linkname)
+	case tar.TypeLink:
+		target, err := safePath("import", hdr.Linkname)
+		if err != nil {
+			return err
+		}
+
+		f, err := fs.Open(target)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		content = f
+		mode = mode.Perm()
+	}
+
+	return copyToFile(fs, &siva.Header{
+		Name:    name,
+		Mode:    mode,
+		ModTime: hdr.ModTime,
+	}, content)
+}
+
+// copyToFile creates the file described by h in fs and fills it with the
+// contents of r.
+func copyToFile(fs billy.Basic, h *siva.Header, r io.Reader) error {
+	f, err := createWithHeader(fs, h)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// ExportTar writes the files and directories of fs to w as a tar archive.
+// Files with os.ModeSymlink are written as symbolic links. To export the
+// contents of an older index use a read only filesystem with its offset, see
+// NewFilesystemReadOnly.
+func ExportTar(fs SivaFS, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := walk(fs, "", func(name string, fi os.FileInfo) error {
+		return exportTarEntry(fs, tw, name, fi)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func exportTarEntry(fs SivaFS, tw *tar.Writer, name string, fi os.FileInfo) error {
+	var f billy.File
+	if !fi.IsDir() {
+		var err error
+		f, err = fs.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		link = string(target)
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+		if fi.Mode().Perm() == 0 {
+			hdr.Mode |= 0755
+		}
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,187 @@
+package sivafs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type TarSuite struct{}
+
+var _ = Suite(&TarSuite{})
+
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func buildTar(c *C, entries []tarEntry) *bytes.Buffer {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.content))
+		if hdr.Typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+
+		c.Assert(tw.WriteHeader(&hdr), IsNil)
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte(e.content))
+			c.Assert(err, IsNil)
+		}
+	}
+
+	c.Assert(tw.Close(), IsNil)
+	return buf
+}
+
+func newTestFilesystem(c *C) SivaFS {
+	fs, err := NewFilesystem(memfs.New(), "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *TarSuite) TestImportExport(c *C) {
+	modTime := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	buf := buildTar(c, []tarEntry{
+		{tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}, ""},
+		{tar.Header{Name: "dir/one.txt", Typeflag: tar.TypeReg, Mode: 0640, ModTime: modTime}, "one"},
+		{tar.Header{Name: "./two.txt", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}, "two"},
+		{tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/one.txt", Mode: 0777, ModTime: modTime}, ""},
+		{tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "two.txt", Mode: 0600, ModTime: modTime}, ""},
+		{tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0600, ModTime: modTime}, ""},
+	})
+
+	fs := newTestFilesystem(c)
+	c.Assert(ImportTar(buf, fs), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	fi, err := fs.Stat("dir/one.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+	c.Assert(fi.ModTime().Equal(modTime), Equals, true)
+
+	fi, err = fs.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Equals, os.ModeSymlink)
+
+	testFileContent(c, fs, "hard", "two")
+
+	_, err = fs.Stat("fifo")
+	c.Assert(err, Equals, os.ErrNotExist)
+
+	out := bytes.NewBuffer(nil)
+	c.Assert(ExportTar(fs, out), IsNil)
+
+	tr := tar.NewReader(out)
+	expected := []struct {
+		name     string
+		typeflag byte
+		content  string
+	}{
+		{"dir/", tar.TypeDir, ""},
+		{"dir/one.txt", tar.TypeReg, "one"},
+		{"hard", tar.TypeReg, "two"},
+		{"link", tar.TypeSymlink, ""},
+		{"two.txt", tar.TypeReg, "two"},
+	}
+
+	for _, e := range expected {
+		hdr, err := tr.Next()
+		c.Assert(err, IsNil)
+		c.Assert(hdr.Name, Equals, e.name)
+		c.Assert(hdr.Typeflag, Equals, e.typeflag)
+		c.Assert(hdr.ModTime.Equal(modTime), Equals, true)
+
+		content, err := ioutil.ReadAll(tr)
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Equals, e.content)
+
+		if e.typeflag == tar.TypeSymlink {
+			c.Assert(hdr.Linkname, Equals, "dir/one.txt")
+		}
+	}
+
+	_, err = tr.Next()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *TarSuite) TestImportUnsafe(c *C) {
+	for _, name := range []string{"../outside", "/absolute", "a/../../b"} {
+		buf := buildTar(c, []tarEntry{
+			{tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}, "x"},
+		})
+
+		err := ImportTar(buf, newTestFilesystem(c))
+		c.Assert(err, NotNil)
+		c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+	}
+}
+
+func (s *TarSuite) TestImportDot(c *C) {
+	buf := buildTar(c, []tarEntry{
+		{tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "./dir/a", Typeflag: tar.TypeReg, Mode: 0644}, "a"},
+	})
+
+	fs := newTestFilesystem(c)
+	c.Assert(ImportTar(buf, fs), IsNil)
+	testFileContent(c, fs, "dir/a", "a")
+
+	buf = buildTar(c, []tarEntry{
+		{tar.Header{Name: ".", Typeflag: tar.TypeReg, Mode: 0644}, "x"},
+	})
+
+	err := ImportTar(buf, newTestFilesystem(c))
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+}
+
+func (s *TarSuite) TestExportOffset(c *C) {
+	mem := memfs.New()
+	fs, err := NewFilesystem(mem, "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	writeFile(c, fs, "one.txt", []byte("one"))
+	c.Assert(fs.Sync(), IsNil)
+
+	fi, err := mem.Stat("test.siva")
+	c.Assert(err, IsNil)
+	offset := uint64(fi.Size())
+
+	writeFile(c, fs, "two.txt", []byte("two"))
+	c.Assert(fs.Sync(), IsNil)
+
+	old, err := NewFilesystemReadOnly(mem, "test.siva", offset)
+	c.Assert(err, IsNil)
+
+	out := bytes.NewBuffer(nil)
+	c.Assert(ExportTar(old, out), IsNil)
+
+	tr := tar.NewReader(out)
+	hdr, err := tr.Next()
+	c.Assert(err, IsNil)
+	c.Assert(hdr.Name, Equals, "one.txt")
+
+	_, err = tr.Next()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func testFileContent(c *C, fs billy.Basic, name, expected string) {
+	f, err := fs.Open(name)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, expected)
+	c.Assert(f.Close(), IsNil)
+}
@@ -0,0 +1,514 @@
+package sivafs
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/helper/chroot"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+var (
+	ErrNoLayers         = errors.New("union needs at least one layer")
+	ErrUnsupportedLayer = errors.New("layer is not a siva filesystem of this package")
+)
+
+// UnionOptions holds configuration options for union filesystems.
+type UnionOptions struct {
+	// Writable sends writes to the top layer, the last one. Removing a file
+	// of a lower layer writes a deletion entry in the top layer hiding it.
+	// Otherwise the union is read only.
+	Writable bool
+}
+
+// NewUnion creates a read only filesystem merging the given siva
+// filesystems, from the bottom layer to the top one. Files of upper layers
+// replace the ones with the same path in lower layers, and files deleted in
+// an upper layer are hidden from lower layers. Only the indexes of the layers
+// are merged, file contents are read from the layer holding them.
+//
+// Layers must be filesystems created by this package.
+func NewUnion(layers ...SivaFS) (SivaFS, error) {
+	return NewUnionWithOptions(layers, UnionOptions{})
+}
+
+// NewUnionWithOptions creates a union filesystem and accepts options. See
+// NewUnion documentation.
+func NewUnionWithOptions(layers []SivaFS, o UnionOptions) (SivaFS, error) {
+	if len(layers) == 0 {
+		return nil, ErrNoLayers
+	}
+
+	for _, l := range layers {
+		if _, ok := l.(indexedFS); !ok {
+			return nil, ErrUnsupportedLayer
+		}
+	}
+
+	u := &union{
+		layers:   layers,
+		writable: o.Writable,
+		ctx:      context.Background(),
+	}
+
+	return newUnionFS(u), nil
+}
+
+func newUnionFS(u *union) *unionFS {
+	return &unionFS{
+		Filesystem: chroot.New(u, "/"),
+		forwarded:  forwarded{u},
+		writable:   u.writable,
+	}
+}
+
+type union struct {
+	layers   []SivaFS
+	writable bool
+	ctx      context.Context
+
+	// mu guards the merged view, kept until the generation of the layers
+	// changes.
+	mu      sync.Mutex
+	view    *indexView
+	deleted map[string]bool
+	viewGen uint64
+}
+
+// merge returns a view of the files of the union and the paths deleted in
+// it. The view is built again only when a layer changes.
+func (u *union) merge() (*indexView, map[string]bool, error) {
+	if err := u.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	gen := u.generation()
+	if u.view != nil && u.viewGen == gen {
+		return u.view, u.deleted, nil
+	}
+
+	v, deleted, err := u.mergeLayers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u.view, u.deleted, u.viewGen = v, deleted, gen
+	return v, deleted, nil
+}
+
+// generation returns the sum of the generations of the layers, which grows
+// every time one of them changes.
+func (u *union) generation() uint64 {
+	var gen uint64
+	for _, l := range u.layers {
+		gen += l.(indexedFS).generation()
+	}
+
+	return gen
+}
+
+func (u *union) mergeLayers() (*indexView, map[string]bool, error) {
+	v := newIndexView(u.ctx)
+	deleted := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for i := len(u.layers) - 1; i >= 0; i-- {
+		l := u.layers[i]
+		index, err := l.(indexedFS).liveIndex()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		layerDeleted, err := l.(indexedFS).deletedPaths()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, e := range index {
+			if seen[e.Name] {
+				continue
+			}
+
+			seen[e.Name] = true
+			v.add(e, l)
+		}
+
+		for name := range layerDeleted {
+			if seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			deleted[name] = true
+		}
+	}
+
+	v.sort()
+	return v, deleted, nil
+}
+
+func (u *union) top() SivaFS {
+	return u.layers[len(u.layers)-1]
+}
+
+func (u *union) Create(path string) (billy.File, error) {
+	return u.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (u *union) Open(path string) (billy.File, error) {
+	return u.OpenFile(path, os.O_RDONLY, 0)
+}
+
+func (u *union) OpenFile(path string, flag int, mode os.FileMode) (billy.File, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_RDWR) != 0 {
+		if !u.writable {
+			return nil, ErrReadOnlyFilesystem
+		}
+
+		return u.top().OpenFile(path, flag, mode)
+	}
+
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.open(normalizePath(path), flag, mode)
+}
+
+func (u *union) Stat(path string) (os.FileInfo, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.stat(normalizePath(path))
+}
+
+func (u *union) ReadDir(path string) ([]os.FileInfo, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.readDir(normalizePath(path))
+}
+
+func (u *union) MkdirAll(path string, perm os.FileMode) error {
+	if !u.writable {
+		return ErrReadOnlyFilesystem
+	}
+
+	v, _, err := u.merge()
+	if err != nil {
+		return err
+	}
+
+	return mkdirAll(v, normalizePath(path))
+}
+
+func (u *union) Remove(path string) error {
+	if !u.writable {
+		return ErrReadOnlyFilesystem
+	}
+
+	v, _, err := u.merge()
+	if err != nil {
+		return err
+	}
+
+	path = normalizePath(path)
+	if err := checkRemove(v, path); err != nil {
+		return err
+	}
+
+	return u.writeTombstone(path)
+}
+
+func (u *union) Rename(from, to string) error {
+	return billy.ErrNotSupported
+}
+
+// Join joins the specified elements using the filesystem separator.
+func (u *union) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// Sync syncs every layer.
+func (u *union) Sync() error {
+	var firstErr error
+	for _, l := range u.layers {
+		if err := l.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Bytes implements SivaBytes interface.
+func (u *union) Bytes(path string) ([]byte, io.Closer, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v.bytes(normalizePath(path))
+}
+
+// Hash implements SivaHash interface.
+func (u *union) Hash(path string) (crypto.Hash, []byte, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return v.hash(normalizePath(path))
+}
+
+// FindByHash implements SivaHash interface.
+func (u *union) FindByHash(sum []byte) ([]string, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.findByHash(sum)
+}
+
+// CreateWithMeta implements SivaMeta interface.
+func (u *union) CreateWithMeta(path string, meta map[string]string) (billy.File, error) {
+	if !u.writable {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	m, ok := u.top().(SivaMeta)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return m.CreateWithMeta(path, meta)
+}
+
+// GetMeta implements SivaMeta interface.
+func (u *union) GetMeta(path, key string) (string, error) {
+	m, err := u.meta(path, false)
+	if err != nil {
+		return "", err
+	}
+
+	return m.GetMeta(path, key)
+}
+
+// SetMeta implements SivaMeta interface.
+func (u *union) SetMeta(path, key, value string) error {
+	m, err := u.meta(path, true)
+	if err != nil {
+		return err
+	}
+
+	return m.SetMeta(path, key, value)
+}
+
+// RemoveMeta implements SivaMeta interface.
+func (u *union) RemoveMeta(path, key string) error {
+	m, err := u.meta(path, true)
+	if err != nil {
+		return err
+	}
+
+	return m.RemoveMeta(path, key)
+}
+
+// ListMeta implements SivaMeta interface.
+func (u *union) ListMeta(path string) (map[string]string, error) {
+	m, err := u.meta(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ListMeta(path)
+}
+
+// meta returns the layer holding the file, to access its metadata. The
+// metadata of files in layers other than the top one can not be changed.
+func (u *union) meta(path string, write bool) (SivaMeta, error) {
+	if write && !u.writable {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	path = normalizePath(path)
+	if write && v.owner(path) != nil && v.owner(path) != u.top() {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	return v.meta(path)
+}
+
+// Snapshot implements SivaSnapshot interface. The snapshot is taken in the
+// top layer, after syncing the others.
+func (u *union) Snapshot(name, message string) error {
+	if !u.writable {
+		return ErrReadOnlyFilesystem
+	}
+
+	top, ok := u.top().(SivaSnapshot)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	if err := u.Sync(); err != nil {
+		return err
+	}
+
+	return top.Snapshot(name, message)
+}
+
+// Snapshots implements SivaSnapshot interface. The snapshots of the top
+// layer are returned.
+func (u *union) Snapshots() ([]Snapshot, error) {
+	top, ok := u.top().(SivaSnapshot)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return top.Snapshots()
+}
+
+// History implements SivaHistory interface. The history of the top layer is
+// returned.
+func (u *union) History() ([]Revision, error) {
+	top, ok := u.top().(SivaHistory)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return top.History()
+}
+
+// Stats implements SivaStats interface. The statistics of the top layer are
+// returned.
+func (u *union) Stats() (*Stats, error) {
+	top, ok := u.top().(SivaStats)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return top.Stats()
+}
+
+// Compact implements SivaCompact interface. Only the top layer is compacted,
+// keeping its tombstones if there are layers below.
+func (u *union) Compact() error {
+	return u.compact(false)
+}
+
+func (u *union) compact(keepTombstones bool) error {
+	if !u.writable {
+		return ErrReadOnlyFilesystem
+	}
+
+	top, ok := u.top().(tombstoneCompacter)
+	if !ok {
+		return billy.ErrNotSupported
+	}
+
+	return top.compact(keepTombstones || len(u.layers) > 1)
+}
+
+// CreateHeader implements SivaCreateHeader interface.
+func (u *union) CreateHeader(h *siva.Header) (billy.File, error) {
+	if !u.writable {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	return createWithHeader(u.top(), h)
+}
+
+func (u *union) liveIndex() (siva.OrderedIndex, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.index, nil
+}
+
+func (u *union) deletedPaths() (map[string]bool, error) {
+	_, deleted, err := u.merge()
+	return deleted, err
+}
+
+func (u *union) writeTombstone(path string) error {
+	if !u.writable {
+		return ErrReadOnlyFilesystem
+	}
+
+	return u.top().(indexedFS).writeTombstone(path)
+}
+
+func (u *union) copyFile(src SivaFS, e *siva.IndexEntry) error {
+	if !u.writable {
+		return ErrReadOnlyFilesystem
+	}
+
+	return u.top().(indexedFS).copyFile(src, e)
+}
+
+type unionFS struct {
+	billy.Filesystem
+	forwarded
+
+	writable bool
+}
+
+// Capability implements billy.Capable interface.
+func (u *unionFS) Capabilities() billy.Capability {
+	if u.writable {
+		return sivaCapabilities
+	}
+
+	return sivaCapabilities & ^billy.WriteCapability
+}
+
+// TempFile implements billy.TempFile interface. Temporary files are not
+// supported by union filesystems.
+func (u *unionFS) TempFile(dir, prefix string) (billy.File, error) {
+	if !u.writable {
+		return nil, ErrReadOnlyFilesystem
+	}
+
+	return nil, billy.ErrNotSupported
+}
+
+func (u *union) openRaw(path string) (*siva.IndexEntry, io.Reader, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v.openRaw(normalizePath(path))
+}
+
+func (u *union) fileInfo(e *siva.IndexEntry) (os.FileInfo, error) {
+	v, _, err := u.merge()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.fileInfo(e)
+}
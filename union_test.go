@@ -0,0 +1,175 @@
+package sivafs
+
+import (
+	"os"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+type UnionSuite struct {
+	memSuite
+}
+
+var _ = Suite(&UnionSuite{})
+
+func (s *UnionSuite) SetUpTest(c *C) {
+	s.memSuite.SetUpTest(c)
+
+	base := s.layer(c, "base.siva", false)
+	writeFile(c, base, "a.txt", []byte("base-a"))
+	writeFile(c, base, "b.txt", []byte("base-b"))
+	writeFile(c, base, "dir/c.txt", []byte("base-c"))
+	c.Assert(base.Sync(), IsNil)
+
+	top := s.layer(c, "top.siva", false)
+	writeFile(c, top, "a.txt", []byte("top-a"))
+	writeFile(c, top, "d.txt", []byte("top-d"))
+	c.Assert(top.Sync(), IsNil)
+}
+
+func (s *UnionSuite) layer(c *C, name string, readOnly bool) SivaFS {
+	return s.openPath(c, name, SivaFSOptions{ReadOnly: readOnly})
+}
+
+func (s *UnionSuite) union(c *C, writable bool) SivaFS {
+	u, err := NewUnionWithOptions([]SivaFS{
+		s.layer(c, "base.siva", true),
+		s.layer(c, "top.siva", !writable),
+	}, UnionOptions{Writable: writable})
+	c.Assert(err, IsNil)
+	return u
+}
+
+func (s *UnionSuite) TestRead(c *C) {
+	u := s.union(c, false)
+
+	testFileContent(c, u, "a.txt", "top-a")
+	testFileContent(c, u, "b.txt", "base-b")
+	testFileContent(c, u, "dir/c.txt", "base-c")
+	testFileContent(c, u, "d.txt", "top-d")
+
+	files, err := u.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"dir", "a.txt", "b.txt", "d.txt"})
+
+	fi, err := u.Stat("dir")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+
+	fi, err = u.Stat("a.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, int64(5))
+
+	_, err = u.Open("missing")
+	c.Assert(err, Equals, os.ErrNotExist)
+}
+
+func (s *UnionSuite) TestReadOnly(c *C) {
+	u := s.union(c, false)
+
+	_, err := u.Create("new.txt")
+	c.Assert(err, Equals, ErrReadOnlyFilesystem)
+
+	c.Assert(u.Remove("a.txt"), Equals, ErrReadOnlyFilesystem)
+	c.Assert(billy.Capabilities(u), Equals, billy.ReadCapability|billy.SeekCapability)
+}
+
+func (s *UnionSuite) TestWrite(c *C) {
+	u := s.union(c, true)
+
+	c.Assert(u.Remove("b.txt"), IsNil)
+	c.Assert(u.Remove("dir/c.txt"), IsNil)
+	writeFile(c, u, "e.txt", []byte("top-e"))
+
+	_, err := u.Stat("b.txt")
+	c.Assert(err, Equals, os.ErrNotExist)
+	_, err = u.Stat("dir")
+	c.Assert(err, Equals, os.ErrNotExist)
+	c.Assert(u.Remove("b.txt"), Equals, os.ErrNotExist)
+
+	c.Assert(u.Sync(), IsNil)
+
+	u = s.union(c, false)
+	files, err := u.ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(names(files), DeepEquals, []string{"a.txt", "d.txt", "e.txt"})
+
+	// the base layer is not modified
+	base := s.layer(c, "base.siva", true)
+	testFileContent(c, base, "b.txt", "base-b")
+
+	// files deleted in the union can be written again
+	u = s.union(c, true)
+	writeFile(c, u, "b.txt", []byte("top-b"))
+	testFileContent(c, u, "b.txt", "top-b")
+	c.Assert(u.Sync(), IsNil)
+
+	testFileContent(c, s.union(c, false), "b.txt", "top-b")
+}
+
+func (s *UnionSuite) TestTombstones(c *C) {
+	u := s.union(c, true)
+	c.Assert(u.Remove("b.txt"), IsNil)
+	c.Assert(u.Remove("dir/c.txt"), IsNil)
+	c.Assert(u.Sync(), IsNil)
+
+	// only dir/c.txt, sorting after every file of the top layer, needs an
+	// empty entry before its deletion.
+	blocks := readBlocks(c, s.mem, "top.siva")
+	var written []string
+	for _, e := range blocks[len(blocks)-1].Entries {
+		written = append(written, e.Name)
+	}
+
+	c.Assert(written, DeepEquals, []string{"b.txt", "dir/c.txt", "dir/c.txt"})
+}
+
+func (s *UnionSuite) TestCachedView(c *C) {
+	top := s.layer(c, "top.siva", false)
+	u, err := NewUnionWithOptions([]SivaFS{
+		s.layer(c, "base.siva", true),
+		top,
+	}, UnionOptions{Writable: true})
+	c.Assert(err, IsNil)
+
+	testFileContent(c, u, "a.txt", "top-a")
+
+	// changes made to the layers are seen by the union.
+	writeFile(c, top, "e.txt", []byte("top-e"))
+	testFileContent(c, u, "e.txt", "top-e")
+	c.Assert(u.Remove("b.txt"), IsNil)
+	_, err = u.Stat("b.txt")
+	c.Assert(err, Equals, os.ErrNotExist)
+
+	c.Assert(u.(SivaRollback).Rollback(), IsNil)
+	testFileContent(c, u, "b.txt", "base-b")
+	_, err = u.Stat("e.txt")
+	c.Assert(err, Equals, os.ErrNotExist)
+
+	writeFile(c, u, "e.txt", []byte("top-e"))
+	c.Assert(u.Sync(), IsNil)
+	testFileContent(c, u, "e.txt", "top-e")
+}
+
+func (s *UnionSuite) TestNested(c *C) {
+	inner := s.union(c, false)
+
+	extra := s.layer(c, "extra.siva", false)
+	writeFile(c, extra, "b.txt", []byte("extra-b"))
+	c.Assert(extra.Sync(), IsNil)
+
+	u, err := NewUnion(inner, s.layer(c, "extra.siva", true))
+	c.Assert(err, IsNil)
+
+	testFileContent(c, u, "a.txt", "top-a")
+	testFileContent(c, u, "b.txt", "extra-b")
+}
+
+func (s *UnionSuite) TestErrors(c *C) {
+	_, err := NewUnion()
+	c.Assert(err, Equals, ErrNoLayers)
+
+	_, err = NewUnion(nil)
+	c.Assert(err, Equals, ErrUnsupportedLayer)
+}
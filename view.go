@@ -0,0 +1,221 @@
+package sivafs
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"os"
+	"sort"
+	"syscall"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// indexView is a read only view of the files of several filesystems, built
+// from their indexes.
+type indexView struct {
+	// ctx cancels the scans of the index.
+	ctx   context.Context
+	index siva.OrderedIndex
+	// owners holds the filesystem storing each entry.
+	owners map[*siva.IndexEntry]billy.Basic
+}
+
+func newIndexView(ctx context.Context) *indexView {
+	return &indexView{
+		ctx:    ctx,
+		owners: make(map[*siva.IndexEntry]billy.Basic),
+	}
+}
+
+// add adds an entry stored in the given filesystem. The index must be sorted
+// afterwards.
+func (v *indexView) add(e *siva.IndexEntry, fs billy.Basic) {
+	v.index = append(v.index, e)
+	v.owners[e] = fs
+}
+
+func (v *indexView) sort() {
+	sort.Sort(v.index)
+}
+
+// owner returns the filesystem storing the file, or nil if it does not exist.
+func (v *indexView) owner(path string) billy.Basic {
+	e := v.index.Find(path)
+	if e == nil {
+		return nil
+	}
+
+	return v.owners[e]
+}
+
+func (v *indexView) open(path string, flag int, mode os.FileMode) (billy.File, error) {
+	fs := v.owner(path)
+	if fs == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return fs.OpenFile(path, flag, mode)
+}
+
+func (v *indexView) stat(path string) (os.FileInfo, error) {
+	if e := v.index.Find(path); e != nil {
+		return v.fileInfo(e)
+	}
+
+	dir, err := getDir(v.ctx, v.index, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return dir, nil
+}
+
+func (v *indexView) readDir(path string) ([]os.FileInfo, error) {
+	files, err := listFiles(v.ctx, v.index, path, v.fileInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, err := listDirs(v.ctx, v.index, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(dirs, files...), nil
+}
+
+func (v *indexView) bytes(path string) ([]byte, io.Closer, error) {
+	fs := v.owner(path)
+	if fs == nil {
+		return nil, nil, os.ErrNotExist
+	}
+
+	b, ok := fs.(SivaBytes)
+	if !ok {
+		return nil, nil, ErrNotMapped
+	}
+
+	return b.Bytes(path)
+}
+
+// mkdirAll checks that a directory can be created in the view. Directories
+// are implicit in siva files so there is nothing to create.
+func mkdirAll(v *indexView, path string) error {
+	if v.index.Find(path) != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	return nil
+}
+
+// checkRemove checks that the path is a file of the view that can be
+// removed.
+func checkRemove(v *indexView, path string) error {
+	if v.index.Find(path) != nil {
+		return nil
+	}
+
+	dir, err := getDir(v.ctx, v.index, path)
+	if err != nil {
+		return err
+	}
+
+	if dir != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+	}
+
+	return os.ErrNotExist
+}
+
+func (v *indexView) openRaw(path string) (*siva.IndexEntry, io.Reader, error) {
+	fs := v.owner(path)
+	if fs == nil {
+		return nil, nil, os.ErrNotExist
+	}
+
+	return fs.(indexedFS).openRaw(path)
+}
+
+// hash returns the hash of the file with the given path, asking the
+// filesystem owning it in the view.
+func (v *indexView) hash(path string) (crypto.Hash, []byte, error) {
+	fs := v.owner(path)
+	if fs == nil {
+		return 0, nil, os.ErrNotExist
+	}
+
+	h, ok := fs.(SivaHash)
+	if !ok {
+		return 0, nil, ErrNoHash
+	}
+
+	return h.Hash(path)
+}
+
+// findByHash returns the paths with the given hash, asking each filesystem
+// for the files it owns in the view.
+func (v *indexView) findByHash(sum []byte) ([]string, error) {
+	var paths []string
+	asked := make(map[billy.Basic]bool)
+	for _, fs := range v.owners {
+		if asked[fs] {
+			continue
+		}
+
+		asked[fs] = true
+		h, ok := fs.(SivaHash)
+		if !ok {
+			continue
+		}
+
+		found, err := h.FindByHash(sum)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range found {
+			if v.owner(p) == fs {
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// meta returns the filesystem owning the path, to access its metadata.
+func (v *indexView) meta(path string) (SivaMeta, error) {
+	fs := v.owner(path)
+	if fs == nil {
+		return nil, os.ErrNotExist
+	}
+
+	m, ok := fs.(SivaMeta)
+	if !ok {
+		return nil, billy.ErrNotSupported
+	}
+
+	return m, nil
+}
+
+// fileInfo returns the FileInfo of the entry. Entries read again from the
+// same siva file are looked up by name.
+func (v *indexView) fileInfo(e *siva.IndexEntry) (os.FileInfo, error) {
+	fs, ok := v.owners[e]
+	if !ok {
+		fs = v.owner(e.Name)
+	}
+
+	if fs == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return fs.(indexedFS).fileInfo(e)
+}
@@ -0,0 +1,21 @@
+module gopkg.in/src-d/go-billy-siva.v4/webdavfs
+
+require (
+	golang.org/x/net v0.21.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+	gopkg.in/src-d/go-billy-siva.v4 v4.0.0-00010101000000-000000000000
+	gopkg.in/src-d/go-billy.v4 v4.3.2
+)
+
+require (
+	github.com/golang/snappy v1.0.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/kr/pretty v0.2.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	gopkg.in/src-d/go-siva.v1 v1.7.0 // indirect
+)
+
+replace gopkg.in/src-d/go-billy-siva.v4 => ../
+
+go 1.22
@@ -0,0 +1,647 @@
+// Package webdavfs exposes a siva filesystem as a golang.org/x/net/webdav
+// FileSystem, so archives can be browsed and edited by desktop clients.
+package webdavfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+var (
+	ErrIsDirectory  = errors.New("is a directory")
+	ErrNotDirectory = errors.New("not a directory")
+)
+
+type fileSystem struct {
+	mu sync.Mutex
+
+	fs sivafs.SivaFS
+	// dirs holds the directories created with Mkdir. Siva files do not
+	// store directories, so empty ones only live in memory until a file
+	// is written inside them.
+	dirs map[string]time.Time
+
+	open  int
+	dirty bool
+}
+
+// New returns a webdav.FileSystem backed by the given siva filesystem.
+//
+// Files opened for writing are buffered in memory and written as a single
+// siva entry when they are closed. Changes are synced to the siva file as
+// soon as there are no open files left, as syncing closes the readers of the
+// underlying filesystem.
+func New(fs sivafs.SivaFS) webdav.FileSystem {
+	return &fileSystem{
+		fs:   fs,
+		dirs: make(map[string]time.Time),
+	}
+}
+
+func (d *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = cleanPath(name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.stat(name); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	parent, err := d.stat(path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	if !parent.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrNotDirectory}
+	}
+
+	if err := d.fs.MkdirAll(name, perm); err != nil {
+		return err
+	}
+
+	d.dirs[name] = time.Now()
+	return nil
+}
+
+func (d *fileSystem) OpenFile(
+	ctx context.Context,
+	name string,
+	flag int,
+	perm os.FileMode,
+) (webdav.File, error) {
+	name = cleanPath(name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return d.openWrite(name, flag, perm)
+	}
+
+	fi, err := d.stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		d.open++
+		return &dir{d: d, name: name, fi: fi}, nil
+	}
+
+	return d.openRead(name, fi, flag)
+}
+
+func (d *fileSystem) openWrite(name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fi, err := d.stat(name)
+	switch {
+	case err == nil && fi.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDirectory}
+	case err == nil && flag&os.O_TRUNC == 0:
+		return d.openRead(name, fi, flag)
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+
+	if err != nil {
+		parent, err := d.stat(path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+
+		if !parent.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotDirectory}
+		}
+	}
+
+	d.open++
+	return &writeFile{d: d, name: name, mode: perm}, nil
+}
+
+// openRead opens an existing file without truncating it. Siva entries cannot
+// be changed in place, so writes fail, but O_RDWR opens are still allowed as
+// webdav uses them to patch the properties of files.
+func (d *fileSystem) openRead(name string, fi os.FileInfo, flag int) (webdav.File, error) {
+	if flag&os.O_WRONLY != 0 {
+		return nil, billy.ErrNotSupported
+	}
+
+	f, err := d.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d.open++
+	return &readFile{File: f, d: d, name: name, fi: fi}, nil
+}
+
+func (d *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = cleanPath(name)
+	if name == "/" {
+		return os.ErrInvalid
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fi, err := d.stat(name)
+	if err != nil {
+		return err
+	}
+
+	if err := d.removeAll(name, fi); err != nil {
+		return err
+	}
+
+	return d.syncIfIdle()
+}
+
+func (d *fileSystem) removeAll(name string, fi os.FileInfo) error {
+	if !fi.IsDir() {
+		d.dirty = true
+		return d.fs.Remove(name)
+	}
+
+	children, err := d.readDir(name)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range children {
+		if err := d.removeAll(path.Join(name, c.Name()), c); err != nil {
+			return err
+		}
+	}
+
+	delete(d.dirs, name)
+	return nil
+}
+
+// Rename copies the files to their new location and removes the old ones, as
+// siva files do not support renaming entries.
+func (d *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName = cleanPath(oldName)
+	newName = cleanPath(newName)
+	if oldName == "/" || newName == "/" {
+		return os.ErrInvalid
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	if strings.HasPrefix(newName, oldName+"/") {
+		return os.ErrInvalid
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fi, err := d.stat(oldName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.stat(newName); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := d.copy(oldName, newName, fi); err != nil {
+		return err
+	}
+
+	if err := d.removeAll(oldName, fi); err != nil {
+		return err
+	}
+
+	return d.syncIfIdle()
+}
+
+func (d *fileSystem) copy(from, to string, fi os.FileInfo) error {
+	if !fi.IsDir() {
+		d.dirty = true
+		return copyFile(d.fs, from, to, fi.Mode())
+	}
+
+	children, err := d.readDir(from)
+	if err != nil {
+		return err
+	}
+
+	if len(children) == 0 {
+		d.dirs[to] = time.Now()
+	}
+
+	for _, c := range children {
+		err := d.copy(path.Join(from, c.Name()), path.Join(to, c.Name()), c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = cleanPath(name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.stat(name)
+}
+
+func (d *fileSystem) stat(name string) (os.FileInfo, error) {
+	if name == "/" {
+		return &dirInfo{name: "/"}, nil
+	}
+
+	fi, err := d.fs.Stat(name)
+	if err == nil {
+		if fi.IsDir() {
+			delete(d.dirs, name)
+		}
+
+		return fi, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	modTime, ok := d.dirs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return &dirInfo{name: path.Base(name), modTime: modTime}, nil
+}
+
+func (d *fileSystem) readDir(name string) ([]os.FileInfo, error) {
+	files, err := d.fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, fi := range files {
+		seen[fi.Name()] = true
+	}
+
+	var empty []string
+	for dir := range d.dirs {
+		if path.Dir(dir) == name && !seen[path.Base(dir)] {
+			empty = append(empty, dir)
+		}
+	}
+
+	sort.Strings(empty)
+	for _, dir := range empty {
+		files = append(files, &dirInfo{name: path.Base(dir), modTime: d.dirs[dir]})
+	}
+
+	return files, nil
+}
+
+func (d *fileSystem) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.open--
+	return d.syncIfIdle()
+}
+
+// syncIfIdle writes the pending changes to the siva file when there are no
+// open files. It must be called with the lock held.
+func (d *fileSystem) syncIfIdle() error {
+	if !d.dirty || d.open > 0 {
+		return nil
+	}
+
+	d.dirty = false
+	return d.fs.Sync()
+}
+
+func copyFile(fs billy.Filesystem, from, to string, mode os.FileMode) error {
+	src, err := fs.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := fs.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// cleanPath returns the name as an absolute and clean slash separated path.
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// propPrefix is the prefix of the metadata keys holding dead properties.
+const propPrefix = "webdav:"
+
+func propKey(name xml.Name) string {
+	return propPrefix + name.Space + " " + name.Local
+}
+
+type readFile struct {
+	billy.File
+
+	d    *fileSystem
+	name string
+	fi   os.FileInfo
+}
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, ErrNotDirectory
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, sivafs.ErrReadOnlyFile
+}
+
+// DeadProps implements webdav.DeadPropsHolder interface. Dead properties are
+// stored in the metadata of the file, see sivafs.SivaMeta.
+func (f *readFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := make(map[xml.Name]webdav.Property)
+	m, ok := f.d.fs.(sivafs.SivaMeta)
+	if !ok {
+		return props, nil
+	}
+
+	f.d.mu.Lock()
+	meta, err := m.ListMeta(f.name)
+	f.d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range meta {
+		if !strings.HasPrefix(key, propPrefix) {
+			continue
+		}
+
+		var p webdav.Property
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			return nil, err
+		}
+
+		props[p.XMLName] = p
+	}
+
+	return props, nil
+}
+
+// Patch implements webdav.DeadPropsHolder interface.
+func (f *readFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	var props []webdav.Property
+	for _, patch := range patches {
+		props = append(props, patch.Props...)
+	}
+
+	m, ok := f.d.fs.(sivafs.SivaMeta)
+	if !ok {
+		return []webdav.Propstat{{Status: http.StatusForbidden, Props: props}}, nil
+	}
+
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	f.d.dirty = true
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			key := propKey(p.XMLName)
+			if patch.Remove {
+				if err := m.RemoveMeta(f.name, key); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			value, err := json.Marshal(p)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := m.SetMeta(f.name, key, string(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return []webdav.Propstat{{Status: http.StatusOK, Props: props}}, nil
+}
+
+func (f *readFile) Close() error {
+	err := f.File.Close()
+	if cerr := f.d.close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+type writeFile struct {
+	d      *fileSystem
+	name   string
+	mode   os.FileMode
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	return 0, sivafs.ErrWriteOnlyFile
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, sivafs.ErrNonSeekableFile
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, ErrNotDirectory
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	return &bufferInfo{
+		name:    path.Base(f.name),
+		size:    int64(f.buf.Len()),
+		mode:    f.mode,
+		modTime: time.Now(),
+	}, nil
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.buf.Write(p)
+}
+
+// Close writes the buffered contents as a single siva entry.
+func (f *writeFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	f.d.open--
+	f.d.dirty = true
+	if err := f.write(); err != nil {
+		return err
+	}
+
+	return f.d.syncIfIdle()
+}
+
+func (f *writeFile) write() error {
+	w, err := f.d.fs.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(f.buf.Bytes()); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+type dir struct {
+	d    *fileSystem
+	name string
+	fi   os.FileInfo
+
+	files  []os.FileInfo
+	read   bool
+	closed bool
+}
+
+func (f *dir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.name, Err: ErrIsDirectory}
+}
+
+func (f *dir) Seek(offset int64, whence int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrIsDirectory}
+}
+
+func (f *dir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: ErrIsDirectory}
+}
+
+func (f *dir) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+
+	if !f.read {
+		f.d.mu.Lock()
+		files, err := f.d.readDir(f.name)
+		f.d.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		f.files = files
+		f.read = true
+	}
+
+	if count <= 0 {
+		files := f.files
+		f.files = nil
+		return files, nil
+	}
+
+	if len(f.files) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(f.files) {
+		count = len(f.files)
+	}
+
+	files := f.files[:count]
+	f.files = f.files[count:]
+	return files, nil
+}
+
+func (f *dir) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *dir) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+	return f.d.close()
+}
+
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi *dirInfo) Name() string       { return fi.name }
+func (fi *dirInfo) Size() int64        { return 0 }
+func (fi *dirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (fi *dirInfo) ModTime() time.Time { return fi.modTime }
+func (fi *dirInfo) IsDir() bool        { return true }
+func (fi *dirInfo) Sys() interface{}   { return nil }
+
+type bufferInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *bufferInfo) Name() string       { return fi.name }
+func (fi *bufferInfo) Size() int64        { return fi.size }
+func (fi *bufferInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *bufferInfo) ModTime() time.Time { return fi.modTime }
+func (fi *bufferInfo) IsDir() bool        { return false }
+func (fi *bufferInfo) Sys() interface{}   { return nil }
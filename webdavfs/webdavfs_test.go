@@ -0,0 +1,196 @@
+package webdavfs
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy-siva.v4"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type WebDAVSuite struct {
+	underlying billy.Filesystem
+	fs         sivafs.SivaFS
+	server     *httptest.Server
+}
+
+var _ = Suite(&WebDAVSuite{})
+
+func (s *WebDAVSuite) SetUpTest(c *C) {
+	var err error
+	s.underlying = memfs.New()
+	s.fs, err = sivafs.NewFilesystem(s.underlying, "test.siva", memfs.New())
+	c.Assert(err, IsNil)
+
+	s.server = httptest.NewServer(&webdav.Handler{
+		FileSystem: New(s.fs),
+		LockSystem: webdav.NewMemLS(),
+	})
+}
+
+func (s *WebDAVSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *WebDAVSuite) do(c *C, method, path, body string, headers ...string) (int, string) {
+	req, err := http.NewRequest(method, s.server.URL+path, strings.NewReader(body))
+	c.Assert(err, IsNil)
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+
+	return res.StatusCode, string(data)
+}
+
+func (s *WebDAVSuite) reopen(c *C) sivafs.SivaFS {
+	fs, err := sivafs.NewFilesystemReadOnly(s.underlying, "test.siva", 0)
+	c.Assert(err, IsNil)
+	return fs
+}
+
+func (s *WebDAVSuite) TestPutGet(c *C) {
+	code, _ := s.do(c, "MKCOL", "/dir", "")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "PUT", "/dir/foo.txt", "hello")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, body := s.do(c, "GET", "/dir/foo.txt", "")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "hello")
+
+	data, err := readAll(s.reopen(c), "dir/foo.txt")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "hello")
+}
+
+func (s *WebDAVSuite) TestPutMissingParent(c *C) {
+	_, err := New(s.fs).OpenFile(context.Background(), "/dir/foo.txt",
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	code, _ := s.do(c, "PUT", "/dir/foo.txt", "hello")
+	c.Assert(code, Not(Equals), http.StatusCreated)
+
+	code, _ = s.do(c, "PUT", "/foo.txt", "hello")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "PUT", "/foo.txt/bar.txt", "hello")
+	c.Assert(code, Not(Equals), http.StatusCreated)
+}
+
+func (s *WebDAVSuite) TestProppatch(c *C) {
+	code, _ := s.do(c, "PUT", "/file.txt", "data")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, body := s.do(c, "PROPPATCH", "/file.txt", `<?xml version="1.0"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test">
+  <D:set><D:prop><Z:color>blue</Z:color></D:prop></D:set>
+</D:propertyupdate>`)
+	c.Assert(code, Equals, http.StatusMultiStatus)
+	c.Assert(strings.Contains(body, "200 OK"), Equals, true)
+
+	code, body = s.do(c, "PROPFIND", "/file.txt", `<?xml version="1.0"?>
+<D:propfind xmlns:D="DAV:" xmlns:Z="urn:test">
+  <D:prop><Z:color/></D:prop>
+</D:propfind>`, "Depth", "0")
+	c.Assert(code, Equals, http.StatusMultiStatus)
+	c.Assert(strings.Contains(body, "blue"), Equals, true)
+
+	data, err := readAll(s.reopen(c), "file.txt")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+}
+
+func (s *WebDAVSuite) TestMkcolPropfind(c *C) {
+	code, _ := s.do(c, "MKCOL", "/empty", "")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "MKCOL", "/empty", "")
+	c.Assert(code, Equals, http.StatusMethodNotAllowed)
+
+	code, _ = s.do(c, "PUT", "/file.txt", "data")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, body := s.do(c, "PROPFIND", "/", "", "Depth", "1")
+	c.Assert(code, Equals, http.StatusMultiStatus)
+	c.Assert(strings.Contains(body, "/empty/"), Equals, true)
+	c.Assert(strings.Contains(body, "/file.txt"), Equals, true)
+}
+
+func (s *WebDAVSuite) TestMove(c *C) {
+	code, _ := s.do(c, "MKCOL", "/a", "")
+	c.Assert(code, Equals, http.StatusCreated)
+	code, _ = s.do(c, "MKCOL", "/a/b", "")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "PUT", "/a/one.txt", "1")
+	c.Assert(code, Equals, http.StatusCreated)
+	code, _ = s.do(c, "PUT", "/a/b/two.txt", "2")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "MOVE", "/a", "", "Destination", s.server.URL+"/c")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "GET", "/a/one.txt", "")
+	c.Assert(code, Equals, http.StatusNotFound)
+
+	fs := s.reopen(c)
+	data, err := readAll(fs, "c/one.txt")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "1")
+
+	data, err = readAll(fs, "c/b/two.txt")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "2")
+}
+
+func (s *WebDAVSuite) TestDelete(c *C) {
+	code, _ := s.do(c, "MKCOL", "/a", "")
+	c.Assert(code, Equals, http.StatusCreated)
+	code, _ = s.do(c, "MKCOL", "/a/b", "")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "PUT", "/a/one.txt", "1")
+	c.Assert(code, Equals, http.StatusCreated)
+	code, _ = s.do(c, "PUT", "/a/b/two.txt", "2")
+	c.Assert(code, Equals, http.StatusCreated)
+	code, _ = s.do(c, "PUT", "/keep.txt", "3")
+	c.Assert(code, Equals, http.StatusCreated)
+
+	code, _ = s.do(c, "DELETE", "/a", "")
+	c.Assert(code, Equals, http.StatusNoContent)
+
+	files, err := s.reopen(c).ReadDir("/")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "keep.txt")
+}
+
+func readAll(fs billy.Filesystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
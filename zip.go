@@ -0,0 +1,115 @@
+package sivafs
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-siva.v1"
+)
+
+// ImportZip writes the contents of the zip archive read from r, which holds
+// size bytes, into fs. Each zip entry is streamed into a new file keeping its
+// mode and modification time. Directories are implicit in siva files so they
+// are only created for filesystems supporting them, except the root one.
+// Entries with absolute paths or paths pointing outside of the root are
+// rejected with ErrUnsafePath, protecting against zip slip attacks.
+//
+// Sync must be called afterwards to write the index of the siva file.
+func ImportZip(r io.ReaderAt, size int64, fs SivaFS) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		if err := importZipEntry(fs, zf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func importZipEntry(fs SivaFS, zf *zip.File) error {
+	mode := zf.Mode()
+	isDir := mode.IsDir() || strings.HasSuffix(zf.Name, "/")
+	if isDir && isRoot(zf.Name) {
+		return nil
+	}
+
+	name, err := safePath("import", zf.Name)
+	if err != nil {
+		return err
+	}
+
+	if isDir {
+		return fs.MkdirAll(name, mode.Perm())
+	}
+
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return copyToFile(fs, &siva.Header{
+		Name:    name,
+		Mode:    mode,
+		ModTime: zf.Modified,
+	}, r)
+}
+
+// ExportZip writes the files and directories of fs to w as a zip archive.
+// Files are compressed with deflate and streamed one at a time, only their
+// headers are kept in memory to write the central directory. To export the
+// contents of an older index use a read only filesystem with its offset, see
+// NewFilesystemReadOnly.
+func ExportZip(fs SivaFS, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := walk(fs, "", func(name string, fi os.FileInfo) error {
+		return exportZipEntry(fs, zw, name, fi)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func exportZipEntry(fs SivaFS, zw *zip.Writer, name string, fi os.FileInfo) error {
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = name
+	hdr.Modified = fi.ModTime()
+	if fi.IsDir() {
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+		if fi.Mode().Perm() == 0 {
+			hdr.SetMode(fi.Mode() | 0755)
+		}
+
+		_, err := zw.CreateHeader(hdr)
+		return err
+	}
+
+	hdr.Method = zip.Deflate
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
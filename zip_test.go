@@ -0,0 +1,146 @@
+package sivafs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ZipSuite struct{}
+
+var _ = Suite(&ZipSuite{})
+
+type zipEntry struct {
+	name    string
+	mode    os.FileMode
+	content string
+}
+
+func buildZip(c *C, modTime time.Time, entries []zipEntry) *bytes.Reader {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: modTime}
+		hdr.SetMode(e.mode)
+
+		w, err := zw.CreateHeader(hdr)
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte(e.content))
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(zw.Close(), IsNil)
+	return bytes.NewReader(buf.Bytes())
+}
+
+func (s *ZipSuite) TestImportExport(c *C) {
+	modTime := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	r := buildZip(c, modTime, []zipEntry{
+		{"dir/", os.ModeDir | 0755, ""},
+		{"dir/one.txt", 0640, "one"},
+		{"two.txt", 0600, "two"},
+		{"link", os.ModeSymlink | 0777, "two.txt"},
+	})
+
+	fs := newTestFilesystem(c)
+	c.Assert(ImportZip(r, r.Size(), fs), IsNil)
+	c.Assert(fs.Sync(), IsNil)
+
+	fi, err := fs.Stat("dir/one.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+	c.Assert(fi.ModTime().Equal(modTime), Equals, true)
+	testFileContent(c, fs, "dir/one.txt", "one")
+
+	fi, err = fs.Stat("link")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.ModeSymlink|0777)
+
+	out := bytes.NewBuffer(nil)
+	c.Assert(ExportZip(fs, out), IsNil)
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	c.Assert(err, IsNil)
+
+	expected := []zipEntry{
+		{"dir/", os.ModeDir | 0755, ""},
+		{"dir/one.txt", 0640, "one"},
+		{"link", os.ModeSymlink | 0777, "two.txt"},
+		{"two.txt", 0600, "two"},
+	}
+
+	c.Assert(zr.File, HasLen, len(expected))
+	for i, e := range expected {
+		zf := zr.File[i]
+		c.Assert(zf.Name, Equals, e.name)
+		c.Assert(zf.Mode(), Equals, e.mode)
+		c.Assert(zf.Modified.Equal(modTime), Equals, true)
+
+		rc, err := zf.Open()
+		c.Assert(err, IsNil)
+		content, err := ioutil.ReadAll(rc)
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Equals, e.content)
+		c.Assert(rc.Close(), IsNil)
+	}
+}
+
+func (s *ZipSuite) TestImportZipSlip(c *C) {
+	for _, name := range []string{"subdir/../../imoutside", "/absolute"} {
+		r := buildZip(c, time.Now(), []zipEntry{{name, 0644, "x"}})
+
+		fs := newTestFilesystem(c)
+		err := ImportZip(r, r.Size(), fs)
+		c.Assert(err, NotNil)
+		c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+
+		files, err := fs.ReadDir("/")
+		c.Assert(err, IsNil)
+		c.Assert(files, HasLen, 0)
+	}
+}
+
+func (s *ZipSuite) TestImportDot(c *C) {
+	r := buildZip(c, time.Now(), []zipEntry{
+		{"./", os.ModeDir | 0755, ""},
+		{"./dir/a", 0644, "a"},
+	})
+
+	fs := newTestFilesystem(c)
+	c.Assert(ImportZip(r, r.Size(), fs), IsNil)
+	testFileContent(c, fs, "dir/a", "a")
+
+	r = buildZip(c, time.Now(), []zipEntry{{".", 0644, "x"}})
+	err := ImportZip(r, r.Size(), newTestFilesystem(c))
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+}
+
+func (s *ZipSuite) TestExportZipSlipFixture(c *C) {
+	fs := fixtures[1].FS(c, true).(SivaFS)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(ExportZip(fs, buf), IsNil)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, IsNil)
+	c.Assert(zr.File, HasLen, 1)
+	c.Assert(zr.File[0].Name, Equals, "imoutside")
+
+	imported := newTestFilesystem(c)
+	c.Assert(ImportZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), imported), IsNil)
+	expected, err := fs.Stat("imoutside")
+	c.Assert(err, IsNil)
+	fi, err := imported.Stat("imoutside")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Size(), Equals, expected.Size())
+
+	unsafe := fixtures[2].FS(c, true).(SivaFS)
+	err = ExportZip(unsafe, ioutil.Discard)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*os.PathError).Err, Equals, ErrUnsafePath)
+}